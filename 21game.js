@@ -1,30 +1,70 @@
 // 21点游戏 - WebSocket客户端
 
+// 客户端支持的协议版本（见 /api/protocol）
+const PROTOCOL_VERSIONS = [3];
+
 class BlackjackGame {
     constructor() {
         this.ws = null;
-        this.playerId = this.getOrCreatePlayerId(); // 获取或生成唯一玩家ID
+        this.playerId = null; // 服务器签发的玩家ID（见 POST /api/guest）
+        this.token = null; // 会话令牌，connect 时验证身份
+        this.loadIdentity();
         this.nickname = '玩家' + Math.floor(Math.random() * 1000);
         this.roomId = null;
         this.reconnectAttempts = 0;
         this.maxReconnectAttempts = 5;
         this.isHost = false; // 是否是房主
         this.gameStarted = false; // 游戏是否已开始
+        this.replayRound = null; // 回放的局数（观众模式）
+        this.requestSeq = 0; // 请求序号，用于生成 requestId
+        this.stateVersion = 0; // 已处理的房间状态版本
+        this.resyncRequestId = null; // 进行中的补发请求
+        this.players = []; // 牌局中各座位的状态，由完整状态和增量消息维护
+        this.roomPlayers = []; // 等待中的房间玩家列表（私聊按昵称查找玩家）
+        this.emotes = {}; // 表情目录，按ID索引（见 /api/emotes）
+        this.emoteCooldownMs = 0;
+        this.emotesMuted = localStorage.getItem('blackjack_mute_emotes') === '1';
 
         this.init();
     }
 
-    // 获取或创建唯一的玩家ID（使用sessionStorage，刷新页面会保留，关闭标签页会清除）
-    getOrCreatePlayerId() {
-        let playerId = sessionStorage.getItem('blackjack_player_id');
-        if (!playerId) {
-            const timestamp = Date.now();
-            const random = Math.floor(Math.random() * 1000000);
-            playerId = `player_${timestamp}_${random}`;
-            sessionStorage.setItem('blackjack_player_id', playerId);
+    // 读取保存的身份：优先使用登录的账号（localStorage），
+    // 否则使用游客身份（sessionStorage，刷新页面会保留，关闭标签页会清除）
+    loadIdentity() {
+        const saved = JSON.parse(localStorage.getItem('blackjack_account') || 'null')
+            || JSON.parse(sessionStorage.getItem('blackjack_identity') || 'null');
+        if (saved) {
+            this.playerId = saved.playerId;
+            this.token = saved.token;
+        }
+    }
+
+    // 没有身份时向服务器申请游客身份
+    async ensureIdentity() {
+        if (this.token) {
+            return;
+        }
+        const response = await fetch('/api/guest', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ nickname: this.nickname })
+        });
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error);
         }
-        console.log('playerId', playerId);
-        return playerId;
+        this.playerId = data.playerId;
+        this.token = data.token;
+        sessionStorage.setItem('blackjack_identity', JSON.stringify({ playerId: data.playerId, token: data.token }));
+        console.log('playerId', this.playerId);
+    }
+
+    // 身份失效（如服务器数据被清除）时丢弃，重连时重新申请
+    clearIdentity() {
+        this.playerId = null;
+        this.token = null;
+        localStorage.removeItem('blackjack_account');
+        sessionStorage.removeItem('blackjack_identity');
     }
 
     init() {
@@ -32,6 +72,8 @@ class BlackjackGame {
         const urlParams = new URLSearchParams(window.location.search);
         this.roomId = urlParams.get('roomId');
         const urlNickname = urlParams.get('nickname');
+        this.replayRound = parseInt(urlParams.get('replay'), 10) || null;
+        this.replaySpeed = parseFloat(urlParams.get('speed')) || 1;
 
         if (!this.roomId) {
             alert('房间ID不存在');
@@ -56,16 +98,79 @@ class BlackjackGame {
             if (e.key === 'Enter') this.sendMessage();
         });
 
+        // 表情可以单独屏蔽，不影响聊天
+        const muteEmotes = document.getElementById('mute-emotes');
+        muteEmotes.checked = this.emotesMuted;
+        muteEmotes.addEventListener('change', () => {
+            this.emotesMuted = muteEmotes.checked;
+            localStorage.setItem('blackjack_mute_emotes', this.emotesMuted ? '1' : '0');
+        });
+        this.loadEmotes();
+
         // 连接WebSocket
         this.connect();
     }
 
+    // 加载服务器定义的表情目录并生成表情按钮（回放时观众不能发送）
+    loadEmotes() {
+        fetch(`/api/emotes?lang=${encodeURIComponent(navigator.language)}`)
+            .then(res => res.json())
+            .then(data => {
+                this.emoteCooldownMs = data.cooldownMs;
+                const buttons = document.getElementById('emote-buttons');
+                data.emotes.forEach(emote => {
+                    this.emotes[emote.id] = emote;
+                    if (this.replayRound) {
+                        return;
+                    }
+                    const button = document.createElement('button');
+                    button.textContent = emote.icon;
+                    button.title = emote.text;
+                    button.addEventListener('click', () => this.sendEmote(emote.id));
+                    buttons.appendChild(button);
+                });
+            })
+            .catch(err => console.error('❌ 加载表情失败:', err));
+    }
+
+    sendEmote(emoteId) {
+        this.send({ type: 'emote', data: { roomId: this.roomId, playerId: this.playerId, emote: emoteId } });
+
+        // 冷却期间禁用表情按钮
+        const buttons = document.querySelectorAll('#emote-buttons button');
+        buttons.forEach(button => button.disabled = true);
+        setTimeout(() => buttons.forEach(button => button.disabled = false), this.emoteCooldownMs);
+    }
+
+    showEmote(data) {
+        if (this.emotesMuted) {
+            return;
+        }
+        const emote = this.emotes[data.emote];
+        const bubble = document.createElement('span');
+        bubble.className = 'emote-bubble';
+        bubble.textContent = `${data.nickname} ${data.icon} ${emote ? emote.text : ''}`;
+        bubble.addEventListener('animationend', () => bubble.remove());
+        document.getElementById('emote-feed').appendChild(bubble);
+    }
+
     startGame() {
         // 发送开始游戏请求
-        this.send({ type: 'start', data: { roomId: this.roomId, playerId: this.playerId } });
+        this.sendAction('start');
     }
 
     connect() {
+        // 回放时也需要身份（服务器只为验证过的连接回放）
+        if (!this.token) {
+            this.ensureIdentity()
+                .then(() => this.connect())
+                .catch(err => {
+                    console.error('❌ 获取游客身份失败:', err);
+                    this.updateStatus('获取游客身份失败: ' + err.message, 'red');
+                });
+            return;
+        }
+
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
         const wsUrl = `${protocol}//${window.location.host}/ws`;
 
@@ -76,8 +181,17 @@ class BlackjackGame {
             this.reconnectAttempts = 0;
             this.updateStatus('已连接', 'green');
 
+            // 先握手协商协议版本
+            this.send({ type: 'hello', data: { versions: PROTOCOL_VERSIONS, client: 'web', locale: navigator.language } });
+
             // 发送连接消息
-            this.send({ type: 'connect', data: { playerId: this.playerId, nickname: this.nickname } });
+            this.send({ type: 'connect', data: { playerId: this.playerId, token: this.token, nickname: this.nickname } });
+
+            // 回放模式：只作为观众接收牌局消息
+            if (this.replayRound) {
+                this.send({ type: 'replay', data: { roomId: this.roomId, round: this.replayRound, speed: this.replaySpeed } });
+                return;
+            }
 
             // 加入房间
             this.send({ type: 'join', data: { roomId: this.roomId, playerId: this.playerId, nickname: this.nickname } });
@@ -110,6 +224,39 @@ class BlackjackGame {
         }
     }
 
+    nextRequestId() {
+        return `${this.playerId}-${Date.now()}-${++this.requestSeq}`;
+    }
+
+    // 发送游戏操作，带上 requestId，服务器对同一请求本局内只执行一次
+    sendAction(type) {
+        this.send({ type, requestId: this.nextRequestId(), data: { roomId: this.roomId, playerId: this.playerId } });
+    }
+
+    // 检查广播消息的状态版本：重复的跳过，断档时请求补发并丢弃当前消息
+    acceptVersion(message) {
+        if (!message.version || this.replayRound || message.type === 'snapshot') {
+            return true;
+        }
+        if (message.version <= this.stateVersion) {
+            return false;
+        }
+        if (message.version > this.stateVersion + 1) {
+            if (!this.resyncRequestId) {
+                console.warn(`⚠️ 状态版本断档 ${this.stateVersion} → ${message.version}，请求补发`);
+                this.resyncRequestId = this.nextRequestId();
+                this.send({
+                    type: 'resync',
+                    requestId: this.resyncRequestId,
+                    data: { roomId: this.roomId, playerId: this.playerId, sinceVersion: this.stateVersion }
+                });
+            }
+            return false;
+        }
+        this.stateVersion = message.version;
+        return true;
+    }
+
     send(message) {
         if (this.ws && this.ws.readyState === WebSocket.OPEN) {
             this.ws.send(JSON.stringify(message));
@@ -121,7 +268,33 @@ class BlackjackGame {
     handleMessage(message) {
         console.log('📨 收到消息:', message);
 
+        if (!this.acceptVersion(message)) {
+            return;
+        }
+        if (message.requestId && message.requestId === this.resyncRequestId) {
+            this.resyncRequestId = null;
+        }
+
         switch (message.type) {
+            case 'ack':
+                if (message.data.duplicate) {
+                    console.log('🔁 重复请求已忽略:', message.requestId);
+                }
+                break;
+
+            case 'snapshot':
+                // 补发的完整状态：以快照版本为准重建界面
+                this.stateVersion = message.version;
+                if (message.data.status === 1 && !this.gameStarted) {
+                    this.handleMessage({ type: 'start', data: { roomId: message.data.roomId } });
+                }
+                this.handleMessage({ type: 'players', data: { players: message.data.players } });
+                break;
+
+            case 'hello':
+                console.log('🤝 协议版本:', message.data.version);
+                break;
+
             case 'connect':
                 console.log('✅ 已连接，玩家ID:', message.data.playerId);
                 break;
@@ -135,10 +308,12 @@ class BlackjackGame {
 
             case 'roomInfo':
                 console.log('🏠 房间信息:', message.data);
-                // 如果游戏已经开始，提示用户
-                if (message.data.status === 1) { // GamePlaying
-                    alert('游戏已经开始，无法加入！');
-                    window.location.href = '21dian.html';
+                // 加入或重新连接后以房间当前版本为起点（服务器重启后版本会重新开始）
+                this.stateVersion = message.data.version || 0;
+                this.resyncRequestId = null;
+                // 游戏进行中收到房间信息说明是重新连接回自己的座位，恢复游戏界面
+                if (message.data.status === 1 && !this.replayRound) { // GamePlaying
+                    this.handleMessage({ type: 'start', data: { roomId: message.data.roomId } });
                 }
                 break;
 
@@ -152,19 +327,33 @@ class BlackjackGame {
                 }
                 break;
 
-            case 'update':
-                this.updatePlayer(message.data);
+            case 'cardDealt':
+                this.applyCardDealt(message.data);
+                break;
+
+            case 'statusChanged':
+                this.applyStatusChanged(message.data);
                 break;
 
             case 'chat':
                 this.addChatMessage(message.data);
                 break;
 
+            case 'emote':
+                this.showEmote(message.data);
+                break;
+
+            case 'chatHistory':
+                // 加入或重连后收到最近的聊天记录，替换已显示的内容
+                document.getElementById('chat-messages').innerHTML = '';
+                message.data.messages.forEach(msg => this.addChatMessage(msg));
+                break;
+
             case 'start':
                 console.log('🎮 游戏开始');
                 this.gameStarted = true;
-                this.updateStatus('游戏进行中', 'yellow');
-                this.enableButtons(true);
+                this.updateStatus(this.replayRound ? '牌局回放中' : '游戏进行中', 'yellow');
+                this.enableButtons(!this.replayRound);
                 // 隐藏等待区域，显示游戏区域
                 document.getElementById('waiting-area').style.display = 'none';
                 document.getElementById('players').style.display = 'block';
@@ -175,8 +364,35 @@ class BlackjackGame {
                 this.handleGameEnd(message.data);
                 break;
 
+            case 'serverShutdown':
+                this.updateStatus(message.data.message, 'red');
+                break;
+
+            case 'replay':
+                console.log('📼 回放结束:', message.data.handId);
+                break;
+
             case 'error':
-                console.error('❌ 错误:', message.error);
+                console.error('❌ 错误:', message.code, message.error, message.fields || '');
+                // 限流和禁言提示显示在聊天区，不弹窗打断游戏
+                if (message.code === 'RATE_LIMITED' || message.code === 'CHAT_MUTED' || message.code === 'EMOTE_COOLDOWN') {
+                    this.addChatMessage({ kind: 'system', message: message.error });
+                    break;
+                }
+                if (message.code === 'UNAUTHORIZED') {
+                    // 关闭连接后自动重连，重连前重新申请身份
+                    this.clearIdentity();
+                    this.ws.close();
+                    break;
+                }
+                if (message.code === 'NOT_AUTHENTICATED') {
+                    // connect 失败后紧跟着的请求，正在重新申请身份
+                    break;
+                }
+                if (message.code === 'INVALID_WHISPER_TARGET') {
+                    this.addChatMessage({ kind: 'system', message: message.error });
+                    break;
+                }
                 alert('错误: ' + message.error);
                 break;
 
@@ -186,24 +402,41 @@ class BlackjackGame {
     }
 
     hit() {
-        this.send({ type: 'hit', data: { roomId: this.roomId, playerId: this.playerId } });
+        this.sendAction('hit');
     }
 
     stand() {
-        this.send({ type: 'stand', data: { roomId: this.roomId, playerId: this.playerId } });
+        this.sendAction('stand');
     }
 
     sendMessage() {
         const input = document.getElementById('message');
         const message = input.value.trim();
 
-        if (message) {
-            this.send({ type: 'chat', data: { roomId: this.roomId, playerId: this.playerId, message: message } });
-            input.value = '';
+        if (!message) {
+            return;
+        }
+
+        // "/w 昵称 内容" 私聊同房间的玩家
+        const data = { roomId: this.roomId, playerId: this.playerId, message: message };
+        const whisper = message.match(/^\/w\s+(\S+)\s+(.+)$/);
+        if (whisper) {
+            const players = this.gameStarted ? this.players : this.roomPlayers;
+            const target = players.find(p => p.nickname === whisper[1] || p.id === whisper[1]);
+            if (!target) {
+                this.addChatMessage({ kind: 'system', message: `找不到玩家 ${whisper[1]}` });
+                return;
+            }
+            data.to = target.id;
+            data.message = whisper[2];
         }
+
+        this.send({ type: 'chat', data: data });
+        input.value = '';
     }
 
     updatePlayers(players) {
+        this.players = players;
         const playersDiv = document.getElementById('players');
         playersDiv.innerHTML = '';
 
@@ -220,7 +453,7 @@ class BlackjackGame {
 
             playerDiv.innerHTML = `
                 ${isSelf ? '我' : player.nickname}的牌: {${player.cardCount}}张 ${displayValue} 分
-                <span class="status" style="color: ${player.statusColor}">${player.status}</span>
+                <span class="status" style="color: ${player.statusColor}">${player.status}${player.online === false ? '（离线）' : ''}</span>
                 <div class="cards">${cardsHtml}</div>
             `;
 
@@ -228,24 +461,45 @@ class BlackjackGame {
         });
     }
 
-    updatePlayer(player) {
-        const playerDiv = document.getElementById(`player-${player.id}`) || document.getElementById('player-self');
-        if (playerDiv) {
-            const cardsHtml = player.cards.map(card => `<div class="card ${card}"></div>`).join('');
-            const isSelf = player.id === this.playerId;
-            console.log('isSelf', isSelf);
-            const displayValue = isSelf || player.status !== '操作中' ? player.handValue : '?';
+    // 增量消息：给某个座位发了一张牌，新牌带动画
+    applyCardDealt(data) {
+        const player = this.players.find(p => p.id === data.playerId);
+        if (!player) {
+            return;
+        }
 
-            playerDiv.innerHTML = `
-                ${isSelf ? '我' : player.nickname}的牌: {${player.cardCount}}张 ${displayValue} 分
-                <span class="status" style="color: ${player.statusColor}">${player.status}</span>
-                <div class="cards">${cardsHtml}</div>
-            `;
+        player.cards.push(data.card);
+        player.cardCount = data.cardCount;
+        if (data.handValue !== undefined) {
+            player.handValue = data.handValue;
+        }
+        this.updatePlayers(this.players);
 
-            // 如果是自己爆牌了，禁用按钮
-            if (isSelf && player.status === '爆牌') {
-                this.enableButtons(false);
-            }
+        const isSelf = player.id === this.playerId;
+        const cards = document.querySelectorAll(`#${isSelf ? 'player-self' : `player-${player.id}`} .card`);
+        if (cards.length > 0) {
+            cards[cards.length - 1].classList.add('card-dealt');
+        }
+    }
+
+    // 增量消息：座位状态变化（停牌、爆牌、上下线）
+    applyStatusChanged(data) {
+        const player = this.players.find(p => p.id === data.playerId);
+        if (!player) {
+            return;
+        }
+
+        player.status = data.status;
+        player.statusColor = data.statusColor;
+        player.online = data.online;
+        if (data.handValue !== undefined) {
+            player.handValue = data.handValue;
+        }
+        this.updatePlayers(this.players);
+
+        // 如果是自己爆牌了，禁用按钮
+        if (player.id === this.playerId && player.status === '爆牌') {
+            this.enableButtons(false);
         }
     }
     updatePlayerSelf(player) {
@@ -272,8 +526,11 @@ class BlackjackGame {
         data.results.forEach(result => {
             const statusClass = result.isWinner ? 'green' : (result.status === '已爆牌' ? 'red' : 'gray');
             const winnerIcon = result.isWinner ? '👑 ' : '';
+            const outcome = result.outcome === 'push' ? '，平局' : '';
+            const blackjack = result.blackjack ? ' 🂡21点' : '';
+            const chips = result.chips > 0 ? `+${result.chips}` : `${result.chips || 0}`;
             resultHtml += `<div style="margin: 10px 0; color: ${statusClass};">
-                ${winnerIcon}${result.nickname}: ${result.score}分 (${result.status})
+                ${winnerIcon}${result.nickname}: ${result.score}分 (${result.status}${outcome})${blackjack} 筹码 ${chips}
             </div>`;
         });
 
@@ -287,7 +544,21 @@ class BlackjackGame {
         const chatMessages = document.getElementById('chat-messages');
         const msgDiv = document.createElement('div');
         msgDiv.style.margin = '5px 0';
-        msgDiv.textContent = `${data.nickname}: ${data.message}`;
+
+        const time = data.time ? new Date(data.time).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' }) : '';
+        const prefix = time ? `[${time}] ` : '';
+        switch (data.kind) {
+            case 'system':
+                msgDiv.style.color = '#95a5a6';
+                msgDiv.textContent = `${prefix}系统: ${data.message}`;
+                break;
+            case 'whisper':
+                msgDiv.style.color = '#e67e22';
+                msgDiv.textContent = `${prefix}${data.nickname} → ${data.toNickname} (私聊): ${data.message}`;
+                break;
+            default:
+                msgDiv.textContent = `${prefix}${data.nickname}: ${data.message}`;
+        }
         chatMessages.appendChild(msgDiv);
         chatMessages.scrollTop = chatMessages.scrollHeight;
     }
@@ -320,6 +591,8 @@ class BlackjackGame {
         const playerCountSpan = document.getElementById('player-count');
         const startButton = document.getElementById('start-game-button');
 
+        this.roomPlayers = players;
+
         // 更新玩家数量
         playerCountSpan.textContent = players.length;
         if (players.length >= 2) {
@@ -350,7 +623,11 @@ class BlackjackGame {
             const hostBadge = index === 0 ? '👑 ' : '';
             const youBadge = player.id === this.playerId ? '（你）' : '';
             
-            playerItem.textContent = `${hostBadge}${player.nickname}${youBadge}`;
+            // 跨局统计：局数、胜场和累计筹码
+            const stats = player.stats;
+            const record = stats ? `　${stats.hands}局 胜${stats.wins} 筹码${stats.netChips >= 0 ? '+' : ''}${stats.netChips}` : '';
+
+            playerItem.textContent = `${hostBadge}${player.nickname}${youBadge}${record}`;
             playerListDiv.appendChild(playerItem);
         });
     }
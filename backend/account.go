@@ -0,0 +1,582 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// guestTTL 游客身份多久不使用后删除
+const guestTTL = 30 * 24 * time.Hour
+
+// guestMintLimit 每个IP创建游客身份的速率
+var guestMintLimit = rateLimit{Rate: 0.1, Burst: 5}
+
+// sessionTTL 注册账号的会话有效期，过期后需要重新登录（游客会话随身份一起过期）
+const sessionTTL = 90 * 24 * time.Hour
+
+// authLimit 每个IP注册和登录的速率（bcrypt 校验开销大，也用来限制猜密码）
+var authLimit = rateLimit{Rate: 0.2, Burst: 10}
+
+// startingChips 新身份的初始筹码
+const startingChips = 1000
+
+// 用户名和密码的长度限制（bcrypt 只使用密码的前72字节）
+const (
+	usernameMinLength = 3
+	usernameMaxLength = 20
+	passwordMinLength = 8
+	passwordMaxBytes  = 72
+)
+
+// avatarChoices 可选的头像（第一个为默认头像）
+var avatarChoices = []string{"spade", "heart", "club", "diamond", "crown", "joker", "dice", "chip"}
+
+// Account 服务器签发的玩家身份：游客或注册账号
+type Account struct {
+	PlayerID     string    `json:"playerId"`
+	Username     string    `json:"username,omitempty"`     // 注册账号的用户名，游客为空
+	PasswordHash string    `json:"passwordHash,omitempty"` // bcrypt 哈希
+	Nickname     string    `json:"nickname"`
+	Avatar       string    `json:"avatar"`
+	Chips        int64     `json:"chips"`
+	Guest        bool      `json:"guest"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
+
+// profile 公开的资料（不含密码哈希）
+func (a *Account) profile() Profile {
+	return Profile{
+		PlayerID:  a.PlayerID,
+		Username:  a.Username,
+		Nickname:  a.Nickname,
+		Avatar:    a.Avatar,
+		Chips:     a.Chips,
+		Guest:     a.Guest,
+		CreatedAt: a.CreatedAt,
+	}
+}
+
+// Session 会话：令牌只保存 SHA-256，泄露存储文件也拿不到可用的令牌
+type Session struct {
+	TokenHash string    `json:"tokenHash"`
+	PlayerID  string    `json:"playerId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AccountState 身份的持久化状态
+type AccountState struct {
+	Accounts []*Account `json:"accounts"`
+	Sessions []*Session `json:"sessions"`
+}
+
+// AccountStore 身份存储接口
+type AccountStore interface {
+	// SaveAccounts 保存全部身份（覆盖旧状态）
+	SaveAccounts(state *AccountState) error
+	// LoadAccounts 加载已保存的身份，没有时返回空状态
+	LoadAccounts() (*AccountState, error)
+}
+
+// GuestRequest 创建游客身份请求（POST /api/guest，请求体可以为空）
+type GuestRequest struct {
+	Nickname string `json:"nickname,omitempty" validate:"max=32"`
+}
+
+// GuestResponse 游客身份：之后用 playerId 和 token 发送 connect
+type GuestResponse struct {
+	PlayerID string `json:"playerId"`
+	Token    string `json:"token"`
+	Nickname string `json:"nickname"`
+}
+
+// Profile 玩家资料
+type Profile struct {
+	PlayerID  string    `json:"playerId"`
+	Username  string    `json:"username,omitempty"`
+	Nickname  string    `json:"nickname"`
+	Avatar    string    `json:"avatar"`
+	Chips     int64     `json:"chips"`
+	Guest     bool      `json:"guest"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RegisterRequest 注册请求（POST /api/account/register）
+//
+// 带上游客的 Authorization: Bearer 令牌时把该游客升级为注册账号，
+// 玩家ID不变，筹码和战绩都保留
+type RegisterRequest struct {
+	Username string `json:"username" validate:"required,min=3,max=20"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+	Nickname string `json:"nickname,omitempty" validate:"max=32"`
+	Avatar   string `json:"avatar,omitempty" validate:"max=16"`
+}
+
+// Validate 检查用户名字符、密码字节数和头像
+func (r *RegisterRequest) Validate() []FieldError {
+	var fields []FieldError
+	for _, c := range r.Username {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			fields = append(fields, FieldError{Field: "username", Code: "usernameChars"})
+			break
+		}
+	}
+	if len(r.Password) > passwordMaxBytes {
+		fields = append(fields, FieldError{Field: "password", Code: "maxBytes", Args: []interface{}{passwordMaxBytes}})
+	}
+	if r.Avatar != "" && !validAvatar(r.Avatar) {
+		fields = append(fields, FieldError{Field: "avatar", Code: "oneOf", Args: []interface{}{strings.Join(avatarChoices, ", ")}})
+	}
+	return fields
+}
+
+// LoginRequest 登录请求（POST /api/account/login）
+type LoginRequest struct {
+	Username string `json:"username" validate:"required,max=20"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+// ProfileRequest 修改资料（PATCH /api/account/profile），留空的字段不修改
+type ProfileRequest struct {
+	Nickname string `json:"nickname,omitempty" validate:"max=32"`
+	Avatar   string `json:"avatar,omitempty" validate:"max=16"`
+}
+
+// Validate 检查头像
+func (r *ProfileRequest) Validate() []FieldError {
+	if r.Avatar != "" && !validAvatar(r.Avatar) {
+		return []FieldError{{Field: "avatar", Code: "oneOf", Args: []interface{}{strings.Join(avatarChoices, ", ")}}}
+	}
+	return nil
+}
+
+// AccountResponse 注册、登录的结果：会话令牌和玩家资料，之后用 playerId 和 token 发送 connect
+type AccountResponse struct {
+	Token string `json:"token,omitempty"`
+	Profile
+}
+
+// validAvatar 头像是否在可选列表中
+func validAvatar(avatar string) bool {
+	return containsString(avatarChoices, avatar)
+}
+
+// accountRegistry 玩家身份与会话（可并发使用）
+type accountRegistry struct {
+	mu        sync.Mutex
+	accounts  map[string]*Account // 按玩家ID索引
+	sessions  map[string]*Session // 按令牌哈希索引
+	usernames map[string]string   // 小写用户名 → 玩家ID
+	store     AccountStore        // 为nil时不持久化
+	saver     *saver              // 设置存储后创建
+	mint      *ipLimiter          // 创建游客身份的速率
+	auth      *ipLimiter          // 注册和登录的速率
+}
+
+// newAccountRegistry 创建身份注册表
+func newAccountRegistry() *accountRegistry {
+	return &accountRegistry{
+		accounts:  make(map[string]*Account),
+		sessions:  make(map[string]*Session),
+		usernames: make(map[string]string),
+		mint:      newIPLimiter(guestMintLimit),
+		auth:      newIPLimiter(authLimit),
+	}
+}
+
+// load 从存储恢复身份，删除过期的游客
+func (reg *accountRegistry) load(store AccountStore) (int, error) {
+	state, err := store.LoadAccounts()
+	if err != nil {
+		return 0, err
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.store = store
+	reg.saver = newSaver("玩家身份", reg.flush)
+	for _, account := range state.Accounts {
+		if account.Avatar == "" {
+			account.Avatar = avatarChoices[0]
+		}
+		reg.accounts[account.PlayerID] = account
+		if account.Username != "" {
+			reg.usernames[strings.ToLower(account.Username)] = account.PlayerID
+		}
+	}
+	for _, session := range state.Sessions {
+		if _, ok := reg.accounts[session.PlayerID]; ok {
+			reg.sessions[session.TokenHash] = session
+		}
+	}
+	if reg.sweep(time.Now()) > 0 {
+		reg.save()
+	}
+	return len(reg.accounts), nil
+}
+
+// createGuest 创建游客身份，返回身份和会话令牌（令牌只在这里出现一次）
+func (reg *accountRegistry) createGuest(rng RandomSource, nickname string) (*Account, string, error) {
+	token, hash, err := newSessionToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	now := time.Now()
+	reg.sweep(now)
+
+	playerID := generatePlayerID(rng)
+	for reg.accounts[playerID] != nil {
+		playerID = generatePlayerID(rng)
+	}
+	if nickname == "" {
+		nickname = "游客" + strings.ToUpper(playerID[len(playerID)-4:])
+	}
+
+	account := &Account{
+		PlayerID:  playerID,
+		Nickname:  nickname,
+		Avatar:    avatarChoices[0],
+		Chips:     startingChips,
+		Guest:     true,
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+	reg.accounts[playerID] = account
+	reg.sessions[hash] = &Session{TokenHash: hash, PlayerID: playerID, CreatedAt: now}
+	reg.save()
+
+	copied := *account
+	return &copied, token, nil
+}
+
+// authenticate 校验会话令牌属于该玩家，返回身份的副本
+func (reg *accountRegistry) authenticate(playerID, token string) (*Account, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	account, err := reg.lookup(token)
+	if err != nil || account.PlayerID != playerID {
+		return nil, newError(ErrUnauthorized)
+	}
+	// 最近使用时间只用于清理过期游客，每天最多保存一次
+	now := time.Now()
+	stale := now.Sub(account.LastSeen) > 24*time.Hour
+	account.LastSeen = now
+	if stale {
+		reg.save()
+	}
+
+	copied := *account
+	return &copied, nil
+}
+
+// session 按会话令牌查找身份，返回身份的副本（HTTP API 使用 Bearer 令牌时调用）
+func (reg *accountRegistry) session(token string) (*Account, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	account, err := reg.lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	copied := *account
+	return &copied, nil
+}
+
+// lookup 按会话令牌查找身份，删除过期的会话（调用方持有锁）
+func (reg *accountRegistry) lookup(token string) (*Account, error) {
+	hash := hashToken(token)
+	session, ok := reg.sessions[hash]
+	if !ok {
+		return nil, newError(ErrUnauthorized)
+	}
+	account := reg.accounts[session.PlayerID]
+	if !account.Guest && time.Since(session.CreatedAt) > sessionTTL {
+		delete(reg.sessions, hash)
+		reg.save()
+		return nil, newError(ErrUnauthorized)
+	}
+	return account, nil
+}
+
+// register 注册账号，返回账号和会话令牌
+//
+// guestToken 不为空时把该游客升级为注册账号：玩家ID和筹码保留，游客的令牌作废，
+// 换成新签发的会话；否则创建新的账号和会话
+func (reg *accountRegistry) register(rng RandomSource, guestToken string, req RegisterRequest, nickname string) (*Account, string, error) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, "", fmt.Errorf("密码哈希失败: %w", err)
+	}
+	token, hash, err := newSessionToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	key := strings.ToLower(req.Username)
+	if _, taken := reg.usernames[key]; taken {
+		return nil, "", newError(ErrUsernameTaken)
+	}
+
+	now := time.Now()
+	var account *Account
+	if guestToken != "" {
+		if account, err = reg.lookup(guestToken); err != nil {
+			return nil, "", err
+		}
+		if !account.Guest {
+			return nil, "", newError(ErrAlreadyRegistered)
+		}
+		// 游客的会话可能已经用了很久，换成新会话，从现在开始计算注册账号的有效期
+		delete(reg.sessions, hashToken(guestToken))
+	} else {
+		playerID := generatePlayerID(rng)
+		for reg.accounts[playerID] != nil {
+			playerID = generatePlayerID(rng)
+		}
+		account = &Account{
+			PlayerID:  playerID,
+			Nickname:  defaultNickname(req.Username, playerID),
+			Avatar:    avatarChoices[0],
+			Chips:     startingChips,
+			CreatedAt: now,
+		}
+		reg.accounts[playerID] = account
+	}
+
+	reg.sessions[hash] = &Session{TokenHash: hash, PlayerID: account.PlayerID, CreatedAt: now}
+	account.Username = req.Username
+	account.PasswordHash = string(passwordHash)
+	account.Guest = false
+	account.LastSeen = now
+	if nickname != "" {
+		account.Nickname = nickname
+	}
+	if req.Avatar != "" {
+		account.Avatar = req.Avatar
+	}
+	reg.usernames[key] = account.PlayerID
+	reg.save()
+
+	copied := *account
+	return &copied, token, nil
+}
+
+// dummyPasswordHash 用户名不存在时也做一次 bcrypt 校验，避免按响应时间判断用户名是否存在
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("blackjack"), bcrypt.DefaultCost)
+	return hash
+})
+
+// login 校验用户名和密码，成功时创建新的会话
+func (reg *accountRegistry) login(username, password string) (*Account, string, error) {
+	reg.mu.Lock()
+	var passwordHash []byte
+	playerID, ok := reg.usernames[strings.ToLower(username)]
+	if ok {
+		passwordHash = []byte(reg.accounts[playerID].PasswordHash)
+	}
+	reg.mu.Unlock()
+
+	// bcrypt 校验较慢，不持有锁
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, "", newError(ErrInvalidCredentials)
+	}
+	if bcrypt.CompareHashAndPassword(passwordHash, []byte(password)) != nil {
+		return nil, "", newError(ErrInvalidCredentials)
+	}
+
+	token, hash, err := newSessionToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	// 登录会不断产生新会话，和创建游客一样顺便清理过期的会话
+	now := time.Now()
+	reg.sweep(now)
+
+	account, ok := reg.accounts[playerID]
+	if !ok {
+		return nil, "", newError(ErrInvalidCredentials)
+	}
+	account.LastSeen = now
+	reg.sessions[hash] = &Session{TokenHash: hash, PlayerID: playerID, CreatedAt: now}
+	reg.save()
+
+	copied := *account
+	return &copied, token, nil
+}
+
+// logout 删除会话
+func (reg *accountRegistry) logout(token string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	hash := hashToken(token)
+	if _, ok := reg.sessions[hash]; !ok {
+		return newError(ErrUnauthorized)
+	}
+	delete(reg.sessions, hash)
+	reg.save()
+	return nil
+}
+
+// updateProfile 修改昵称和头像，为空的不修改，返回修改后的副本
+func (reg *accountRegistry) updateProfile(playerID, nickname, avatar string) (*Account, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	account, ok := reg.accounts[playerID]
+	if !ok {
+		return nil, newError(ErrUnauthorized)
+	}
+	if nickname != "" {
+		account.Nickname = nickname
+	}
+	if avatar != "" {
+		account.Avatar = avatar
+	}
+	reg.save()
+
+	copied := *account
+	return &copied, nil
+}
+
+// addChips 按结算结果调整身份的筹码（只是游戏筹码，输光后可以为负）
+func (reg *accountRegistry) addChips(results []RoundResult) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	changed := false
+	for _, result := range results {
+		if account, ok := reg.accounts[result.PlayerID]; ok && result.Chips != 0 {
+			account.Chips += result.Chips
+			changed = true
+		}
+	}
+	if changed {
+		reg.save()
+	}
+}
+
+// defaultNickname 注册时未指定昵称：用户名能作昵称时使用用户名，否则为 "玩家"+ID后4位
+func defaultNickname(username, playerID string) string {
+	if nickname, err := normalizeNickname(username); err == nil && nickname != "" {
+		return nickname
+	}
+	return "玩家" + strings.ToUpper(playerID[len(playerID)-4:])
+}
+
+// nickname 身份的昵称，身份不存在时为空
+func (reg *accountRegistry) nickname(playerID string) string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if account, ok := reg.accounts[playerID]; ok {
+		return account.Nickname
+	}
+	return ""
+}
+
+// setNickname 修改身份的昵称
+func (reg *accountRegistry) setNickname(playerID, nickname string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if account, ok := reg.accounts[playerID]; ok && account.Nickname != nickname {
+		account.Nickname = nickname
+		reg.save()
+	}
+}
+
+// sweep 删除长期未使用的游客和过期的会话，返回删除的数量（调用方持有锁）
+func (reg *accountRegistry) sweep(now time.Time) int {
+	removed := 0
+	for id, account := range reg.accounts {
+		if account.Guest && now.Sub(account.LastSeen) > guestTTL {
+			delete(reg.accounts, id)
+			removed++
+		}
+	}
+	for hash, session := range reg.sessions {
+		account, ok := reg.accounts[session.PlayerID]
+		if !ok || !account.Guest && now.Sub(session.CreatedAt) > sessionTTL {
+			delete(reg.sessions, hash)
+			removed++
+		}
+	}
+	return removed
+}
+
+// save 标记身份有改动，由后台协程保存（调用方持有锁）
+func (reg *accountRegistry) save() {
+	if reg.saver != nil {
+		reg.saver.mark()
+	}
+}
+
+// flush 保存全部身份和会话的副本（在后台保存协程中调用）
+func (reg *accountRegistry) flush() error {
+	reg.mu.Lock()
+	state := &AccountState{
+		Accounts: make([]*Account, 0, len(reg.accounts)),
+		Sessions: make([]*Session, 0, len(reg.sessions)),
+	}
+	for _, account := range reg.accounts {
+		copied := *account
+		state.Accounts = append(state.Accounts, &copied)
+	}
+	for _, session := range reg.sessions {
+		copied := *session
+		state.Sessions = append(state.Sessions, &copied)
+	}
+	reg.mu.Unlock()
+
+	return reg.store.SaveAccounts(state)
+}
+
+// close 写出未保存的改动并停止后台保存
+func (reg *accountRegistry) close() {
+	reg.mu.Lock()
+	saver := reg.saver
+	reg.mu.Unlock()
+	if saver != nil {
+		saver.close()
+	}
+}
+
+// newSessionToken 生成随机会话令牌及其哈希
+func newSessionToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("生成会话令牌失败: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken 会话令牌的 SHA-256
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccountGuest(t *testing.T) {
+	reg := newAccountRegistry()
+	rng := NewSeededRandomSource(1)
+
+	guest, token, err := reg.createGuest(rng, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !guest.Guest || guest.Chips != startingChips || guest.Avatar != avatarChoices[0] {
+		t.Errorf("游客 = %+v", guest)
+	}
+	if want := "游客" + strings.ToUpper(guest.PlayerID[len(guest.PlayerID)-4:]); guest.Nickname != want {
+		t.Errorf("默认昵称 = %q, want %q", guest.Nickname, want)
+	}
+
+	named, _, err := reg.createGuest(rng, "小明")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if named.Nickname != "小明" || named.PlayerID == guest.PlayerID {
+		t.Errorf("第二个游客 = %+v", named)
+	}
+
+	tests := []struct {
+		name     string
+		playerID string
+		token    string
+		want     ErrorCode
+	}{
+		{name: "正确的令牌", playerID: guest.PlayerID, token: token},
+		{name: "其他玩家的令牌", playerID: named.PlayerID, token: token, want: ErrUnauthorized},
+		{name: "无效令牌", playerID: guest.PlayerID, token: "bogus", want: ErrUnauthorized},
+		{name: "空令牌", playerID: guest.PlayerID, token: "", want: ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account, err := reg.authenticate(tt.playerID, tt.token)
+			if codeOf(err) != tt.want || (err == nil) != (tt.want == "") {
+				t.Fatalf("authenticate = %v, want %q", err, tt.want)
+			}
+			if err == nil && account.PlayerID != tt.playerID {
+				t.Errorf("身份 = %s, want %s", account.PlayerID, tt.playerID)
+			}
+		})
+	}
+}
+
+func TestAccountRegisterAndLogin(t *testing.T) {
+	reg := newAccountRegistry()
+	rng := NewSeededRandomSource(2)
+	req := RegisterRequest{Username: "Alice", Password: "password1"}
+
+	account, token, err := reg.register(rng, "", req, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Guest || account.Username != "Alice" || account.Nickname != "Alice" || account.PasswordHash == "" {
+		t.Errorf("账号 = %+v", account)
+	}
+	if _, err := reg.authenticate(account.PlayerID, token); err != nil {
+		t.Errorf("注册返回的令牌无效: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		register func() error
+		want     ErrorCode
+	}{
+		{name: "用户名不区分大小写", want: ErrUsernameTaken, register: func() error {
+			_, _, err := reg.register(rng, "", RegisterRequest{Username: "alice", Password: "password2"}, "")
+			return err
+		}},
+		{name: "注册账号不能再升级", want: ErrAlreadyRegistered, register: func() error {
+			_, _, err := reg.register(rng, token, RegisterRequest{Username: "bob", Password: "password2"}, "")
+			return err
+		}},
+		{name: "游客令牌无效", want: ErrUnauthorized, register: func() error {
+			_, _, err := reg.register(rng, "bogus", RegisterRequest{Username: "carol", Password: "password2"}, "")
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.register(); codeOf(err) != tt.want {
+				t.Errorf("register = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	logins := []struct {
+		username, password string
+		want               ErrorCode
+	}{
+		{"alice", "password1", ""},
+		{"ALICE", "password1", ""},
+		{"alice", "wrong-password", ErrInvalidCredentials},
+		{"nobody", "password1", ErrInvalidCredentials},
+	}
+	for _, tt := range logins {
+		got, loginToken, err := reg.login(tt.username, tt.password)
+		if codeOf(err) != tt.want || (err == nil) != (tt.want == "") {
+			t.Errorf("login(%q, %q) = %v, want %q", tt.username, tt.password, err, tt.want)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got.PlayerID != account.PlayerID || loginToken == token {
+			t.Errorf("login(%q) = %s, 令牌 %q", tt.username, got.PlayerID, loginToken)
+		}
+		if err := reg.logout(loginToken); err != nil {
+			t.Errorf("logout: %v", err)
+		}
+		if err := reg.logout(loginToken); codeOf(err) != ErrUnauthorized {
+			t.Errorf("重复 logout = %v, want %s", err, ErrUnauthorized)
+		}
+	}
+}
+
+// TestAccountUpgradeGuest 游客升级为注册账号：玩家ID和筹码保留，游客令牌作废，换成新会话
+
+func TestAccountUpgradeGuest(t *testing.T) {
+	reg := newAccountRegistry()
+	rng := NewSeededRandomSource(3)
+
+	guest, guestToken, err := reg.createGuest(rng, "小明")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.addChips([]RoundResult{{PlayerID: guest.PlayerID, Chips: 20}})
+
+	account, token, err := reg.register(rng, guestToken, RegisterRequest{Username: "xiaoming", Password: "password1", Avatar: "crown"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.PlayerID != guest.PlayerID || account.Guest || account.Chips != startingChips+20 {
+		t.Errorf("升级后的账号 = %+v", account)
+	}
+	if account.Nickname != "小明" || account.Avatar != "crown" {
+		t.Errorf("昵称和头像 = %q, %q, want 保留昵称并使用新头像", account.Nickname, account.Avatar)
+	}
+	if token == guestToken {
+		t.Fatal("升级后仍使用游客令牌")
+	}
+	if _, err := reg.authenticate(guest.PlayerID, guestToken); codeOf(err) != ErrUnauthorized {
+		t.Errorf("游客令牌 authenticate = %v, want %s", err, ErrUnauthorized)
+	}
+	if _, err := reg.authenticate(guest.PlayerID, token); err != nil {
+		t.Errorf("新令牌 authenticate = %v", err)
+	}
+}
+
+func TestAccountSweep(t *testing.T) {
+	reg := newAccountRegistry()
+	now := time.Now()
+
+	reg.accounts["old-guest"] = &Account{PlayerID: "old-guest", Guest: true, LastSeen: now.Add(-guestTTL - time.Hour)}
+	reg.accounts["new-guest"] = &Account{PlayerID: "new-guest", Guest: true, LastSeen: now.Add(-time.Hour)}
+	reg.accounts["member"] = &Account{PlayerID: "member", Username: "member", LastSeen: now.Add(-guestTTL - time.Hour)}
+	reg.sessions["a"] = &Session{TokenHash: "a", PlayerID: "old-guest", CreatedAt: now}
+	reg.sessions["b"] = &Session{TokenHash: "b", PlayerID: "new-guest", CreatedAt: now.Add(-sessionTTL - time.Hour)}
+	reg.sessions["c"] = &Session{TokenHash: "c", PlayerID: "member", CreatedAt: now.Add(-sessionTTL - time.Hour)}
+	reg.sessions["d"] = &Session{TokenHash: "d", PlayerID: "member", CreatedAt: now.Add(-time.Hour)}
+
+	if removed := reg.sweep(now); removed != 3 {
+		t.Errorf("sweep = %d, want 3（过期游客及其会话、过期的账号会话）", removed)
+	}
+
+	for _, id := range []string{"new-guest", "member"} {
+		if reg.accounts[id] == nil {
+			t.Errorf("身份 %s 被删除", id)
+		}
+	}
+	if reg.accounts["old-guest"] != nil {
+		t.Error("过期游客没有删除")
+	}
+	for hash, want := range map[string]bool{"a": false, "b": true, "c": false, "d": true} {
+		if _, ok := reg.sessions[hash]; ok != want {
+			t.Errorf("会话 %s 存在 = %v, want %v", hash, ok, want)
+		}
+	}
+}
+
+func TestAccountPersistence(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := newAccountRegistry()
+	if _, err := reg.load(store); err != nil {
+		t.Fatal(err)
+	}
+	guest, token, err := reg.createGuest(NewSeededRandomSource(4), "小红")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.updateProfile(guest.PlayerID, "", "joker"); err != nil {
+		t.Fatal(err)
+	}
+	reg.close() // 写出未保存的改动
+
+	restored := newAccountRegistry()
+	n, err := restored.load(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.close()
+	if n != 1 {
+		t.Fatalf("恢复了 %d 个身份, want 1", n)
+	}
+	account, err := restored.authenticate(guest.PlayerID, token)
+	if err != nil {
+		t.Fatalf("恢复后令牌无效: %v", err)
+	}
+	if account.Nickname != "小红" || account.Avatar != "joker" {
+		t.Errorf("恢复的身份 = %+v", account)
+	}
+}
+
+func TestDefaultNickname(t *testing.T) {
+	tests := []struct {
+		username, playerID, want string
+	}{
+		{"alice", "abcdefgh", "alice"},
+		{"admin", "abcdwxyz", "玩家WXYZ"},
+		{"a_very_long_username", "abcd1234", "玩家1234"},
+	}
+	for _, tt := range tests {
+		if got := defaultNickname(tt.username, tt.playerID); got != tt.want {
+			t.Errorf("defaultNickname(%q) = %q, want %q", tt.username, got, tt.want)
+		}
+	}
+}
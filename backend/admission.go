@@ -0,0 +1,273 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConnLimits WebSocket 连接准入配置，数值为0表示不限制
+type ConnLimits struct {
+	AllowedOrigins []string // 允许的来源（如 https://example.com），为空时只允许同源，"*" 允许所有来源
+	MaxPerIP       int      // 每个IP的最大并发连接数
+	MaxTotal       int      // 服务器的最大并发连接数
+	Rate           float64  // 每个IP每秒允许新建的连接数
+	Burst          int      // 每个IP允许的突发新建连接数
+	TrustProxy     bool     // 从 X-Forwarded-For / X-Real-IP 取客户端IP（部署在反向代理之后时开启）
+
+	// TrustedProxies 受信任的代理地址；为空时只信任直连的对端（只有一层代理）
+	TrustedProxies []*net.IPNet
+}
+
+// defaultConnLimits 默认的连接准入配置
+func defaultConnLimits() ConnLimits {
+	return ConnLimits{
+		MaxPerIP: 20,
+		MaxTotal: 5000,
+		Rate:     2,
+		Burst:    10,
+	}
+}
+
+// loadConnLimits 从环境变量读取连接准入配置，未设置的使用默认值
+//
+//	WS_ALLOWED_ORIGINS   逗号分隔的来源列表
+//	WS_MAX_CONNS_PER_IP  每个IP的最大并发连接数
+//	WS_MAX_CONNS         最大并发连接数
+//	WS_CONN_RATE         每个IP每秒新建连接数
+//	WS_CONN_BURST        每个IP突发新建连接数
+//	TRUST_PROXY          为1时信任代理头
+//	TRUSTED_PROXIES      逗号分隔的受信任代理地址（IP或CIDR）
+func loadConnLimits() ConnLimits {
+	limits := defaultConnLimits()
+
+	for _, origin := range strings.Split(os.Getenv("WS_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			limits.AllowedOrigins = append(limits.AllowedOrigins, origin)
+		}
+	}
+	if v, err := strconv.Atoi(os.Getenv("WS_MAX_CONNS_PER_IP")); err == nil && v >= 0 {
+		limits.MaxPerIP = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("WS_MAX_CONNS")); err == nil && v >= 0 {
+		limits.MaxTotal = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("WS_CONN_RATE"), 64); err == nil && v >= 0 {
+		limits.Rate = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("WS_CONN_BURST")); err == nil && v > 0 {
+		limits.Burst = v
+	}
+	limits.TrustProxy = os.Getenv("TRUST_PROXY") == "1"
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if network, ok := parseNetwork(proxy); ok {
+			limits.TrustedProxies = append(limits.TrustedProxies, network)
+		} else {
+			log.Printf("忽略无效的代理地址: %s", proxy)
+		}
+	}
+
+	return limits
+}
+
+// 拒绝连接的原因（同时作为计数器的键）
+const (
+	rejectOrigin = "origin" // 来源不在允许列表中
+	rejectPerIP  = "perIp"  // 该IP的并发连接已满
+	rejectTotal  = "total"  // 服务器并发连接已满
+	rejectRate   = "rate"   // 该IP新建连接过快
+)
+
+// rejectErrors 拒绝原因对应的错误
+var rejectErrors = map[string]ErrorCode{
+	rejectOrigin: ErrOriginNotAllowed,
+	rejectPerIP:  ErrTooManyConnections,
+	rejectTotal:  ErrServerBusy,
+	rejectRate:   ErrRateLimited,
+}
+
+// admission WebSocket 连接准入：在升级之前检查来源、并发数和新建连接速率
+type admission struct {
+	limits ConnLimits
+	mu     sync.Mutex
+	perIP  map[string]int // 各IP当前的连接数
+	total  int            // 当前连接总数
+	rate   *ipLimiter     // 各IP新建连接的速率
+
+	accepted uint64            // 累计接受的连接数
+	rejected map[string]uint64 // 按原因累计拒绝的连接数
+}
+
+// newAdmission 创建连接准入检查
+func newAdmission(limits ConnLimits) *admission {
+	return &admission{
+		limits:   limits,
+		perIP:    make(map[string]int),
+		rate:     newIPLimiter(rateLimit{Rate: limits.Rate, Burst: limits.Burst}),
+		rejected: make(map[string]uint64),
+	}
+}
+
+// admit 检查是否接受连接，接受时返回连接断开后必须调用的 release
+func (a *admission) admit(r *http.Request) (release func(), err error) {
+	ip := a.clientIP(r)
+	origin := r.Header.Get("Origin")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	reason := a.check(r, ip, origin)
+	if reason != "" {
+		a.rejected[reason]++
+		log.Printf("拒绝WebSocket连接（%s）: ip=%s origin=%q", reason, ip, origin)
+		return nil, newError(rejectErrors[reason])
+	}
+
+	a.accepted++
+	a.total++
+	a.perIP[ip]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+
+			a.total--
+			if a.perIP[ip]--; a.perIP[ip] <= 0 {
+				delete(a.perIP, ip)
+			}
+		})
+	}, nil
+}
+
+// check 返回拒绝原因，接受时返回空字符串（调用方持有锁）
+func (a *admission) check(r *http.Request, ip, origin string) string {
+	if !a.originAllowed(r, origin) {
+		return rejectOrigin
+	}
+	if a.limits.MaxTotal > 0 && a.total >= a.limits.MaxTotal {
+		return rejectTotal
+	}
+	if a.limits.MaxPerIP > 0 && a.perIP[ip] >= a.limits.MaxPerIP {
+		return rejectPerIP
+	}
+	if a.limits.Rate > 0 && !a.rate.allow(ip, time.Now()) {
+		return rejectRate
+	}
+	return ""
+}
+
+// originAllowed 检查来源：没有 Origin 头的非浏览器客户端直接放行，
+// 未配置允许列表时只允许与请求 Host 相同的来源
+func (a *admission) originAllowed(r *http.Request, origin string) bool {
+	if origin == "" {
+		return true
+	}
+
+	if len(a.limits.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, allowed := range a.limits.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 获取客户端IP。开启 TrustProxy 且直连的对端是受信任的代理时，
+// 从 X-Forwarded-For 由右向左取第一个不是受信任代理的地址（左边的条目可以由客户端伪造），
+// 没有该头时取代理设置的 X-Real-IP
+func (a *admission) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !a.limits.TrustProxy || len(a.limits.TrustedProxies) > 0 && !a.trustedProxy(ip) {
+		return ip
+	}
+
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		hops := strings.Split(strings.Join(values, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !a.trustedProxy(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
+
+// trustedProxy 地址是否在受信任的代理列表中
+func (a *admission) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range a.limits.TrustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetwork 解析IP或CIDR，单个IP视为只包含它自己的网段
+func parseNetwork(s string) (*net.IPNet, bool) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return network, true
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, false
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, true
+}
+
+// ConnStats 连接准入统计
+type ConnStats struct {
+	Active   int               `json:"active"`
+	Accepted uint64            `json:"accepted"`
+	Rejected map[string]uint64 `json:"rejected"`
+}
+
+// stats 获取连接准入统计
+func (a *admission) stats() ConnStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rejected := make(map[string]uint64, len(a.rejected))
+	for reason, n := range a.rejected {
+		rejected[reason] = n
+	}
+	return ConnStats{
+		Active:   a.total,
+		Accepted: a.accepted,
+		Rejected: rejected,
+	}
+}
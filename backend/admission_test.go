@@ -0,0 +1,271 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// mustNetworks 解析受信任的代理列表
+func mustNetworks(t *testing.T, specs ...string) []*net.IPNet {
+	t.Helper()
+
+	networks := make([]*net.IPNet, 0, len(specs))
+	for _, spec := range specs {
+		network, ok := parseNetwork(spec)
+		if !ok {
+			t.Fatalf("无效的地址 %q", spec)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := []string{"10.0.0.0/8", "192.168.1.1"}
+
+	tests := []struct {
+		name       string
+		trustProxy bool
+		trusted    []string
+		remote     string
+		xff        []string
+		realIP     string
+		want       string
+	}{
+		{name: "不信任代理时忽略代理头", remote: "1.2.3.4:5000", xff: []string{"9.9.9.9"}, realIP: "8.8.8.8", want: "1.2.3.4"},
+		{name: "RemoteAddr 没有端口", remote: "1.2.3.4", want: "1.2.3.4"},
+
+		{name: "一层代理取最右边的地址", trustProxy: true, remote: "10.0.0.1:80", xff: []string{"9.9.9.9"}, want: "9.9.9.9"},
+		{name: "一层代理不信任客户端伪造的左侧地址", trustProxy: true, remote: "10.0.0.1:80", xff: []string{"6.6.6.6, 9.9.9.9"}, want: "9.9.9.9"},
+		{name: "一层代理只有 X-Real-IP", trustProxy: true, remote: "10.0.0.1:80", realIP: "9.9.9.9", want: "9.9.9.9"},
+		{name: "X-Real-IP 不合法时用对端地址", trustProxy: true, remote: "10.0.0.1:80", realIP: "bogus", want: "10.0.0.1"},
+
+		{name: "跳过受信任的代理", trustProxy: true, trusted: proxies, remote: "10.0.0.1:80", xff: []string{"6.6.6.6, 9.9.9.9, 10.1.2.3"}, want: "9.9.9.9"},
+		{name: "多个头按顺序拼接", trustProxy: true, trusted: proxies, remote: "10.0.0.1:80", xff: []string{"6.6.6.6, 9.9.9.9", "192.168.1.1"}, want: "9.9.9.9"},
+		{name: "全是受信任的代理时取最左边的", trustProxy: true, trusted: proxies, remote: "10.0.0.1:80", xff: []string{"10.0.0.5, 10.0.0.6"}, want: "10.0.0.5"},
+		{name: "遇到不合法的条目停止", trustProxy: true, trusted: proxies, remote: "10.0.0.1:80", xff: []string{"9.9.9.9, unknown, 10.0.0.7"}, want: "10.0.0.7"},
+		{name: "对端不是受信任的代理时忽略代理头", trustProxy: true, trusted: proxies, remote: "7.7.7.7:80", xff: []string{"9.9.9.9"}, realIP: "9.9.9.9", want: "7.7.7.7"},
+		{name: "IPv6", trustProxy: true, trusted: []string{"::1"}, remote: "[::1]:80", xff: []string{"2001:db8::1"}, want: "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := defaultConnLimits()
+			limits.TrustProxy = tt.trustProxy
+			limits.TrustedProxies = mustNetworks(t, tt.trusted...)
+			a := newAdmission(limits)
+
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := a.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		spec     string
+		ok       bool
+		contains string
+		excludes string
+	}{
+		{spec: "10.0.0.0/8", ok: true, contains: "10.255.0.1", excludes: "11.0.0.1"},
+		{spec: "192.168.1.1", ok: true, contains: "192.168.1.1", excludes: "192.168.1.2"},
+		{spec: "::1", ok: true, contains: "::1", excludes: "::2"},
+		{spec: "2001:db8::/32", ok: true, contains: "2001:db8:1::1", excludes: "2001:db9::1"},
+		{spec: "example.com"},
+		{spec: "10.0.0.0/33"},
+	}
+
+	for _, tt := range tests {
+		network, ok := parseNetwork(tt.spec)
+		if ok != tt.ok {
+			t.Errorf("parseNetwork(%q) ok = %v, want %v", tt.spec, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !network.Contains(net.ParseIP(tt.contains)) {
+			t.Errorf("%s 应包含 %s", tt.spec, tt.contains)
+		}
+		if network.Contains(net.ParseIP(tt.excludes)) {
+			t.Errorf("%s 不应包含 %s", tt.spec, tt.excludes)
+		}
+	}
+}
+
+// admitStep 一次连接请求及期望的结果（空错误码表示接受）
+type admitStep struct {
+	remote string
+	origin string
+	want   ErrorCode
+}
+
+func TestAdmit(t *testing.T) {
+	limits := func(change func(*ConnLimits)) ConnLimits {
+		l := ConnLimits{Burst: 1}
+		change(&l)
+		return l
+	}
+
+	tests := []struct {
+		name     string
+		limits   ConnLimits
+		steps    []admitStep
+		rejected map[string]uint64
+	}{
+		{
+			name:   "未配置来源时只允许同源",
+			limits: limits(func(*ConnLimits) {}),
+			steps: []admitStep{
+				{remote: "1.1.1.1:1", origin: "http://example.com"},
+				{remote: "1.1.1.1:2", origin: "http://EXAMPLE.com"},
+				{remote: "1.1.1.1:3", origin: "http://evil.com", want: ErrOriginNotAllowed},
+				{remote: "1.1.1.1:4"},
+			},
+			rejected: map[string]uint64{rejectOrigin: 1},
+		},
+		{
+			name: "来源允许列表",
+			limits: limits(func(l *ConnLimits) {
+				l.AllowedOrigins = []string{"https://app.example.com/"}
+			}),
+			steps: []admitStep{
+				{remote: "1.1.1.1:1", origin: "https://app.example.com"},
+				{remote: "1.1.1.1:2", origin: "http://example.com", want: ErrOriginNotAllowed},
+			},
+			rejected: map[string]uint64{rejectOrigin: 1},
+		},
+		{
+			name: "每个IP的并发连接数",
+			limits: limits(func(l *ConnLimits) {
+				l.MaxPerIP = 2
+			}),
+			steps: []admitStep{
+				{remote: "1.1.1.1:1"},
+				{remote: "1.1.1.1:2"},
+				{remote: "1.1.1.1:3", want: ErrTooManyConnections},
+				{remote: "2.2.2.2:1"},
+			},
+			rejected: map[string]uint64{rejectPerIP: 1},
+		},
+		{
+			name: "服务器的并发连接数",
+			limits: limits(func(l *ConnLimits) {
+				l.MaxTotal = 2
+				l.MaxPerIP = 1
+			}),
+			steps: []admitStep{
+				{remote: "1.1.1.1:1"},
+				{remote: "2.2.2.2:1"},
+				{remote: "3.3.3.3:1", want: ErrServerBusy},
+				{remote: "1.1.1.1:2", want: ErrServerBusy},
+			},
+			rejected: map[string]uint64{rejectTotal: 2},
+		},
+		{
+			name: "每个IP新建连接的速率",
+			limits: limits(func(l *ConnLimits) {
+				l.Rate = 0.001
+				l.Burst = 2
+			}),
+			steps: []admitStep{
+				{remote: "1.1.1.1:1"},
+				{remote: "1.1.1.1:2"},
+				{remote: "1.1.1.1:3", want: ErrRateLimited},
+				{remote: "2.2.2.2:1"},
+			},
+			rejected: map[string]uint64{rejectRate: 1},
+		},
+		{
+			name: "被拒绝的来源不消耗速率令牌",
+			limits: limits(func(l *ConnLimits) {
+				l.Rate = 0.001
+			}),
+			steps: []admitStep{
+				{remote: "1.1.1.1:1", origin: "http://evil.com", want: ErrOriginNotAllowed},
+				{remote: "1.1.1.1:2"},
+			},
+			rejected: map[string]uint64{rejectOrigin: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdmission(tt.limits)
+
+			accepted := 0
+			for i, step := range tt.steps {
+				r := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+				r.RemoteAddr = step.remote
+				if step.origin != "" {
+					r.Header.Set("Origin", step.origin)
+				}
+
+				release, err := a.admit(r)
+				var got ErrorCode
+				if err != nil {
+					got = asGameError(err).Code
+				}
+				if got != step.want {
+					t.Fatalf("第%d个连接: 错误 = %q, want %q", i+1, got, step.want)
+				}
+				if err == nil {
+					accepted++
+					defer release()
+				}
+			}
+
+			stats := a.stats()
+			if stats.Active != accepted || stats.Accepted != uint64(accepted) {
+				t.Errorf("统计 = %+v, want %d 个连接", stats, accepted)
+			}
+			if !reflect.DeepEqual(stats.Rejected, tt.rejected) {
+				t.Errorf("拒绝统计 = %v, want %v", stats.Rejected, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestAdmitRelease(t *testing.T) {
+	a := newAdmission(ConnLimits{MaxPerIP: 1, MaxTotal: 1})
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		r.RemoteAddr = "1.1.1.1:1"
+		return r
+	}
+
+	release, err := a.admit(request())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.admit(request()); err == nil {
+		t.Fatal("连接已满时应拒绝")
+	}
+
+	// 重复调用 release 只释放一次
+	release()
+	release()
+	if stats := a.stats(); stats.Active != 0 || len(a.perIP) != 0 {
+		t.Fatalf("释放后 active=%d perIP=%v", stats.Active, a.perIP)
+	}
+
+	release, err = a.admit(request())
+	if err != nil {
+		t.Fatalf("释放后应接受新连接: %v", err)
+	}
+	release()
+}
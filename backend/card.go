@@ -1,10 +1,5 @@
 package main
 
-import (
-	"math/rand"
-	"time"
-)
-
 // Suit 扑克花色
 type Suit int
 
@@ -36,8 +31,8 @@ const (
 
 // Card 扑克牌
 type Card struct {
-	Suit Suit
-	Rank Rank
+	Suit Suit `json:"suit"`
+	Rank Rank `json:"rank"`
 }
 
 // Value 获取牌的数值（21点规则）
@@ -69,6 +64,8 @@ func (c *Card) String() string {
 	switch c.Rank {
 	case Ace:
 		rankStr = "A"
+	case Ten:
+		rankStr = "10"
 	case Jack:
 		rankStr = "J"
 	case Queen:
@@ -82,14 +79,36 @@ func (c *Card) String() string {
 	return "pk-" + suitStr + rankStr
 }
 
+// Code 获取牌的简写（如 Ah、Td，用于牌局记录）
+func (c *Card) Code() string {
+	rankStr := ""
+	switch c.Rank {
+	case Ace:
+		rankStr = "A"
+	case Ten:
+		rankStr = "T"
+	case Jack:
+		rankStr = "J"
+	case Queen:
+		rankStr = "Q"
+	case King:
+		rankStr = "K"
+	default:
+		rankStr = string('0' + byte(c.Rank))
+	}
+
+	return rankStr + string("cdhs"[c.Suit])
+}
+
 // Deck 一副牌（52张）
 type Deck struct {
 	cards []Card
+	rng   RandomSource
 }
 
-// NewDeck 创建一副新牌并洗牌
-func NewDeck() *Deck {
-	d := &Deck{cards: make([]Card, 0, 52)}
+// NewDeck 创建一副新牌并使用指定随机数来源洗牌
+func NewDeck(rng RandomSource) *Deck {
+	d := &Deck{cards: make([]Card, 0, 52), rng: rng}
 
 	for suit := Club; suit <= Spade; suit++ {
 		for rank := Ace; rank <= King; rank++ {
@@ -101,11 +120,23 @@ func NewDeck() *Deck {
 	return d
 }
 
-// Shuffle 洗牌
+// NewDeckFromCards 按指定牌序创建牌组（没有随机数来源，Shuffle 不改变牌序；用于回放和恢复）
+func NewDeckFromCards(cards []Card) *Deck {
+	return &Deck{cards: append([]Card(nil), cards...)}
+}
+
+// Cards 获取当前牌序的副本（最后一张最先发出）
+func (d *Deck) Cards() []Card {
+	return append([]Card(nil), d.cards...)
+}
+
+// Shuffle 洗牌，没有随机数来源时保持原牌序
 func (d *Deck) Shuffle() {
-	rand.Seed(time.Now().UnixNano())
+	if d.rng == nil {
+		return
+	}
 	for i := len(d.cards) - 1; i > 0; i-- {
-		j := rand.Intn(i + 1)
+		j := d.rng.Intn(i + 1)
 		d.cards[i], d.cards[j] = d.cards[j], d.cards[i]
 	}
 }
@@ -120,6 +151,14 @@ func (d *Deck) Deal() Card {
 	return card
 }
 
+// Peek 查看下一张要发的牌（不从牌组中移除）
+func (d *Deck) Peek() Card {
+	if len(d.cards) == 0 {
+		return Card{}
+	}
+	return d.cards[len(d.cards)-1]
+}
+
 // Remaining 返回剩余牌数
 func (d *Deck) Remaining() int {
 	return len(d.cards)
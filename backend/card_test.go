@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// hand 由简写（如 Ah、Td）组成的手牌
+func hand(t *testing.T, codes ...string) []Card {
+	t.Helper()
+
+	cards := make([]Card, 0, len(codes))
+	for _, code := range codes {
+		cards = append(cards, parseCard(t, code))
+	}
+	return cards
+}
+
+// parseCard 解析 Card.Code 格式的简写
+func parseCard(t *testing.T, code string) Card {
+	t.Helper()
+
+	for suit := Club; suit <= Spade; suit++ {
+		for rank := Ace; rank <= King; rank++ {
+			card := Card{Suit: suit, Rank: rank}
+			if card.Code() == code {
+				return card
+			}
+		}
+	}
+	t.Fatalf("无效的牌: %q", code)
+	return Card{}
+}
+
+func TestCalculateHandValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		cards     []string
+		want      int
+		bust      bool
+		blackjack bool
+	}{
+		{name: "空手牌", cards: nil, want: 0},
+		{name: "普通牌", cards: []string{"7c", "9d"}, want: 16},
+		{name: "人头牌算10点", cards: []string{"Jh", "Qs", "Kc"}, want: 30, bust: true},
+		{name: "三张21点不是blackjack", cards: []string{"7c", "7d", "7h"}, want: 21},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cards := hand(t, tt.cards...)
+			if got := CalculateHandValue(cards); got != tt.want {
+				t.Errorf("CalculateHandValue = %d, want %d", got, tt.want)
+			}
+			if got := IsBust(cards); got != tt.bust {
+				t.Errorf("IsBust = %v, want %v", got, tt.bust)
+			}
+			if got := IsBlackjack(cards); got != tt.blackjack {
+				t.Errorf("IsBlackjack = %v, want %v", got, tt.blackjack)
+			}
+		})
+	}
+}
+
+func TestCardNames(t *testing.T) {
+	tests := []struct {
+		card Card
+		css  string
+		code string
+	}{
+		{Card{Suit: Spade, Rank: Ace}, "pk-spadeA", "As"},
+		{Card{Suit: Heart, Rank: Ten}, "pk-heart10", "Th"},
+		{Card{Suit: Diamond, Rank: Seven}, "pk-diamond7", "7d"},
+		{Card{Suit: Club, Rank: King}, "pk-clubK", "Kc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := tt.card.String(); got != tt.css {
+				t.Errorf("String = %q, want %q", got, tt.css)
+			}
+			if got := tt.card.Code(); got != tt.code {
+				t.Errorf("Code = %q, want %q", got, tt.code)
+			}
+		})
+	}
+}
+
+func TestNewDeck(t *testing.T) {
+	deck := NewDeck(NewSeededRandomSource(1))
+	if deck.Remaining() != 52 {
+		t.Fatalf("Remaining = %d, want 52", deck.Remaining())
+	}
+
+	seen := make(map[Card]bool)
+	for deck.Remaining() > 0 {
+		next := deck.Peek()
+		card := deck.Deal()
+		if card != next {
+			t.Fatalf("Deal = %v, Peek 返回 %v", card, next)
+		}
+		if seen[card] {
+			t.Fatalf("重复的牌 %v", card)
+		}
+		seen[card] = true
+	}
+	if len(seen) != 52 {
+		t.Errorf("发出 %d 张不同的牌, want 52", len(seen))
+	}
+	if card := deck.Deal(); card != (Card{}) {
+		t.Errorf("空牌组 Deal = %v, want 零值", card)
+	}
+}
+
+func TestDeckSameSeedSameOrder(t *testing.T) {
+	a := NewDeck(NewSeededRandomSource(42)).Cards()
+	b := NewDeck(NewSeededRandomSource(42)).Cards()
+	if !reflect.DeepEqual(a, b) {
+		t.Error("相同种子洗出的牌序不同")
+	}
+
+	c := NewDeck(NewSeededRandomSource(43)).Cards()
+	if reflect.DeepEqual(a, c) {
+		t.Error("不同种子洗出了相同的牌序")
+	}
+}
+
+func TestDeckFromCardsKeepsOrder(t *testing.T) {
+	cards := hand(t, "As", "Kd", "7c", "2h")
+	deck := NewDeckFromCards(cards)
+	cards[0] = Card{} // 不与调用方共享
+
+	deck.Shuffle()
+	if got, want := deck.Cards(), hand(t, "As", "Kd", "7c", "2h"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Shuffle 后牌序 = %v, want %v", got, want)
+	}
+	if got := deck.Deal(); got.Code() != "2h" {
+		t.Errorf("Deal = %s, want 2h（最后一张最先发出）", got.Code())
+	}
+}
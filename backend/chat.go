@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// chatHistorySize 每个房间保留的最近聊天条数
+const chatHistorySize = 50
+
+// ChatKind 聊天消息的种类
+type ChatKind string
+
+const (
+	ChatPlayer  ChatKind = "player"  // 玩家发给全房间
+	ChatWhisper ChatKind = "whisper" // 玩家私聊，只有双方收到
+	ChatSystem  ChatKind = "system"  // 系统消息（加入、离开、结算）
+)
+
+// 系统消息的事件
+const (
+	chatEventJoin   = "join"
+	chatEventLeave  = "leave"
+	chatEventRename = "rename"
+	chatEventResult = "result"
+)
+
+// chatLog 房间最近的聊天记录（在房间协程中使用）
+type chatLog struct {
+	entries []ChatResponse
+	seq     uint64 // 最后一条聊天的序号
+}
+
+// add 记录一条聊天并分配序号和时间，超出容量时丢弃最早的记录
+func (l *chatLog) add(entry ChatResponse) ChatResponse {
+	l.seq++
+	entry.ID = l.seq
+	entry.Time = time.Now()
+
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > chatHistorySize {
+		l.entries = append([]ChatResponse(nil), l.entries[len(l.entries)-chatHistorySize:]...)
+	}
+	return entry
+}
+
+// visibleTo 玩家能看到的聊天记录（私聊只有双方可见）
+func (l *chatLog) visibleTo(playerID string) []ChatResponse {
+	entries := make([]ChatResponse, 0, len(l.entries))
+	for _, entry := range l.entries {
+		if entry.Kind == ChatWhisper && entry.PlayerID != playerID && entry.To != playerID {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Chat 发送聊天：to 为空时发给全房间，否则私聊同房间的玩家
+func (r *Room) Chat(playerID, to, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return &validationError{Fields: []FieldError{{Field: "message", Code: "required"}}}
+	}
+
+	err := errRoomClosed
+	r.do(func() {
+		player, ok := r.Players[playerID]
+		if !ok {
+			err = newError(ErrPlayerNotFound)
+			return
+		}
+
+		entry := ChatResponse{
+			Kind:     ChatPlayer,
+			PlayerID: player.ID,
+			Nickname: player.Nickname,
+			Message:  text,
+		}
+
+		if to != "" {
+			target, ok := r.Players[to]
+			if !ok || to == playerID {
+				err = newError(ErrWhisperTarget)
+				return
+			}
+			entry.Kind = ChatWhisper
+			entry.To = target.ID
+			entry.ToNickname = target.Nickname
+		}
+		err = nil
+
+		entry = r.chat.add(entry)
+		msg := Message{Type: TypeChat, Data: toJSON(entry)}
+		if entry.Kind == ChatWhisper {
+			// 私聊不是房间广播，不占用状态版本
+			r.deliver(roomMessage{To: entry.To, Msg: msg})
+			r.deliver(roomMessage{To: entry.PlayerID, Msg: msg})
+			return
+		}
+		r.deliver(roomMessage{Msg: msg})
+	})
+	return err
+}
+
+// systemChat 记录一条系统消息并返回要广播的消息（在房间协程中调用）
+func (r *Room) systemChat(event, text string) roomMessage {
+	entry := r.chat.add(ChatResponse{
+		Kind:    ChatSystem,
+		Event:   event,
+		Message: text,
+	})
+	return roomMessage{Msg: Message{Type: TypeChat, Data: toJSON(entry)}}
+}
+
+// resultChat 结算的系统消息内容
+func resultChat(results []RoundResult) string {
+	var winners []string
+	for _, result := range results {
+		if result.IsWinner {
+			winners = append(winners, fmt.Sprintf("%s（%d点）", result.Nickname, result.Score))
+		}
+	}
+	if len(winners) == 0 {
+		return "本局结束，没有赢家"
+	}
+	return "本局结束，" + strings.Join(winners, "、") + " 获胜"
+}
+
+// chatHistoryMessage 构造发给玩家的最近聊天记录（在房间协程中调用）
+func chatHistoryMessage(room *Room, playerID string) Message {
+	return Message{
+		Type: TypeChatHistory,
+		Data: toJSON(ChatHistoryResponse{
+			Messages: room.chat.visibleTo(playerID),
+		}),
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// chatsOf 连接收到的聊天消息
+func chatsOf(t *testing.T, conn *WebSocketConn) []ChatResponse {
+	t.Helper()
+
+	var chats []ChatResponse
+	for _, msg := range received(conn) {
+		if msg.Type != TypeChat {
+			continue
+		}
+		var chat ChatResponse
+		if err := json.Unmarshal(msg.Data, &chat); err != nil {
+			t.Fatal(err)
+		}
+		chats = append(chats, chat)
+	}
+	return chats
+}
+
+// chatMessages 聊天的 种类:内容 列表
+func chatMessages(chats []ChatResponse) []string {
+	texts := make([]string, 0, len(chats))
+	for _, chat := range chats {
+		texts = append(texts, string(chat.Kind)+":"+chat.Message)
+	}
+	return texts
+}
+
+// newChatRoom 创建有 n 个已连接玩家的房间，返回各玩家的连接（已取出加入时的消息）
+func newChatRoom(t *testing.T, n int) (*Room, []*WebSocketConn) {
+	t.Helper()
+
+	room := NewRoom("ROOM", NewSeededRandomSource(1))
+	t.Cleanup(room.Stop)
+	conns := make([]*WebSocketConn, n)
+	for i, id := range playerIDs(n) {
+		conns[i] = newTestConn(ProtocolVersion)
+		if err := room.AddPlayer(id, "玩家"+strconv.Itoa(i), conns[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, conn := range conns {
+		received(conn)
+	}
+	return room, conns
+}
+
+func TestChatWhisper(t *testing.T) {
+	room, conns := newChatRoom(t, 3)
+
+	if err := room.Chat("p0", "", "大家好"); err != nil {
+		t.Fatal(err)
+	}
+	if err := room.Chat("p0", "p1", "悄悄话"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"player:大家好", "whisper:悄悄话"},
+		{"player:大家好", "whisper:悄悄话"},
+		{"player:大家好"},
+	}
+	for i, conn := range conns {
+		if got := chatMessages(chatsOf(t, conn)); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("p%d 收到 %v, want %v", i, got, want[i])
+		}
+	}
+
+	// 重新连接时的聊天记录同样只含自己可见的私聊
+	for i, id := range playerIDs(3) {
+		var history []ChatResponse
+		room.do(func() { history = room.chat.visibleTo(id) })
+		var got []string
+		for _, chat := range chatMessages(history) {
+			if chat == "player:大家好" || chat == "whisper:悄悄话" {
+				got = append(got, chat)
+			}
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("%s 的聊天记录 %v, want %v", id, got, want[i])
+		}
+	}
+}
+
+func TestChatErrors(t *testing.T) {
+	room, conns := newChatRoom(t, 2)
+
+	tests := []struct {
+		name     string
+		playerID string
+		to       string
+		text     string
+		want     ErrorCode
+	}{
+		{name: "私聊自己", playerID: "p0", to: "p0", text: "hi", want: ErrWhisperTarget},
+		{name: "私聊不在房间的玩家", playerID: "p0", to: "p9", text: "hi", want: ErrWhisperTarget},
+		{name: "不在房间中", playerID: "p9", text: "hi", want: ErrPlayerNotFound},
+		{name: "只有空白", playerID: "p0", text: "  ", want: ErrInvalidPayload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := asGameError(room.Chat(tt.playerID, tt.to, tt.text)).Code; got != tt.want {
+				t.Errorf("错误 = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	for i, conn := range conns {
+		if got := chatsOf(t, conn); len(got) != 0 {
+			t.Errorf("p%d 收到 %v", i, chatMessages(got))
+		}
+	}
+}
+
+func TestChatLogCap(t *testing.T) {
+	var log chatLog
+	for i := 1; i <= chatHistorySize+10; i++ {
+		entry := log.add(ChatResponse{Kind: ChatPlayer, Message: strconv.Itoa(i)})
+		if entry.ID != uint64(i) {
+			t.Fatalf("第%d条的序号 %d", i, entry.ID)
+		}
+	}
+
+	entries := log.visibleTo("p0")
+	if len(entries) != chatHistorySize {
+		t.Fatalf("保留 %d 条, want %d", len(entries), chatHistorySize)
+	}
+	if first, last := entries[0].ID, entries[len(entries)-1].ID; first != 11 || last != chatHistorySize+10 {
+		t.Errorf("保留的序号 %d..%d, want 11..%d", first, last, chatHistorySize+10)
+	}
+}
+
+func TestSystemChat(t *testing.T) {
+	room, conns := newChatRoom(t, 2)
+
+	conn := newTestConn(ProtocolVersion)
+	if err := room.AddPlayer("p2", "玩家2", conn); err != nil {
+		t.Fatal(err)
+	}
+	room.RemovePlayer("p1")
+
+	got := chatsOf(t, conns[0])
+	want := []string{"system:玩家2 加入了房间", "system:玩家1 离开了房间"}
+	if !reflect.DeepEqual(chatMessages(got), want) {
+		t.Fatalf("收到 %v, want %v", chatMessages(got), want)
+	}
+	events := []string{got[0].Event, got[1].Event}
+	if !reflect.DeepEqual(events, []string{chatEventJoin, chatEventLeave}) {
+		t.Errorf("事件 %v", events)
+	}
+	if got[0].ID >= got[1].ID || got[0].PlayerID != "" {
+		t.Errorf("系统消息 %+v", got)
+	}
+}
+
+func TestResultChat(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []RoundResult
+		want    string
+	}{
+		{name: "没有赢家", results: []RoundResult{{Nickname: "甲", Score: 25}}, want: "本局结束，没有赢家"},
+		{
+			name:    "一个赢家",
+			results: []RoundResult{{Nickname: "甲", Score: 20, IsWinner: true}, {Nickname: "乙", Score: 18}},
+			want:    "本局结束，甲（20点） 获胜",
+		},
+		{
+			name:    "多个赢家",
+			results: []RoundResult{{Nickname: "甲", Score: 21, IsWinner: true}, {Nickname: "乙", Score: 21, IsWinner: true}},
+			want:    "本局结束，甲（21点）、乙（21点） 获胜",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := resultChat(tt.results); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// 消息编码对应的 WebSocket 子协议
+const (
+	SubprotocolJSON     = "blackjack.json"
+	SubprotocolMsgpack  = "blackjack.msgpack"
+	SubprotocolProtobuf = "blackjack.protobuf"
+)
+
+// Codec 消息的线上编码，连接建立时按 WebSocket 子协议选定
+// 各编码的消息集合完全相同：信封字段与 Message 一致，data 与 JSON 编码下的结构相同
+type Codec interface {
+	Subprotocol() string
+	FrameType() int // websocket.TextMessage 或 websocket.BinaryMessage
+	Encode(msg Message) ([]byte, error)
+	Decode(data []byte) (Message, error)
+}
+
+// codecs 支持的编码，按服务器的偏好排序（客户端同时请求多个子协议时选靠前的）
+var codecs = []Codec{msgpackCodec{}, protobufCodec{}, jsonCodec{}}
+
+// subprotocols 握手时可协商的子协议
+func subprotocols() []string {
+	names := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		names = append(names, c.Subprotocol())
+	}
+	return names
+}
+
+// codecFor 子协议对应的编码，未协商子协议时使用 JSON
+func codecFor(subprotocol string) Codec {
+	for _, c := range codecs {
+		if c.Subprotocol() == subprotocol {
+			return c
+		}
+	}
+	return jsonCodec{}
+}
+
+// ---- JSON ----
+
+// jsonCodec JSON 文本帧（浏览器默认使用）
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return SubprotocolJSON }
+func (jsonCodec) FrameType() int      { return websocket.TextMessage }
+
+func (jsonCodec) Encode(msg Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Decode(data []byte) (Message, error) {
+	var msg Message
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// ---- MessagePack ----
+
+// msgpackCodec MessagePack 二进制帧，字段名与 JSON 相同
+type msgpackCodec struct{}
+
+// wireMessage 二进制编码的信封，data 为通用值而不是 JSON 文本
+type wireMessage struct {
+	Type      MessageType  `json:"type"`
+	RequestID string       `json:"requestId,omitempty"`
+	Version   uint64       `json:"version,omitempty"`
+	Data      interface{}  `json:"data,omitempty"`
+	Code      ErrorCode    `json:"code,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+func (msgpackCodec) Subprotocol() string { return SubprotocolMsgpack }
+func (msgpackCodec) FrameType() int      { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(msg Message) ([]byte, error) {
+	data, err := genericValue(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	err = enc.Encode(wireMessage{
+		Type:      msg.Type,
+		RequestID: msg.RequestID,
+		Version:   msg.Version,
+		Data:      data,
+		Code:      msg.Code,
+		Error:     msg.Error,
+		Fields:    msg.Fields,
+	})
+	return buf.Bytes(), err
+}
+
+func (msgpackCodec) Decode(data []byte) (Message, error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+
+	var wire wireMessage
+	if err := dec.Decode(&wire); err != nil {
+		return Message{}, err
+	}
+	return wire.message()
+}
+
+// message 转换为 Message，data 重新编码为 JSON 供请求解析使用
+func (w wireMessage) message() (Message, error) {
+	msg := Message{
+		Type:      w.Type,
+		RequestID: w.RequestID,
+		Version:   w.Version,
+		Code:      w.Code,
+		Error:     w.Error,
+		Fields:    w.Fields,
+	}
+	if w.Data != nil {
+		data, err := json.Marshal(w.Data)
+		if err != nil {
+			return Message{}, err
+		}
+		msg.Data = data
+	}
+	return msg, nil
+}
+
+// ---- Protocol Buffers ----
+
+// protobufCodec Protocol Buffers 二进制帧，消息定义见 blackjack.proto
+type protobufCodec struct{}
+
+// Envelope 和 FieldError 的字段编号（与 blackjack.proto 一致）
+const (
+	envelopeType      protowire.Number = 1
+	envelopeRequestID protowire.Number = 2
+	envelopeVersion   protowire.Number = 3
+	envelopeData      protowire.Number = 4
+	envelopeCode      protowire.Number = 5
+	envelopeError     protowire.Number = 6
+	envelopeFields    protowire.Number = 7
+	envelopeSnapshot  protowire.Number = 8
+	envelopeCardDealt protowire.Number = 9
+	envelopePlayers   protowire.Number = 10
+
+	fieldErrorField   protowire.Number = 1
+	fieldErrorCode    protowire.Number = 2
+	fieldErrorMessage protowire.Number = 3
+)
+
+func (protobufCodec) Subprotocol() string { return SubprotocolProtobuf }
+func (protobufCodec) FrameType() int      { return websocket.BinaryMessage }
+
+func (protobufCodec) Encode(msg Message) ([]byte, error) {
+	var b []byte
+	b = appendString(b, envelopeType, string(msg.Type))
+	b = appendString(b, envelopeRequestID, msg.RequestID)
+	if msg.Version != 0 {
+		b = protowire.AppendTag(b, envelopeVersion, protowire.VarintType)
+		b = protowire.AppendVarint(b, msg.Version)
+	}
+
+	if p, ok := typedPayloads[msg.Type]; ok && len(msg.Data) > 0 {
+		data, err := p.encode(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, p.num, protowire.BytesType)
+		b = protowire.AppendBytes(b, data)
+	} else if len(msg.Data) > 0 {
+		generic, err := genericValue(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		value, err := structpb.NewValue(generic)
+		if err != nil {
+			return nil, err
+		}
+		data, err := proto.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, envelopeData, protowire.BytesType)
+		b = protowire.AppendBytes(b, data)
+	}
+
+	b = appendString(b, envelopeCode, string(msg.Code))
+	b = appendString(b, envelopeError, msg.Error)
+	for _, f := range msg.Fields {
+		var fb []byte
+		fb = appendString(fb, fieldErrorField, f.Field)
+		fb = appendString(fb, fieldErrorCode, f.Code)
+		fb = appendString(fb, fieldErrorMessage, f.Message)
+		b = protowire.AppendTag(b, envelopeFields, protowire.BytesType)
+		b = protowire.AppendBytes(b, fb)
+	}
+	return b, nil
+}
+
+func (protobufCodec) Decode(data []byte) (Message, error) {
+	var msg Message
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == envelopeVersion && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			msg.Version = v
+			return n, protowire.ParseError(n)
+
+		case typ == protowire.BytesType && num >= envelopeType && num <= envelopePlayers:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, protowire.ParseError(n)
+			}
+			return n, decodeEnvelopeField(&msg, num, v)
+		}
+
+		n := protowire.ConsumeFieldValue(num, typ, b)
+		return n, protowire.ParseError(n)
+	})
+	return msg, err
+}
+
+// decodeEnvelopeField 解析信封中长度前缀类型的字段
+func decodeEnvelopeField(msg *Message, num protowire.Number, v []byte) error {
+	switch num {
+	case envelopeType:
+		msg.Type = MessageType(v)
+	case envelopeRequestID:
+		msg.RequestID = string(v)
+	case envelopeCode:
+		msg.Code = ErrorCode(v)
+	case envelopeError:
+		msg.Error = string(v)
+
+	case envelopeData:
+		var value structpb.Value
+		if err := proto.Unmarshal(v, &value); err != nil {
+			return err
+		}
+		data, err := json.Marshal(value.AsInterface())
+		if err != nil {
+			return err
+		}
+		msg.Data = data
+
+	case envelopeFields:
+		var f FieldError
+		err := consumeFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+			if typ != protowire.BytesType {
+				n := protowire.ConsumeFieldValue(num, typ, b)
+				return n, protowire.ParseError(n)
+			}
+			s, n := protowire.ConsumeString(b)
+			switch num {
+			case fieldErrorField:
+				f.Field = s
+			case fieldErrorCode:
+				f.Code = s
+			case fieldErrorMessage:
+				f.Message = s
+			}
+			return n, protowire.ParseError(n)
+		})
+		if err != nil {
+			return err
+		}
+		msg.Fields = append(msg.Fields, f)
+
+	default:
+		for _, p := range typedPayloads {
+			if p.num != num {
+				continue
+			}
+			data, err := p.decode(v)
+			if err != nil {
+				return err
+			}
+			msg.Data = data
+		}
+	}
+	return nil
+}
+
+// consumeFields 依次读取字段，fn 返回该字段值占用的字节数
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// appendString 追加字符串字段（空字符串按 proto3 规则省略）
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// genericValue 将 JSON 文本转换为通用值（map、切片、字符串、布尔、int64、float64），整数保持为整数
+func genericValue(raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("解析消息数据失败: %w", err)
+	}
+	return convertNumbers(v), nil
+}
+
+// convertNumbers 将 json.Number 转换为 int64 或 float64
+func convertNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = convertNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertNumbers(item)
+		}
+	}
+	return v
+}
+
+// ---- Protocol Buffers 专用消息 ----
+
+// typedPayload 有专用 protobuf 消息的类型：data 编码到信封的 num 字段，而不是通用的 data
+type typedPayload struct {
+	num    protowire.Number
+	encode func(data json.RawMessage) ([]byte, error)
+	decode func(b []byte) (json.RawMessage, error)
+}
+
+// typedPayloads 高频消息使用专用消息，省去 google.protobuf.Value 的字段名和类型开销
+var typedPayloads = map[MessageType]typedPayload{
+	TypeSnapshot: {
+		num: envelopeSnapshot,
+		encode: encodeTyped(func(s SnapshotResponse) []byte {
+			var b []byte
+			b = appendString(b, 1, s.RoomID)
+			b = appendInt(b, 2, int64(s.Status))
+			b = appendInt(b, 3, int64(s.Round))
+			for _, p := range s.Players {
+				b = appendMessage(b, 4, encodePlayerInfo(p))
+			}
+			return b
+		}),
+		decode: decodeTyped(func(b []byte) (SnapshotResponse, error) {
+			s := SnapshotResponse{Players: []PlayerInfo{}}
+			err := consumeMessage(b, func(num protowire.Number, v uint64) {
+				switch num {
+				case 2:
+					s.Status = GameStatus(int32(v))
+				case 3:
+					s.Round = int(int32(v))
+				}
+			}, func(num protowire.Number, v []byte) error {
+				switch num {
+				case 1:
+					s.RoomID = string(v)
+				case 4:
+					p, err := decodePlayerInfo(v)
+					if err != nil {
+						return err
+					}
+					s.Players = append(s.Players, p)
+				}
+				return nil
+			})
+			return s, err
+		}),
+	},
+	TypeCardDealt: {
+		num: envelopeCardDealt,
+		encode: encodeTyped(func(c CardDealtResponse) []byte {
+			var b []byte
+			b = appendInt(b, 1, int64(c.Seat))
+			b = appendString(b, 2, c.PlayerID)
+			b = appendString(b, 3, c.Card)
+			b = appendInt(b, 4, int64(c.CardCount))
+			b = appendInt(b, 5, int64(c.HandValue))
+			return b
+		}),
+		decode: decodeTyped(func(b []byte) (CardDealtResponse, error) {
+			var c CardDealtResponse
+			err := consumeMessage(b, func(num protowire.Number, v uint64) {
+				switch num {
+				case 1:
+					c.Seat = int(int32(v))
+				case 4:
+					c.CardCount = int(int32(v))
+				case 5:
+					c.HandValue = int(int32(v))
+				}
+			}, func(num protowire.Number, v []byte) error {
+				switch num {
+				case 2:
+					c.PlayerID = string(v)
+				case 3:
+					c.Card = string(v)
+				}
+				return nil
+			})
+			return c, err
+		}),
+	},
+	TypePlayers: {
+		num: envelopePlayers,
+		encode: encodeTyped(func(r PlayersResponse) []byte {
+			var b []byte
+			for _, p := range r.Players {
+				b = appendMessage(b, 1, encodePlayerInfo(p))
+			}
+			return b
+		}),
+		decode: decodeTyped(func(b []byte) (PlayersResponse, error) {
+			r := PlayersResponse{Players: []PlayerInfo{}}
+			err := consumeMessage(b, nil, func(num protowire.Number, v []byte) error {
+				if num != 1 {
+					return nil
+				}
+				p, err := decodePlayerInfo(v)
+				if err != nil {
+					return err
+				}
+				r.Players = append(r.Players, p)
+				return nil
+			})
+			return r, err
+		}),
+	},
+}
+
+// encodeTyped 将 JSON 格式的 data 解析为 T 后用 encode 编码
+func encodeTyped[T any](encode func(T) []byte) func(json.RawMessage) ([]byte, error) {
+	return func(data json.RawMessage) ([]byte, error) {
+		var v T
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("解析消息数据失败: %w", err)
+		}
+		return encode(v), nil
+	}
+}
+
+// decodeTyped 用 decode 解析专用消息后转换回 JSON 格式的 data
+func decodeTyped[T any](decode func([]byte) (T, error)) func([]byte) (json.RawMessage, error) {
+	return func(b []byte) (json.RawMessage, error) {
+		v, err := decode(b)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	}
+}
+
+// encodePlayerInfo 编码 blackjack.PlayerInfo
+func encodePlayerInfo(p PlayerInfo) []byte {
+	var b []byte
+	b = appendString(b, 1, p.ID)
+	b = appendString(b, 2, p.Nickname)
+	for _, c := range p.Cards {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, c)
+	}
+	b = appendInt(b, 4, int64(p.CardCount))
+	b = appendInt(b, 5, int64(p.HandValue))
+	b = appendString(b, 6, p.Status)
+	b = appendString(b, 7, p.StatusColor)
+	if p.Online {
+		b = appendInt(b, 8, 1)
+	}
+	if s := p.Stats; s != nil {
+		var sb []byte
+		sb = appendInt(sb, 1, int64(s.Hands))
+		sb = appendInt(sb, 2, int64(s.Wins))
+		sb = appendInt(sb, 3, int64(s.Losses))
+		sb = appendInt(sb, 4, int64(s.Pushes))
+		sb = appendInt(sb, 5, int64(s.Blackjacks))
+		sb = appendInt(sb, 6, int64(s.Busts))
+		sb = appendInt(sb, 7, int64(s.TotalValue))
+		if s.AverageValue != 0 {
+			sb = protowire.AppendTag(sb, 8, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(s.AverageValue))
+		}
+		sb = appendInt(sb, 9, s.BiggestWin)
+		sb = appendInt(sb, 10, s.NetChips)
+		if !s.UpdatedAt.IsZero() {
+			sb = appendString(sb, 11, s.UpdatedAt.Format(time.RFC3339Nano))
+		}
+		b = appendMessage(b, 9, sb)
+	}
+	return b
+}
+
+// decodePlayerInfo 解析 blackjack.PlayerInfo
+func decodePlayerInfo(b []byte) (PlayerInfo, error) {
+	p := PlayerInfo{Cards: []string{}}
+	err := consumeMessage(b, func(num protowire.Number, v uint64) {
+		switch num {
+		case 4:
+			p.CardCount = int(int32(v))
+		case 5:
+			p.HandValue = int(int32(v))
+		case 8:
+			p.Online = v != 0
+		}
+	}, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			p.ID = string(v)
+		case 2:
+			p.Nickname = string(v)
+		case 3:
+			p.Cards = append(p.Cards, string(v))
+		case 6:
+			p.Status = string(v)
+		case 7:
+			p.StatusColor = string(v)
+		case 9:
+			s, err := decodePlayerStats(v)
+			if err != nil {
+				return err
+			}
+			p.Stats = &s
+		}
+		return nil
+	})
+	return p, err
+}
+
+// decodePlayerStats 解析 blackjack.PlayerStats
+func decodePlayerStats(b []byte) (PlayerStats, error) {
+	var s PlayerStats
+	var updatedAt string
+	err := consumeMessage(b, func(num protowire.Number, v uint64) {
+		switch num {
+		case 1:
+			s.Hands = int(int32(v))
+		case 2:
+			s.Wins = int(int32(v))
+		case 3:
+			s.Losses = int(int32(v))
+		case 4:
+			s.Pushes = int(int32(v))
+		case 5:
+			s.Blackjacks = int(int32(v))
+		case 6:
+			s.Busts = int(int32(v))
+		case 7:
+			s.TotalValue = int(int32(v))
+		case 8:
+			s.AverageValue = math.Float64frombits(v)
+		case 9:
+			s.BiggestWin = int64(v)
+		case 10:
+			s.NetChips = int64(v)
+		}
+	}, func(num protowire.Number, v []byte) error {
+		if num == 11 {
+			updatedAt = string(v)
+		}
+		return nil
+	})
+	if err != nil || updatedAt == "" {
+		return s, err
+	}
+	s.UpdatedAt, err = time.Parse(time.RFC3339Nano, updatedAt)
+	return s, err
+}
+
+// consumeMessage 读取消息的字段：varint 和 fixed64 字段的原始值交给 scalar，
+// 长度前缀字段交给 nested，其他字段跳过；回调为 nil 时跳过对应字段
+func consumeMessage(b []byte, scalar func(num protowire.Number, v uint64), nested func(num protowire.Number, v []byte) error) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n >= 0 && scalar != nil {
+				scalar(num, v)
+			}
+			return n, protowire.ParseError(n)
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n >= 0 && scalar != nil {
+				scalar(num, v)
+			}
+			return n, protowire.ParseError(n)
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 || nested == nil {
+				return n, protowire.ParseError(n)
+			}
+			return n, nested(num, v)
+		}
+		n := protowire.ConsumeFieldValue(num, typ, b)
+		return n, protowire.ParseError(n)
+	})
+}
+
+// appendInt 追加 int32/int64 字段（0 按 proto3 规则省略，负数按补码编码）
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// appendMessage 追加嵌套消息字段（空消息也保留，用于 repeated 字段）
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
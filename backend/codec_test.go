@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// codecMessages 编解码往返测试用的消息
+func codecMessages() []Message {
+	stats := &PlayerStats{
+		Hands:        3,
+		Wins:         1,
+		Losses:       2,
+		TotalValue:   55,
+		AverageValue: 55.0 / 3,
+		BiggestWin:   10,
+		NetChips:     -10,
+		UpdatedAt:    time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC),
+	}
+	players := []PlayerInfo{
+		{ID: "p1", Nickname: "小明", Cards: []string{"pk-spadeA", "pk-heart10"}, CardCount: 2, HandValue: 21, Status: "已停牌", StatusColor: "#5cb85c", Online: true, Stats: stats},
+		{ID: "p2", Nickname: "Bob", Cards: []string{"pk-clubK", "pk-hide"}, CardCount: 2, Status: "操作中", StatusColor: "#337ab7"},
+		{ID: "p3", Nickname: "空手", Cards: []string{}},
+	}
+
+	return []Message{
+		{Type: TypeHello, Data: toJSON(HelloRequest{Versions: []int{1, 2, 3}, Client: "test", Locale: "en"})},
+		{Type: TypeHit, RequestID: "req-1", Data: toJSON(RoomActionRequest{RoomID: "ABCD", PlayerID: "p1"})},
+		{Type: TypeSnapshot, Version: 42, Data: toJSON(SnapshotResponse{RoomID: "ABCD", Status: GamePlaying, Round: 3, Players: players})},
+		{Type: TypeSnapshot, Version: 1, Data: toJSON(SnapshotResponse{RoomID: "ABCD", Players: []PlayerInfo{}})},
+		{Type: TypeCardDealt, Version: 43, Data: toJSON(CardDealtResponse{Seat: 1, PlayerID: "p2", Card: "pk-hide", CardCount: 3})},
+		{Type: TypeCardDealt, Version: 44, Data: toJSON(CardDealtResponse{Seat: 0, PlayerID: "p1", Card: "pk-diamond7", CardCount: 3, HandValue: 18})},
+		{Type: TypePlayers, Version: 45, Data: toJSON(PlayersResponse{Players: players})},
+		{Type: TypePlayers, Data: toJSON(PlayersResponse{Players: []PlayerInfo{}})},
+		{Type: TypeChat, Data: json.RawMessage(`{"nested":{"list":[1,2.5,"x",true,null]},"count":-7}`)},
+		{Type: TypeError, RequestID: "req-2", Code: ErrInvalidPayload, Error: "请求参数错误", Fields: []FieldError{
+			{Field: "roomId", Code: "required", Message: "不能为空"},
+			{Field: "nickname", Code: "maxLength", Message: "最多32个字符"},
+		}},
+		{Type: TypeAck},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range codecs {
+		t.Run(codec.Subprotocol(), func(t *testing.T) {
+			for _, msg := range codecMessages() {
+				data, err := codec.Encode(msg)
+				if err != nil {
+					t.Fatalf("Encode(%s): %v", msg.Type, err)
+				}
+				got, err := codec.Decode(data)
+				if err != nil {
+					t.Fatalf("Decode(%s): %v", msg.Type, err)
+				}
+
+				if !sameJSON(t, got.Data, msg.Data) {
+					t.Errorf("%s: data = %s, want %s", msg.Type, got.Data, msg.Data)
+				}
+				got.Data, msg.Data = nil, nil
+				if !reflect.DeepEqual(got, msg) {
+					t.Errorf("%s: 信封 = %+v, want %+v", msg.Type, got, msg)
+				}
+			}
+		})
+	}
+}
+
+// TestProtobufTypedPayloads 高频消息使用专用字段，不再带通用的 data
+func TestProtobufTypedPayloads(t *testing.T) {
+	want := map[MessageType]protowire.Number{
+		TypeSnapshot:  envelopeSnapshot,
+		TypeCardDealt: envelopeCardDealt,
+		TypePlayers:   envelopePlayers,
+		TypeHello:     envelopeData,
+		TypeChat:      envelopeData,
+	}
+
+	for _, msg := range codecMessages() {
+		num, ok := want[msg.Type]
+		if !ok {
+			continue
+		}
+		data, err := protobufCodec{}.Encode(msg)
+		if err != nil {
+			t.Fatalf("Encode(%s): %v", msg.Type, err)
+		}
+
+		var fields []protowire.Number
+		err = consumeFields(data, func(n protowire.Number, typ protowire.Type, b []byte) (int, error) {
+			fields = append(fields, n)
+			l := protowire.ConsumeFieldValue(n, typ, b)
+			return l, protowire.ParseError(l)
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", msg.Type, err)
+		}
+
+		found := false
+		for _, n := range fields {
+			if n == num {
+				found = true
+			}
+			if n != num && n >= envelopeData && n != envelopeCode && n != envelopeError && n != envelopeFields {
+				t.Errorf("%s: 多余的数据字段 %d", msg.Type, n)
+			}
+		}
+		if !found {
+			t.Errorf("%s: 缺少数据字段 %d，实际字段 %v", msg.Type, num, fields)
+		}
+	}
+}
+
+// TestProtobufNegativeSeat 负数按补码编码，解码后保持原值
+func TestProtobufNegativeSeat(t *testing.T) {
+	msg := Message{Type: TypeCardDealt, Data: toJSON(CardDealtResponse{Seat: -1, PlayerID: "gone", Card: "pk-hide", CardCount: 1})}
+	data, err := protobufCodec{}.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := protobufCodec{}.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameJSON(t, got.Data, msg.Data) {
+		t.Errorf("data = %s, want %s", got.Data, msg.Data)
+	}
+}
+
+func TestCodecDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		codec Codec
+		data  []byte
+	}{
+		{jsonCodec{}, []byte(`{"type":`)},
+		{msgpackCodec{}, []byte{0xc1}},
+		{protobufCodec{}, []byte{0x0a, 0x05, 'a'}},                                          // 长度超出消息
+		{protobufCodec{}, []byte{0x42, 0x02, 0x0a, 0x09}},                                   // 快照中的字符串长度超出
+		{protobufCodec{}, []byte{0x22, 0x03, 0xff, 0xff, 0xff}},                             // data 不是合法的 Value
+		{protobufCodec{}, []byte{0x52, 0x08, 0x0a, 0x06, 0x4a, 0x04, 0x5a, 0x02, 'x', 'y'}}, // 玩家统计的更新时间不合法
+		{protobufCodec{}, []byte{0x52, 0x04, 0x0a, 0x02, 0x4a, 0x01}},                       // 玩家统计的长度超出
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.codec.Decode(tt.data); err == nil {
+			t.Errorf("%s: Decode(%x) 没有返回错误", tt.codec.Subprotocol(), tt.data)
+		}
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		subprotocol string
+		want        string
+		frame       int
+	}{
+		{"", SubprotocolJSON, websocket.TextMessage},
+		{"unknown", SubprotocolJSON, websocket.TextMessage},
+		{SubprotocolJSON, SubprotocolJSON, websocket.TextMessage},
+		{SubprotocolMsgpack, SubprotocolMsgpack, websocket.BinaryMessage},
+		{SubprotocolProtobuf, SubprotocolProtobuf, websocket.BinaryMessage},
+	}
+
+	for _, tt := range tests {
+		codec := codecFor(tt.subprotocol)
+		if codec.Subprotocol() != tt.want || codec.FrameType() != tt.frame {
+			t.Errorf("codecFor(%q) = %s/%d, want %s/%d", tt.subprotocol, codec.Subprotocol(), codec.FrameType(), tt.want, tt.frame)
+		}
+	}
+}
+
+// sameJSON 两段 JSON 表示的值是否相同（忽略键的顺序；数字按文本比较，避免大整数丢失精度）
+func sameJSON(t *testing.T, a, b json.RawMessage) bool {
+	t.Helper()
+
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	va, err := genericValue(a)
+	if err != nil {
+		t.Fatalf("解析 %s: %v", a, err)
+	}
+	vb, err := genericValue(b)
+	if err != nil {
+		t.Fatalf("解析 %s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// protoEnvelope 由 blackjack.proto 编译出的 Envelope 描述，codec.go 手写的字段编号以它为准
+func protoEnvelope(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{}),
+	}
+	files, err := compiler.Compile(context.Background(), "blackjack.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := files[0].Messages().ByName("Envelope")
+	if envelope == nil {
+		t.Fatal("blackjack.proto 中没有 Envelope")
+	}
+	return envelope
+}
+
+// protoPayloadFields 有专用消息的类型在 .proto 中对应的 oneof 字段（JSON 名）
+var protoPayloadFields = map[MessageType]string{
+	TypeSnapshot:  "snapshot",
+	TypeCardDealt: "cardDealt",
+	TypePlayers:   "players",
+}
+
+// protoEnvelopeJSON 消息按 .proto 的 JSON 映射表示：data 放到专用字段中（如果有）
+func protoEnvelopeJSON(t *testing.T, msg Message) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := protoPayloadFields[msg.Type]; ok {
+		fields[name] = fields["data"]
+		delete(fields, "data")
+	}
+	data, err = json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestProtobufMatchesProtoFile 用 blackjack.proto 编译出的描述解码 codec 的输出、编码 codec 的输入，两者不能不一致
+func TestProtobufMatchesProtoFile(t *testing.T) {
+	envelope := protoEnvelope(t)
+	codec := protobufCodec{}
+
+	for _, msg := range codecMessages() {
+		t.Run(string(msg.Type), func(t *testing.T) {
+			want := dynamicpb.NewMessage(envelope)
+			if err := protojson.Unmarshal(protoEnvelopeJSON(t, msg), want); err != nil {
+				t.Fatalf("按 .proto 解析 JSON: %v", err)
+			}
+
+			// codec 编码 → .proto 解码：字段编号、类型一致，且没有 .proto 之外的字段
+			data, err := codec.Encode(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := dynamicpb.NewMessage(envelope)
+			if err := proto.Unmarshal(data, got); err != nil {
+				t.Fatalf("按 .proto 解码: %v", err)
+			}
+			if !proto.Equal(got, want) {
+				t.Errorf("按 .proto 解码 = %v\nwant %v", got, want)
+			}
+
+			// .proto 编码 → codec 解码
+			data, err = proto.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := codec.Decode(data)
+			if err != nil {
+				t.Fatalf("codec 解码 .proto 的编码: %v", err)
+			}
+			if !sameJSON(t, decoded.Data, msg.Data) {
+				t.Errorf("data = %s, want %s", decoded.Data, msg.Data)
+			}
+			decoded.Data, msg.Data = nil, nil
+			if !reflect.DeepEqual(decoded, msg) {
+				t.Errorf("信封 = %+v, want %+v", decoded, msg)
+			}
+		})
+	}
+}
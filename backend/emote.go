@@ -0,0 +1,100 @@
+package main
+
+import "time"
+
+// emoteCooldown 同一玩家两次表情之间的最短间隔
+const emoteCooldown = 3 * time.Second
+
+// Emote 服务器定义的快捷表情
+type Emote struct {
+	ID   string
+	Icon string
+	Text map[Locale]string
+}
+
+// emoteCatalogue 表情目录（按显示顺序）
+var emoteCatalogue = []Emote{
+	{ID: "nice", Icon: "👍", Text: map[Locale]string{LocaleZH: "漂亮！", LocaleEN: "Nice!"}},
+	{ID: "unlucky", Icon: "😢", Text: map[Locale]string{LocaleZH: "可惜了", LocaleEN: "Unlucky"}},
+	{ID: "lucky", Icon: "🍀", Text: map[Locale]string{LocaleZH: "运气真好", LocaleEN: "Lucky!"}},
+	{ID: "wow", Icon: "😮", Text: map[Locale]string{LocaleZH: "哇！", LocaleEN: "Wow!"}},
+	{ID: "thinking", Icon: "🤔", Text: map[Locale]string{LocaleZH: "让我想想…", LocaleEN: "Hmm..."}},
+	{ID: "hurry", Icon: "⏰", Text: map[Locale]string{LocaleZH: "快点吧", LocaleEN: "Hurry up!"}},
+	{ID: "laugh", Icon: "😂", Text: map[Locale]string{LocaleZH: "哈哈", LocaleEN: "Haha"}},
+	{ID: "gg", Icon: "🤝", Text: map[Locale]string{LocaleZH: "打得好", LocaleEN: "Good game"}},
+}
+
+// findEmote 按ID查找表情
+func findEmote(id string) (Emote, bool) {
+	for _, emote := range emoteCatalogue {
+		if emote.ID == id {
+			return emote, true
+		}
+	}
+	return Emote{}, false
+}
+
+// emoteList 按语言生成的表情目录
+func emoteList(locale Locale) []EmoteInfo {
+	list := make([]EmoteInfo, 0, len(emoteCatalogue))
+	for _, emote := range emoteCatalogue {
+		text, ok := emote.Text[locale]
+		if !ok {
+			text = emote.Text[DefaultLocale]
+		}
+		list = append(list, EmoteInfo{ID: emote.ID, Icon: emote.Icon, Text: text})
+	}
+	return list
+}
+
+// Emote 发送快捷表情：广播给房间里的所有连接和观众，不占用状态版本，也不进入聊天记录
+func (r *Room) Emote(playerID, emoteID string) error {
+	emote, ok := findEmote(emoteID)
+	if !ok {
+		return newError(ErrUnknownEmote, emoteID)
+	}
+
+	err := errRoomClosed
+	r.do(func() {
+		player, ok := r.Players[playerID]
+		if !ok {
+			err = newError(ErrPlayerNotFound)
+			return
+		}
+
+		now := time.Now()
+		if wait := r.emotes[playerID].Add(emoteCooldown).Sub(now); wait > 0 {
+			err = newError(ErrEmoteCooldown, int((wait+time.Second-1)/time.Second))
+			return
+		}
+		r.emotes[playerID] = now
+		err = nil
+
+		msg := Message{Type: TypeEmote, Data: toJSON(EmoteResponse{
+			Seat:     r.seatOf(playerID),
+			PlayerID: player.ID,
+			Nickname: player.Nickname,
+			Emote:    emote.ID,
+			Icon:     emote.Icon,
+		})}
+		r.deliver(roomMessage{Ephemeral: true, Msg: msg})
+		for conn := range r.spectators {
+			conn.Send(msg)
+		}
+	})
+	return err
+}
+
+// Watch 回放本房间的牌局期间把观众连接加入房间，观众收到房间里的表情
+func (r *Room) Watch(conn *WebSocketConn) {
+	r.do(func() {
+		r.spectators[conn] = struct{}{}
+	})
+}
+
+// Unwatch 回放结束后移除观众连接
+func (r *Room) Unwatch(conn *WebSocketConn) {
+	r.do(func() {
+		delete(r.spectators, conn)
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// emotesOf 连接收到的表情ID
+func emotesOf(conn *WebSocketConn) []string {
+	var emotes []string
+	for _, msg := range received(conn) {
+		if msg.Type == TypeEmote {
+			var emote EmoteResponse
+			if err := json.Unmarshal(msg.Data, &emote); err == nil {
+				emotes = append(emotes, emote.PlayerID+":"+emote.Emote)
+			}
+		}
+	}
+	return emotes
+}
+
+func TestEmoteCooldown(t *testing.T) {
+	room, conns := newChatRoom(t, 2)
+
+	if err := room.Emote("p0", "nice"); err != nil {
+		t.Fatal(err)
+	}
+	err := room.Emote("p0", "wow")
+	if gerr := asGameError(err); gerr.Code != ErrEmoteCooldown || !reflect.DeepEqual(gerr.Args, []interface{}{3}) {
+		t.Fatalf("冷却中: %v", err)
+	}
+	// 冷却按玩家计算
+	if err := room.Emote("p1", "gg"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 冷却即将结束时仍要等待，向上取整到秒
+	room.do(func() { room.emotes["p0"] = time.Now().Add(-emoteCooldown + 100*time.Millisecond) })
+	if gerr := asGameError(room.Emote("p0", "wow")); gerr.Code != ErrEmoteCooldown || !reflect.DeepEqual(gerr.Args, []interface{}{1}) {
+		t.Fatalf("冷却即将结束: %v", gerr)
+	}
+	room.do(func() { room.emotes["p0"] = time.Now().Add(-emoteCooldown) })
+	if err := room.Emote("p0", "wow"); err != nil {
+		t.Fatalf("冷却结束后: %v", err)
+	}
+
+	want := []string{"p0:nice", "p1:gg", "p0:wow"}
+	for i, conn := range conns {
+		if got := emotesOf(conn); !reflect.DeepEqual(got, want) {
+			t.Errorf("p%d 收到 %v, want %v", i, got, want)
+		}
+	}
+
+	// 表情不占用状态版本，也不进入聊天记录
+	var version uint64
+	var history []ChatResponse
+	room.do(func() { version, history = room.version, room.chat.visibleTo("p0") })
+	for _, chat := range history {
+		if chat.Kind != ChatSystem {
+			t.Errorf("聊天记录中有 %+v", chat)
+		}
+	}
+	if got := room.Resync("p0", version, conns[0]); got != nil {
+		t.Fatal(got)
+	}
+	if got := emotesOf(conns[0]); len(got) != 0 {
+		t.Errorf("断档补发了表情 %v", got)
+	}
+}
+
+func TestEmoteErrors(t *testing.T) {
+	room, _ := newChatRoom(t, 1)
+
+	if got := asGameError(room.Emote("p0", "nope")).Code; got != ErrUnknownEmote {
+		t.Errorf("未知表情: %s", got)
+	}
+	if got := asGameError(room.Emote("p9", "nice")).Code; got != ErrPlayerNotFound {
+		t.Errorf("不在房间中: %s", got)
+	}
+}
+
+func TestEmoteSpectators(t *testing.T) {
+	room, conns := newChatRoom(t, 1)
+	spectator := newTestConn(ProtocolVersion)
+
+	room.Watch(spectator)
+	if err := room.Emote("p0", "nice"); err != nil {
+		t.Fatal(err)
+	}
+	room.Unwatch(spectator)
+	room.do(func() { room.emotes["p0"] = time.Time{} })
+	if err := room.Emote("p0", "gg"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := emotesOf(spectator), []string{"p0:nice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("观众收到 %v, want %v", got, want)
+	}
+	if got, want := emotesOf(conns[0]), []string{"p0:nice", "p0:gg"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("玩家收到 %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ErrorCode 稳定的错误码，客户端按错误码判断错误类型，错误描述只用于展示
+type ErrorCode string
+
+const (
+	ErrInvalidPayload     ErrorCode = "INVALID_PAYLOAD"        // 请求格式错误或字段不合法
+	ErrUnknownMessageType ErrorCode = "UNKNOWN_MESSAGE_TYPE"   // 未知消息类型
+	ErrUnsupportedVersion ErrorCode = "UNSUPPORTED_VERSION"    // 没有共同的协议版本
+	ErrRoomNotFound       ErrorCode = "ROOM_NOT_FOUND"         // 房间不存在
+	ErrRoomFull           ErrorCode = "ROOM_FULL"              // 房间已满
+	ErrRoomClosed         ErrorCode = "ROOM_CLOSED"            // 房间已关闭
+	ErrPlayerExists       ErrorCode = "PLAYER_EXISTS"          // 玩家已在房间中
+	ErrPlayerNotFound     ErrorCode = "PLAYER_NOT_FOUND"       // 玩家不存在
+	ErrWhisperTarget      ErrorCode = "INVALID_WHISPER_TARGET" // 私聊对象不在房间中
+	ErrGameInProgress     ErrorCode = "GAME_IN_PROGRESS"       // 游戏已在进行中
+	ErrGameNotInProgress  ErrorCode = "GAME_NOT_IN_PROGRESS"   // 游戏未进行中
+	ErrNotEnoughPlayers   ErrorCode = "NOT_ENOUGH_PLAYERS"     // 玩家人数不足
+	ErrNotYourTurn        ErrorCode = "NOT_YOUR_TURN"          // 当前不能执行该操作
+	ErrHistoryNotFound    ErrorCode = "HISTORY_NOT_FOUND"      // 牌局记录不存在
+	ErrReplayFailed       ErrorCode = "REPLAY_FAILED"          // 回放失败
+	ErrTooManyReplays     ErrorCode = "TOO_MANY_REPLAYS"       // 连接已有进行中的回放，或服务器同时回放的数量已满
+	ErrServerClosing      ErrorCode = "SERVER_CLOSING"         // 服务器正在关闭
+	ErrServerBusy         ErrorCode = "SERVER_BUSY"            // 服务器连接数已满
+	ErrOriginNotAllowed   ErrorCode = "ORIGIN_NOT_ALLOWED"     // 来源不在允许列表中
+	ErrTooManyConnections ErrorCode = "TOO_MANY_CONNECTIONS"   // 同一IP的连接过多
+	ErrRateLimited        ErrorCode = "RATE_LIMITED"           // 请求过于频繁
+	ErrChatMuted          ErrorCode = "CHAT_MUTED"             // 聊天刷屏被临时禁言
+	ErrChatRejected       ErrorCode = "CHAT_REJECTED"          // 聊天内容未通过审核
+	ErrUnauthorized       ErrorCode = "UNAUTHORIZED"           // 玩家ID或会话令牌无效
+	ErrNotAuthenticated   ErrorCode = "NOT_AUTHENTICATED"      // 连接还没有通过 connect 验证身份
+	ErrForbidden          ErrorCode = "FORBIDDEN"              // 以其他玩家的身份操作
+	ErrInvalidCredentials ErrorCode = "INVALID_CREDENTIALS"    // 用户名或密码错误
+	ErrUsernameTaken      ErrorCode = "USERNAME_TAKEN"         // 用户名已被注册
+	ErrAlreadyRegistered  ErrorCode = "ALREADY_REGISTERED"     // 身份已经是注册账号
+	ErrUnknownEmote       ErrorCode = "UNKNOWN_EMOTE"          // 表情不在目录中
+	ErrEmoteCooldown      ErrorCode = "EMOTE_COOLDOWN"         // 表情发送过于频繁
+	ErrMethodNotAllowed   ErrorCode = "METHOD_NOT_ALLOWED"     // HTTP方法不支持
+	ErrInternal           ErrorCode = "INTERNAL_ERROR"         // 服务器内部错误
+)
+
+// Locale 错误描述的语言
+type Locale string
+
+const (
+	LocaleZH Locale = "zh"
+	LocaleEN Locale = "en"
+)
+
+// DefaultLocale 未指定语言时使用中文
+const DefaultLocale = LocaleZH
+
+// errorMessages 错误描述，参数按 fmt 格式填入
+var errorMessages = map[ErrorCode]map[Locale]string{
+	ErrInvalidPayload:     {LocaleZH: "请求参数错误", LocaleEN: "Invalid request payload"},
+	ErrUnknownMessageType: {LocaleZH: "未知消息类型: %v", LocaleEN: "Unknown message type: %v"},
+	ErrUnsupportedVersion: {LocaleZH: "不支持的协议版本，服务器支持 %v", LocaleEN: "Unsupported protocol version, server supports %v"},
+	ErrRoomNotFound:       {LocaleZH: "房间不存在", LocaleEN: "Room not found"},
+	ErrRoomFull:           {LocaleZH: "房间已满", LocaleEN: "Room is full"},
+	ErrRoomClosed:         {LocaleZH: "房间已关闭", LocaleEN: "Room is closed"},
+	ErrPlayerExists:       {LocaleZH: "玩家已存在", LocaleEN: "Player already in room"},
+	ErrPlayerNotFound:     {LocaleZH: "玩家不存在", LocaleEN: "Player not found"},
+	ErrWhisperTarget:      {LocaleZH: "私聊对象不在房间中", LocaleEN: "Whisper target is not in this room"},
+	ErrGameInProgress:     {LocaleZH: "游戏已在进行中", LocaleEN: "Game already in progress"},
+	ErrGameNotInProgress:  {LocaleZH: "游戏未进行中", LocaleEN: "Game is not in progress"},
+	ErrNotEnoughPlayers:   {LocaleZH: "至少需要%v个玩家", LocaleEN: "At least %v player(s) required"},
+	ErrNotYourTurn:        {LocaleZH: "当前不能操作", LocaleEN: "You cannot act right now"},
+	ErrHistoryNotFound:    {LocaleZH: "牌局记录不存在", LocaleEN: "Hand history not found"},
+	ErrReplayFailed:       {LocaleZH: "回放失败: %v", LocaleEN: "Replay failed: %v"},
+	ErrTooManyReplays:     {LocaleZH: "回放过多，请等当前回放结束后再试", LocaleEN: "Too many replays, try again after the current one finishes"},
+	ErrServerClosing:      {LocaleZH: "服务器正在关闭", LocaleEN: "Server is shutting down"},
+	ErrServerBusy:         {LocaleZH: "服务器繁忙，请稍后再试", LocaleEN: "Server is busy, please try again later"},
+	ErrOriginNotAllowed:   {LocaleZH: "不允许的来源", LocaleEN: "Origin not allowed"},
+	ErrTooManyConnections: {LocaleZH: "连接数过多", LocaleEN: "Too many connections"},
+	ErrRateLimited:        {LocaleZH: "操作过于频繁，请稍后再试", LocaleEN: "Too many requests, please slow down"},
+	ErrChatMuted:          {LocaleZH: "发言过于频繁，%v秒内不能发言", LocaleEN: "You are sending messages too fast and are muted for %v seconds"},
+	ErrChatRejected:       {LocaleZH: "消息包含不允许的内容", LocaleEN: "Message contains content that is not allowed"},
+	ErrUnknownEmote:       {LocaleZH: "未知表情: %v", LocaleEN: "Unknown emote: %v"},
+	ErrUnauthorized:       {LocaleZH: "身份验证失败，请重新登录或获取游客身份", LocaleEN: "Invalid player ID or session token"},
+	ErrNotAuthenticated:   {LocaleZH: "请先发送 connect 验证身份", LocaleEN: "Send connect to authenticate first"},
+	ErrForbidden:          {LocaleZH: "不能以其他玩家的身份操作", LocaleEN: "You cannot act as another player"},
+	ErrInvalidCredentials: {LocaleZH: "用户名或密码错误", LocaleEN: "Invalid username or password"},
+	ErrUsernameTaken:      {LocaleZH: "用户名已被注册", LocaleEN: "Username is already taken"},
+	ErrAlreadyRegistered:  {LocaleZH: "已经是注册账号", LocaleEN: "This identity is already a registered account"},
+	ErrEmoteCooldown:      {LocaleZH: "表情发送过于频繁，请%v秒后再试", LocaleEN: "Emotes are on cooldown, try again in %v seconds"},
+	ErrMethodNotAllowed:   {LocaleZH: "不支持的请求方法", LocaleEN: "Method not allowed"},
+	ErrInternal:           {LocaleZH: "服务器内部错误", LocaleEN: "Internal server error"},
+}
+
+// fieldMessages 字段错误描述，按 FieldError.Code 查找
+var fieldMessages = map[string]map[Locale]string{
+	"required":  {LocaleZH: "不能为空", LocaleEN: "is required"},
+	"minLength": {LocaleZH: "长度不能少于%v", LocaleEN: "must be at least %v characters"},
+	"maxLength": {LocaleZH: "长度不能超过%v", LocaleEN: "must be at most %v characters"},
+	"min":       {LocaleZH: "不能小于%v", LocaleEN: "must be at least %v"},
+	"max":       {LocaleZH: "不能大于%v", LocaleEN: "must be at most %v"},
+	"type":      {LocaleZH: "类型错误，应为%v", LocaleEN: "must be of type %v"},
+	"invalid":   {LocaleZH: "无效的数据格式", LocaleEN: "is not valid JSON"},
+
+	"nicknameChars": {LocaleZH: "只能包含文字、数字、空格和 %v", LocaleEN: "may only contain letters, digits, spaces and %v"},
+	"reserved":      {LocaleZH: "是保留名称，不能使用", LocaleEN: "is a reserved name"},
+	"usernameChars": {LocaleZH: "只能包含英文字母、数字和下划线", LocaleEN: "may only contain letters, digits and underscores"},
+	"maxBytes":      {LocaleZH: "不能超过%v字节", LocaleEN: "must be at most %v bytes"},
+	"oneOf":         {LocaleZH: "必须是以下之一：%v", LocaleEN: "must be one of: %v"},
+}
+
+// errorStatus 错误码对应的HTTP状态码（未列出的为400）
+var errorStatus = map[ErrorCode]int{
+	ErrRoomNotFound:       http.StatusNotFound,
+	ErrPlayerNotFound:     http.StatusNotFound,
+	ErrHistoryNotFound:    http.StatusNotFound,
+	ErrRoomFull:           http.StatusConflict,
+	ErrPlayerExists:       http.StatusConflict,
+	ErrGameInProgress:     http.StatusConflict,
+	ErrServerClosing:      http.StatusServiceUnavailable,
+	ErrServerBusy:         http.StatusServiceUnavailable,
+	ErrOriginNotAllowed:   http.StatusForbidden,
+	ErrTooManyConnections: http.StatusTooManyRequests,
+	ErrRateLimited:        http.StatusTooManyRequests,
+	ErrChatMuted:          http.StatusTooManyRequests,
+	ErrEmoteCooldown:      http.StatusTooManyRequests,
+	ErrTooManyReplays:     http.StatusTooManyRequests,
+	ErrUnauthorized:       http.StatusUnauthorized,
+	ErrNotAuthenticated:   http.StatusUnauthorized,
+	ErrForbidden:          http.StatusForbidden,
+	ErrInvalidCredentials: http.StatusUnauthorized,
+	ErrUsernameTaken:      http.StatusConflict,
+	ErrAlreadyRegistered:  http.StatusConflict,
+	ErrMethodNotAllowed:   http.StatusMethodNotAllowed,
+	ErrInternal:           http.StatusInternalServerError,
+}
+
+// errorCodes 全部错误码（按字母排序）
+func errorCodes() []ErrorCode {
+	codes := make([]ErrorCode, 0, len(errorMessages))
+	for code := range errorMessages {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
+
+// GameError 带错误码的错误
+type GameError struct {
+	Code ErrorCode
+	Args []interface{} // 填入错误描述的参数
+}
+
+// newError 创建带错误码的错误
+func newError(code ErrorCode, args ...interface{}) *GameError {
+	return &GameError{Code: code, Args: args}
+}
+
+// Error 返回默认语言的错误描述（用于日志）
+func (e *GameError) Error() string {
+	return e.Message(DefaultLocale)
+}
+
+// Message 返回指定语言的错误描述
+func (e *GameError) Message(locale Locale) string {
+	return localize(errorMessages[e.Code], locale, e.Args...)
+}
+
+// HTTPStatus 错误码对应的HTTP状态码
+func (e *GameError) HTTPStatus() int {
+	if status, ok := errorStatus[e.Code]; ok {
+		return status
+	}
+	return http.StatusBadRequest
+}
+
+// asGameError 将任意错误转换为带错误码的错误，未知错误作为内部错误
+func asGameError(err error) *GameError {
+	var gerr *GameError
+	if errors.As(err, &gerr) {
+		return gerr
+	}
+
+	var verr *validationError
+	if errors.As(err, &verr) {
+		return newError(ErrInvalidPayload)
+	}
+
+	return newError(ErrInternal)
+}
+
+// localizeFields 按语言填写字段错误描述
+func localizeFields(fields []FieldError, locale Locale) []FieldError {
+	out := make([]FieldError, 0, len(fields))
+	for _, f := range fields {
+		f.Message = localize(fieldMessages[f.Code], locale, f.Args...)
+		out = append(out, f)
+	}
+	return out
+}
+
+// localize 取指定语言的描述（没有时回退到默认语言）并填入参数
+func localize(messages map[Locale]string, locale Locale, args ...interface{}) string {
+	format, ok := messages[locale]
+	if !ok {
+		format = messages[DefaultLocale]
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// parseLocale 解析语言标签或 Accept-Language 头，取第一个支持的语言
+func parseLocale(value string) (Locale, bool) {
+	for _, part := range strings.Split(value, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		switch Locale(lang) {
+		case LocaleZH, LocaleEN:
+			return Locale(lang), true
+		}
+	}
+	return "", false
+}
+
+// requestLocale HTTP请求的语言：优先 lang 参数，其次 Accept-Language
+func requestLocale(r *http.Request) Locale {
+	if locale, ok := parseLocale(r.URL.Query().Get("lang")); ok {
+		return locale
+	}
+	if locale, ok := parseLocale(r.Header.Get("Accept-Language")); ok {
+		return locale
+	}
+	return DefaultLocale
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// snapshotInterval 每产生多少个事件生成一次快照
+const snapshotInterval = 100
+
+// EventType 房间事件类型
+type EventType string
+
+const (
+	EventPlayerJoined  EventType = "playerJoined"
+	EventPlayerLeft    EventType = "playerLeft"
+	EventPlayerRenamed EventType = "playerRenamed"
+	EventRoundStarted  EventType = "roundStarted"
+	EventCardDealt     EventType = "cardDealt"
+	EventPlayerStood   EventType = "playerStood"
+	EventRoundSettled  EventType = "roundSettled"
+)
+
+// RoomEvent 房间领域事件（创建后不可修改）
+type RoomEvent interface {
+	EventType() EventType
+}
+
+// PlayerJoined 玩家加入房间
+type PlayerJoined struct {
+	PlayerID string `json:"playerId"`
+	Nickname string `json:"nickname"`
+}
+
+// PlayerLeft 玩家离开房间
+type PlayerLeft struct {
+	PlayerID string `json:"playerId"`
+	Nickname string `json:"nickname,omitempty"`
+}
+
+// PlayerRenamed 玩家重新连接时换了昵称
+type PlayerRenamed struct {
+	PlayerID string `json:"playerId"`
+	Previous string `json:"previous"`
+	Nickname string `json:"nickname"`
+}
+
+// RoundStarted 新一局开始，携带洗牌后的完整牌序
+type RoundStarted struct {
+	Round     int    `json:"round"`
+	StartedBy string `json:"startedBy"`
+	RequestID string `json:"requestId,omitempty"`
+	Deck      []Card `json:"deck"`
+}
+
+// CardDealt 给玩家发了一张牌（Initial 表示开局发牌）
+type CardDealt struct {
+	PlayerID  string `json:"playerId"`
+	Card      Card   `json:"card"`
+	Initial   bool   `json:"initial,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// PlayerStood 玩家停牌
+type PlayerStood struct {
+	PlayerID  string `json:"playerId"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// RoundSettled 本局结算
+type RoundSettled struct {
+	Results []RoundResult `json:"results"`
+}
+
+func (PlayerJoined) EventType() EventType  { return EventPlayerJoined }
+func (PlayerLeft) EventType() EventType    { return EventPlayerLeft }
+func (PlayerRenamed) EventType() EventType { return EventPlayerRenamed }
+func (RoundStarted) EventType() EventType  { return EventRoundStarted }
+func (CardDealt) EventType() EventType     { return EventCardDealt }
+func (PlayerStood) EventType() EventType   { return EventPlayerStood }
+func (RoundSettled) EventType() EventType  { return EventRoundSettled }
+
+// EventRecord 事件记录（带序号和时间）
+type EventRecord struct {
+	Seq   uint64
+	Time  time.Time
+	Event RoomEvent
+}
+
+// eventRecordJSON 事件记录的JSON格式
+type eventRecordJSON struct {
+	Seq  uint64          `json:"seq"`
+	Time time.Time       `json:"time"`
+	Type EventType       `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// MarshalJSON 序列化事件记录
+func (rec EventRecord) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(rec.Event)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(eventRecordJSON{
+		Seq:  rec.Seq,
+		Time: rec.Time,
+		Type: rec.Event.EventType(),
+		Data: data,
+	})
+}
+
+// UnmarshalJSON 反序列化事件记录
+func (rec *EventRecord) UnmarshalJSON(b []byte) error {
+	var raw eventRecordJSON
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	var event RoomEvent
+	switch raw.Type {
+	case EventPlayerJoined:
+		var e PlayerJoined
+		if err := json.Unmarshal(raw.Data, &e); err != nil {
+			return err
+		}
+		event = e
+	case EventPlayerLeft:
+		var e PlayerLeft
+		if err := json.Unmarshal(raw.Data, &e); err != nil {
+			return err
+		}
+		event = e
+	case EventPlayerRenamed:
+		var e PlayerRenamed
+		if err := json.Unmarshal(raw.Data, &e); err != nil {
+			return err
+		}
+		event = e
+	case EventRoundStarted:
+		var e RoundStarted
+		if err := json.Unmarshal(raw.Data, &e); err != nil {
+			return err
+		}
+		event = e
+	case EventCardDealt:
+		var e CardDealt
+		if err := json.Unmarshal(raw.Data, &e); err != nil {
+			return err
+		}
+		event = e
+	case EventPlayerStood:
+		var e PlayerStood
+		if err := json.Unmarshal(raw.Data, &e); err != nil {
+			return err
+		}
+		event = e
+	case EventRoundSettled:
+		var e RoundSettled
+		if err := json.Unmarshal(raw.Data, &e); err != nil {
+			return err
+		}
+		event = e
+	default:
+		return fmt.Errorf("未知事件类型: %s", raw.Type)
+	}
+
+	rec.Seq = raw.Seq
+	rec.Time = raw.Time
+	rec.Event = event
+	return nil
+}
+
+// PlayerSnapshot 玩家状态快照
+type PlayerSnapshot struct {
+	ID       string       `json:"id"`
+	Nickname string       `json:"nickname"`
+	Cards    []Card       `json:"cards"`
+	Status   PlayerStatus `json:"status"`
+}
+
+// RoomSnapshot 房间状态快照（Seq 为快照包含的最后一个事件序号）
+type RoomSnapshot struct {
+	Seq         uint64           `json:"seq"`
+	RoomID      string           `json:"roomId"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	Status      GameStatus       `json:"status"`
+	Round       int              `json:"round"`
+	CurrentTurn int              `json:"currentTurn"`
+	Players     []PlayerSnapshot `json:"players"` // 按座位顺序
+	Deck        []Card           `json:"deck"`
+	History     *HandHistory     `json:"history,omitempty"`
+	Histories   []*HandHistory   `json:"histories"`
+	Requests    []string         `json:"requests,omitempty"` // 本局已执行的请求键
+}
+
+// RestoreRoom 由快照和快照之后的事件重建房间
+func RestoreRoom(snapshot *RoomSnapshot, events []EventRecord, rng RandomSource) *Room {
+	r := newRoom(snapshot.RoomID, rng)
+	r.CreatedAt = snapshot.CreatedAt
+	r.Status = snapshot.Status
+	r.Round = snapshot.Round
+	r.CurrentTurn = snapshot.CurrentTurn
+	r.seq = snapshot.Seq
+	r.histories = append([]*HandHistory(nil), snapshot.Histories...)
+	if snapshot.History != nil {
+		r.history = snapshot.History.clone()
+	}
+
+	if snapshot.Deck != nil {
+		r.Deck = NewDeckFromCards(snapshot.Deck)
+	}
+
+	for _, key := range snapshot.Requests {
+		r.requests[key] = struct{}{}
+	}
+
+	for _, ps := range snapshot.Players {
+		player := NewPlayer(ps.ID, ps.Nickname)
+		player.RoomID = r.ID
+		player.Cards = append(player.Cards, ps.Cards...)
+		player.HandValue = CalculateHandValue(player.Cards)
+		player.Status = ps.Status
+		r.Players[player.ID] = player
+		r.seatOrder = append(r.seatOrder, player.ID)
+	}
+
+	snapshotCopy := *snapshot
+	r.snapshot = &snapshotCopy
+
+	for _, rec := range events {
+		if rec.Seq <= r.seq {
+			continue
+		}
+		r.seq = rec.Seq
+		r.apply(rec)
+		r.events = append(r.events, rec)
+	}
+
+	// 恢复的玩家都没有连接，宽限期内没有重新加入的玩家自动停牌
+	r.scheduleAutoStand()
+	go r.run()
+	return r
+}
+
+// State 获取可持久化的房间状态（快照和之后的事件取自同一时刻）
+func (r *Room) State() *RoomState {
+	var state *RoomState
+	r.do(func() {
+		state = r.state()
+	})
+	return state
+}
+
+// state 获取可持久化的房间状态（在房间协程中调用）
+func (r *Room) state() *RoomState {
+	snapshot := r.snapshot
+	if snapshot == nil {
+		// 尚未生成快照时，以空房间作为起点
+		snapshot = &RoomSnapshot{
+			RoomID:    r.ID,
+			CreatedAt: r.CreatedAt,
+			Status:    GameWaiting,
+			Players:   []PlayerSnapshot{},
+			Histories: []*HandHistory{},
+		}
+	}
+
+	return &RoomState{
+		Snapshot: snapshot,
+		Events:   append([]EventRecord(nil), r.events...),
+	}
+}
+
+// takeSnapshot 生成快照并截断事件日志（在房间协程中调用）
+func (r *Room) takeSnapshot() {
+	players := make([]PlayerSnapshot, 0, len(r.seatOrder))
+	for _, player := range r.orderedPlayers() {
+		players = append(players, PlayerSnapshot{
+			ID:       player.ID,
+			Nickname: player.Nickname,
+			Cards:    append([]Card(nil), player.Cards...),
+			Status:   player.Status,
+		})
+	}
+
+	var deck []Card
+	if r.Deck != nil {
+		deck = r.Deck.Cards()
+	}
+
+	var history *HandHistory
+	if r.history != nil {
+		history = r.history.clone()
+	}
+
+	requests := make([]string, 0, len(r.requests))
+	for key := range r.requests {
+		requests = append(requests, key)
+	}
+	sort.Strings(requests)
+
+	r.snapshot = &RoomSnapshot{
+		Seq:         r.seq,
+		RoomID:      r.ID,
+		CreatedAt:   r.CreatedAt,
+		Status:      r.Status,
+		Round:       r.Round,
+		CurrentTurn: r.CurrentTurn,
+		Players:     players,
+		Deck:        deck,
+		History:     history,
+		Histories:   append([]*HandHistory(nil), r.histories...),
+		Requests:    requests,
+	}
+	r.events = nil
+}
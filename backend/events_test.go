@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// roomView 用于比较的房间状态
+type roomView struct {
+	Status    GameStatus
+	Round     int
+	Seq       uint64
+	Players   []Player
+	Deck      []Card
+	Histories int
+	Requests  map[string]struct{}
+}
+
+// viewOf 取房间状态用于比较
+func viewOf(room *Room) roomView {
+	var v roomView
+	room.do(func() {
+		v = roomView{Status: room.Status, Round: room.Round, Seq: room.seq, Histories: len(room.histories), Requests: make(map[string]struct{})}
+		for key := range room.requests {
+			v.Requests[key] = struct{}{}
+		}
+		for _, player := range room.orderedPlayers() {
+			p := *player.clone()
+			p.LastActive, p.Conn = time.Time{}, nil // 不属于持久化状态
+			v.Players = append(v.Players, p)
+		}
+		if room.Deck != nil {
+			v.Deck = room.Deck.Cards()
+		}
+	})
+	return v
+}
+
+// restore 模拟保存到文件再恢复：状态经过JSON往返后重建房间
+func restore(t *testing.T, room *Room) *Room {
+	t.Helper()
+
+	data, err := json.Marshal(room.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state RoomState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := RestoreRoom(state.Snapshot, state.Events, NewSeededRandomSource(1))
+	t.Cleanup(restored.Stop)
+	return restored
+}
+
+func TestRestoreRoom(t *testing.T) {
+	tests := []struct {
+		name string
+		play func(t *testing.T, room *Room)
+	}{
+		{name: "空房间", play: func(t *testing.T, room *Room) {}},
+		{name: "结算后", play: func(t *testing.T, room *Room) {
+			playRound(t, room, [][]string{{"Kc", "Qd"}, {"9c", "6d", "Kh"}})
+		}},
+		{name: "牌局进行中", play: func(t *testing.T, room *Room) {
+			if err := room.startGameWithDeck("p0", "start", riggedDeck(t, [][]string{{"2c", "3c", "4d"}, {"4c", "5c"}})); err != nil {
+				t.Fatal(err)
+			}
+			if err := room.PlayerHit("p0", "hit"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "玩家离开后", play: func(t *testing.T, room *Room) {
+			playRound(t, room, [][]string{{"Kc", "Qd"}, {"9c", "9d"}})
+			room.RemovePlayer("p0")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := newTestRoom(t, 2)
+			tt.play(t, room)
+
+			restored := restore(t, room)
+			if got, want := viewOf(restored), viewOf(room); !reflect.DeepEqual(got, want) {
+				t.Errorf("恢复的房间 = %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+// TestRestoreRoomDeduplicates 恢复后本局已执行的请求仍然去重
+func TestRestoreRoomDeduplicates(t *testing.T) {
+	room := newTestRoom(t, 1)
+	if err := room.startGameWithDeck("p0", "start", riggedDeck(t, [][]string{{"2c", "3c", "4d", "5d"}})); err != nil {
+		t.Fatal(err)
+	}
+	if err := room.PlayerHit("p0", "hit-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := restore(t, room)
+	if err := restored.PlayerHit("p0", "hit-1"); !errors.Is(err, errDuplicateRequest) {
+		t.Errorf("恢复后重复的请求 = %v, want errDuplicateRequest", err)
+	}
+	if err := restored.PlayerHit("p0", "hit-2"); err != nil {
+		t.Errorf("恢复后新的请求 = %v", err)
+	}
+	if got := restored.GetPlayer("p0").Cards; len(got) != 4 || got[3].Code() != "5d" {
+		t.Errorf("恢复后的手牌 = %v, want 按原牌序继续发牌", got)
+	}
+}
+
+// TestRoomSnapshots 事件数达到 snapshotInterval 时生成快照并截断事件日志，由快照加事件恢复出相同的状态
+func TestRoomSnapshots(t *testing.T) {
+	room := newTestRoom(t, 2)
+	hands := [][]string{{"Kc", "Qd"}, {"9c", "6d", "2h"}}
+
+	for room.Snapshot() == nil {
+		playRound(t, room, hands)
+	}
+	// 快照之后再产生一些事件，恢复时需要重放
+	playRound(t, room, hands)
+
+	snapshot, events := room.Snapshot(), room.Events()
+	if snapshot.Seq == 0 || len(events) == 0 || len(events) >= snapshotInterval {
+		t.Fatalf("快照序号 %d，之后 %d 个事件", snapshot.Seq, len(events))
+	}
+	if events[0].Seq != snapshot.Seq+1 {
+		t.Errorf("快照之后的第一个事件序号 = %d, want %d", events[0].Seq, snapshot.Seq+1)
+	}
+
+	restored := restore(t, room)
+	if got, want := viewOf(restored), viewOf(room); !reflect.DeepEqual(got, want) {
+		t.Errorf("恢复的房间 = %+v\nwant %+v", got, want)
+	}
+	if got, want := len(restored.Histories()), viewOf(room).Round; got != want {
+		t.Errorf("恢复的牌局记录 = %d, want %d", got, want)
+	}
+}
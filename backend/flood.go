@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 每个连接的消息速率限制
+var (
+	// connMessageLimit 所有类型的消息合计
+	connMessageLimit = rateLimit{Rate: 10, Burst: 20}
+
+	// typeMessageLimits 单类消息的限制（未列出的类型只受合计限制）
+	typeMessageLimits = map[MessageType]rateLimit{
+		TypeStart:  {Rate: 0.5, Burst: 2},
+		TypeHit:    {Rate: 3, Burst: 5},
+		TypeStand:  {Rate: 3, Burst: 5},
+		TypeChat:   {Rate: 1, Burst: 5},
+		TypeEmote:  {Rate: 1, Burst: 3},
+		TypeResync: {Rate: 1, Burst: 3},
+		TypeReplay: {Rate: 0.2, Burst: 2},
+	}
+
+	// abuseLimit 对超限消息的容忍度：每条超限消息消耗一个，耗尽时断开连接
+	abuseLimit = rateLimit{Rate: 0.2, Burst: 10}
+)
+
+// chatMuteDuration 聊天刷屏后的禁言时长
+const chatMuteDuration = 30 * time.Second
+
+// floodVerdict 限流检查结果
+type floodVerdict int
+
+const (
+	floodAllow      floodVerdict = iota // 正常处理
+	floodLimited                        // 超出速率，丢弃这条消息
+	floodMuted                          // 聊天禁言中，丢弃这条聊天
+	floodDisconnect                     // 持续超限，断开连接
+)
+
+// floodGuard 单个连接的消息限流状态（只在该连接的读取协程中使用）
+type floodGuard struct {
+	total      *tokenBucket
+	types      map[MessageType]*tokenBucket
+	abuse      *tokenBucket
+	mutedUntil time.Time // 聊天禁言截止时间
+}
+
+// newFloodGuard 创建连接的限流状态
+func newFloodGuard(now time.Time) *floodGuard {
+	g := &floodGuard{
+		total: newTokenBucket(connMessageLimit.Rate, connMessageLimit.Burst, now),
+		types: make(map[MessageType]*tokenBucket, len(typeMessageLimits)),
+		abuse: newTokenBucket(abuseLimit.Rate, abuseLimit.Burst, now),
+	}
+	for msgType, limit := range typeMessageLimits {
+		g.types[msgType] = newTokenBucket(limit.Rate, limit.Burst, now)
+	}
+	return g
+}
+
+// check 检查是否处理一条消息；聊天超限时开始禁言
+func (g *floodGuard) check(msgType MessageType, now time.Time) floodVerdict {
+	verdict := floodAllow
+	switch {
+	case msgType == TypeChat && now.Before(g.mutedUntil):
+		verdict = floodMuted
+	case !g.total.allow(now):
+		verdict = floodLimited
+	case g.types[msgType] != nil && !g.types[msgType].allow(now):
+		verdict = floodLimited
+		if msgType == TypeChat {
+			g.mutedUntil = now.Add(chatMuteDuration)
+			verdict = floodMuted
+		}
+	}
+
+	if verdict != floodAllow && !g.abuse.allow(now) {
+		return floodDisconnect
+	}
+	return verdict
+}
+
+// mutedFor 剩余禁言时长（向上取整到秒）
+func (g *floodGuard) mutedFor(now time.Time) int {
+	return int((g.mutedUntil.Sub(now) + time.Second - 1) / time.Second)
+}
+
+// FloodStats 消息限流统计
+type FloodStats struct {
+	Limited      uint64 `json:"limited"`      // 因超出速率被丢弃的消息
+	Muted        uint64 `json:"muted"`        // 禁言期间被丢弃的聊天
+	Disconnected uint64 `json:"disconnected"` // 因持续超限被断开的连接
+}
+
+// floodCounters 消息限流计数
+type floodCounters struct {
+	limited      atomic.Uint64
+	muted        atomic.Uint64
+	disconnected atomic.Uint64
+}
+
+// admitMessage 按连接的限流状态检查消息：超限时回复错误并丢弃，持续超限时断开连接
+func (rm *RoomManager) admitMessage(wsConn *WebSocketConn, msg Message) bool {
+	now := time.Now()
+	switch wsConn.flood.check(msg.Type, now) {
+	case floodAllow:
+		return true
+
+	case floodLimited:
+		rm.floods.limited.Add(1)
+		rm.sendError(wsConn, msg, newError(ErrRateLimited))
+
+	case floodMuted:
+		rm.floods.muted.Add(1)
+		rm.sendError(wsConn, msg, newError(ErrChatMuted, wsConn.flood.mutedFor(now)))
+
+	case floodDisconnect:
+		rm.floods.disconnected.Add(1)
+		playerID, _ := wsConn.identity()
+		log.Printf("连接消息持续超限，断开连接: player=%s type=%s", playerID, msg.Type)
+		rm.sendError(wsConn, msg, newError(ErrRateLimited))
+		wsConn.CloseWithReason(websocket.ClosePolicyViolation, "消息过于频繁")
+	}
+	return false
+}
+
+// FloodStats 获取消息限流统计
+func (rm *RoomManager) FloodStats() FloodStats {
+	return FloodStats{
+		Limited:      rm.floods.limited.Load(),
+		Muted:        rm.floods.muted.Load(),
+		Disconnected: rm.floods.disconnected.Load(),
+	}
+}
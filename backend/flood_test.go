@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// floodStep 在 at 时刻（相对连接建立）收到 n 条同类消息，期望每条的检查结果
+type floodStep struct {
+	at   time.Duration
+	typ  MessageType
+	n    int
+	want floodVerdict
+}
+
+func TestFloodGuardCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []floodStep
+	}{
+		{
+			name: "单类消息的令牌桶",
+			steps: []floodStep{
+				{at: 0, typ: TypeHit, n: 5, want: floodAllow},
+				{at: 0, typ: TypeHit, n: 1, want: floodLimited},
+				{at: 0, typ: TypeStand, n: 1, want: floodAllow},
+				{at: 400 * time.Millisecond, typ: TypeHit, n: 1, want: floodAllow},
+				{at: 400 * time.Millisecond, typ: TypeHit, n: 1, want: floodLimited},
+				{at: 2 * time.Second, typ: TypeHit, n: 5, want: floodAllow},
+			},
+		},
+		{
+			name: "所有消息合计的令牌桶",
+			steps: []floodStep{
+				{at: 0, typ: TypeJoin, n: 20, want: floodAllow},
+				{at: 0, typ: TypeJoin, n: 1, want: floodLimited},
+				{at: 0, typ: TypeHit, n: 1, want: floodLimited},
+				{at: time.Second / 10, typ: TypeJoin, n: 1, want: floodAllow},
+				{at: time.Second / 10, typ: TypeJoin, n: 1, want: floodLimited},
+			},
+		},
+		{
+			name: "聊天超限后禁言",
+			steps: []floodStep{
+				{at: 0, typ: TypeChat, n: 5, want: floodAllow},
+				{at: 0, typ: TypeChat, n: 1, want: floodMuted},
+				// 禁言期间即使令牌已经补满也不能聊天，其他消息不受影响
+				{at: 10 * time.Second, typ: TypeChat, n: 1, want: floodMuted},
+				{at: 10 * time.Second, typ: TypeHit, n: 1, want: floodAllow},
+				{at: chatMuteDuration - time.Millisecond, typ: TypeChat, n: 1, want: floodMuted},
+				{at: chatMuteDuration, typ: TypeChat, n: 5, want: floodAllow},
+				{at: chatMuteDuration, typ: TypeChat, n: 1, want: floodMuted},
+			},
+		},
+		{
+			name: "持续超限时断开连接",
+			steps: []floodStep{
+				{at: 0, typ: TypeStart, n: 2, want: floodAllow},
+				{at: 0, typ: TypeStart, n: 10, want: floodLimited},
+				{at: 0, typ: TypeStart, n: 1, want: floodDisconnect},
+				// 容忍度按 0.2/秒 恢复
+				{at: 5 * time.Second, typ: TypeStart, n: 2, want: floodAllow},
+				{at: 5 * time.Second, typ: TypeStart, n: 1, want: floodLimited},
+				{at: 5 * time.Second, typ: TypeStart, n: 1, want: floodDisconnect},
+			},
+		},
+		{
+			name: "正常的消息不消耗容忍度",
+			steps: []floodStep{
+				{at: 0, typ: TypeHit, n: 5, want: floodAllow},
+				{at: 0, typ: TypeHit, n: 10, want: floodLimited},
+				{at: 2 * time.Second, typ: TypeHit, n: 5, want: floodAllow},
+				{at: 2 * time.Second, typ: TypeHit, n: 1, want: floodDisconnect},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Unix(1700000000, 0)
+			g := newFloodGuard(start)
+
+			for i, step := range tt.steps {
+				now := start.Add(step.at)
+				for j := 0; j < step.n; j++ {
+					if got := g.check(step.typ, now); got != step.want {
+						t.Fatalf("第%d步第%d条 %s: %v, want %v", i+1, j+1, step.typ, got, step.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFloodGuardMutedFor(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	g := newFloodGuard(start)
+	for i := 0; i < 6; i++ {
+		g.check(TypeChat, start)
+	}
+
+	tests := []struct {
+		at   time.Duration
+		want int
+	}{
+		{0, 30},
+		{time.Millisecond, 30},
+		{29*time.Second + time.Millisecond, 1},
+		{chatMuteDuration, 0},
+	}
+	for _, tt := range tests {
+		if got := g.mutedFor(start.Add(tt.at)); got != tt.want {
+			t.Errorf("mutedFor(+%v) = %d, want %d", tt.at, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,17 @@ module blackjack
 
 go 1.21
 
-require github.com/gorilla/websocket v1.5.1
+require (
+	github.com/bufbuild/protocompile v0.14.1
+	github.com/gorilla/websocket v1.5.1
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	golang.org/x/crypto v0.14.0
+	golang.org/x/text v0.14.0
+	google.golang.org/protobuf v1.34.2
+)
 
-require golang.org/x/net v0.17.0 // indirect
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+)
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// maxHandHistories 每个房间保留的牌局记录数量
+const maxHandHistories = 50
+
+// 牌局动作类型
+const (
+	ActionHit   = "hit"
+	ActionStand = "stand"
+	ActionLeave = "leave" // 牌局中离开房间，回放时同样移除该玩家
+)
+
+// SeatRecord 座位记录
+type SeatRecord struct {
+	Seat     int    `json:"seat"`
+	PlayerID string `json:"playerId"`
+	Nickname string `json:"nickname"`
+}
+
+// DealRecord 初始发牌记录
+type DealRecord struct {
+	PlayerID string `json:"playerId"`
+	Cards    []Card `json:"cards"`
+}
+
+// HandAction 玩家动作记录
+type HandAction struct {
+	PlayerID  string    `json:"playerId"`
+	Action    string    `json:"action"`
+	Card      *Card     `json:"card,omitempty"`
+	HandValue int       `json:"handValue"`
+	Time      time.Time `json:"time"`
+}
+
+// RoundResult 单个玩家的本局结果
+type RoundResult struct {
+	PlayerID  string `json:"playerId"`
+	Nickname  string `json:"nickname"`
+	Score     int    `json:"score"`
+	Status    string `json:"status"`
+	IsWinner  bool   `json:"isWinner"`
+	Outcome   string `json:"outcome,omitempty" doc:"win、loss、push（与赢家同分）"`
+	Blackjack bool   `json:"blackjack,omitempty" doc:"首两张牌21点"`
+	Chips     int64  `json:"chips" doc:"本局输赢的筹码"`
+}
+
+// HandHistory 一局完整的牌局记录
+type HandHistory struct {
+	ID          string        `json:"id"`
+	RoomID      string        `json:"roomId"`
+	Round       int           `json:"round"`
+	StartedAt   time.Time     `json:"startedAt"`
+	EndedAt     time.Time     `json:"endedAt,omitempty"`
+	StartedBy   string        `json:"startedBy"`
+	Deck        []Card        `json:"deck"` // 洗牌后的完整牌序，用于回放
+	Seats       []SeatRecord  `json:"seats"`
+	InitialDeal []DealRecord  `json:"initialDeal"`
+	Actions     []HandAction  `json:"actions"`
+	Results     []RoundResult `json:"results,omitempty"`
+}
+
+// NewHandHistory 创建牌局记录
+func NewHandHistory(roomID string, round int) *HandHistory {
+	return &HandHistory{
+		ID:          fmt.Sprintf("%s-%d", roomID, round),
+		RoomID:      roomID,
+		Round:       round,
+		StartedAt:   time.Now(),
+		Seats:       make([]SeatRecord, 0),
+		InitialDeal: make([]DealRecord, 0),
+		Actions:     make([]HandAction, 0),
+	}
+}
+
+// clone 深拷贝牌局记录
+func (h *HandHistory) clone() *HandHistory {
+	c := *h
+	c.Deck = append([]Card(nil), h.Deck...)
+	c.Seats = append([]SeatRecord(nil), h.Seats...)
+	c.InitialDeal = make([]DealRecord, 0, len(h.InitialDeal))
+	for _, deal := range h.InitialDeal {
+		c.InitialDeal = append(c.InitialDeal, DealRecord{
+			PlayerID: deal.PlayerID,
+			Cards:    append([]Card(nil), deal.Cards...),
+		})
+	}
+	c.Actions = append([]HandAction(nil), h.Actions...)
+	c.Results = append([]RoundResult(nil), h.Results...)
+	return &c
+}
+
+// recordHistory 根据事件更新牌局记录（在 apply 中调用，调用方需持有锁）
+func (r *Room) recordHistory(rec EventRecord) {
+	switch e := rec.Event.(type) {
+	case RoundStarted:
+		r.history = NewHandHistory(r.ID, e.Round)
+		r.history.StartedAt = rec.Time
+		r.history.StartedBy = e.StartedBy
+		r.history.Deck = append([]Card(nil), e.Deck...)
+		for i, player := range r.orderedPlayers() {
+			r.history.Seats = append(r.history.Seats, SeatRecord{
+				Seat:     i + 1,
+				PlayerID: player.ID,
+				Nickname: player.Nickname,
+			})
+		}
+
+	case CardDealt:
+		if r.history == nil {
+			return
+		}
+		if e.Initial {
+			for i := range r.history.InitialDeal {
+				if r.history.InitialDeal[i].PlayerID == e.PlayerID {
+					r.history.InitialDeal[i].Cards = append(r.history.InitialDeal[i].Cards, e.Card)
+					return
+				}
+			}
+			r.history.InitialDeal = append(r.history.InitialDeal, DealRecord{
+				PlayerID: e.PlayerID,
+				Cards:    []Card{e.Card},
+			})
+			return
+		}
+		card := e.Card
+		r.recordAction(rec, e.PlayerID, ActionHit, &card)
+
+	case PlayerStood:
+		r.recordAction(rec, e.PlayerID, ActionStand, nil)
+
+	case PlayerLeft:
+		// 玩家已从房间移除，点数记为0
+		if r.history == nil {
+			return
+		}
+		r.history.Actions = append(r.history.Actions, HandAction{
+			PlayerID: e.PlayerID,
+			Action:   ActionLeave,
+			Time:     rec.Time,
+		})
+
+	case RoundSettled:
+		if r.history == nil {
+			return
+		}
+		r.history.Results = e.Results
+		r.history.EndedAt = rec.Time
+		r.histories = append(r.histories, r.history)
+		if len(r.histories) > maxHandHistories {
+			r.histories = r.histories[len(r.histories)-maxHandHistories:]
+		}
+		r.history = nil
+	}
+}
+
+// recordAction 记录玩家动作到当前牌局（调用方需持有锁）
+func (r *Room) recordAction(rec EventRecord, playerID, action string, card *Card) {
+	player, ok := r.Players[playerID]
+	if r.history == nil || !ok {
+		return
+	}
+
+	r.history.Actions = append(r.history.Actions, HandAction{
+		PlayerID:  playerID,
+		Action:    action,
+		Card:      card,
+		HandValue: player.HandValue,
+		Time:      rec.Time,
+	})
+}
+
+// seatOf 获取玩家的座位记录
+func (h *HandHistory) seatOf(playerID string) SeatRecord {
+	for _, seat := range h.Seats {
+		if seat.PlayerID == playerID {
+			return seat
+		}
+	}
+	return SeatRecord{PlayerID: playerID, Nickname: playerID}
+}
+
+// WriteText 以类似扑克网站牌局记录的文本格式导出
+func (h *HandHistory) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "21点牌局 #%s - %s\n", h.ID, h.StartedAt.Format("2006/01/02 15:04:05"))
+	fmt.Fprintf(&b, "房间 '%s' 6人桌（无庄家，最高点数者胜）\n", h.RoomID)
+	for _, seat := range h.Seats {
+		fmt.Fprintf(&b, "座位 %d: %s (%s)\n", seat.Seat, seat.Nickname, seat.PlayerID)
+	}
+
+	b.WriteString("*** 发牌 ***\n")
+	for _, deal := range h.InitialDeal {
+		fmt.Fprintf(&b, "发给 %s [%s]\n", h.seatOf(deal.PlayerID).Nickname, cardCodes(deal.Cards))
+	}
+
+	b.WriteString("*** 玩家行动 ***\n")
+	for _, action := range h.Actions {
+		nickname := h.seatOf(action.PlayerID).Nickname
+		switch action.Action {
+		case ActionHit:
+			fmt.Fprintf(&b, "%s %s: 要牌 [%s] (%d)\n",
+				action.Time.Format("15:04:05"), nickname, action.Card.Code(), action.HandValue)
+		case ActionStand:
+			fmt.Fprintf(&b, "%s %s: 停牌 (%d)\n",
+				action.Time.Format("15:04:05"), nickname, action.HandValue)
+		case ActionLeave:
+			fmt.Fprintf(&b, "%s %s: 离开房间\n", action.Time.Format("15:04:05"), nickname)
+		}
+	}
+
+	if len(h.Results) > 0 {
+		b.WriteString("*** 结算 ***\n")
+		for _, result := range h.Results {
+			outcome := "未获胜"
+			if result.IsWinner {
+				outcome = "获胜"
+			}
+			fmt.Fprintf(&b, "座位 %d: %s %d点 (%s) %s\n",
+				h.seatOf(result.PlayerID).Seat, result.Nickname, result.Score, result.Status, outcome)
+		}
+		fmt.Fprintf(&b, "牌局结束 %s\n", h.EndedAt.Format("2006/01/02 15:04:05"))
+	}
+
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteHandHistoriesText 导出多局文本记录
+func WriteHandHistoriesText(w io.Writer, histories []*HandHistory) error {
+	for _, h := range histories {
+		if err := h.WriteText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteHandHistoriesJSONLines 以JSON Lines格式导出（每行一局）
+func WriteHandHistoriesJSONLines(w io.Writer, histories []*HandHistory) error {
+	encoder := json.NewEncoder(w)
+	for _, h := range histories {
+		if err := encoder.Encode(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cardCodes 将多张牌转换为简写列表
+func cardCodes(cards []Card) string {
+	codes := make([]string, 0, len(cards))
+	for _, card := range cards {
+		codes = append(codes, card.Code())
+	}
+	return strings.Join(codes, " ")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "用当前输出更新 testdata 下的期望文件")
+
+// golden 比较输出与 testdata 下的期望文件，-update 时改写期望文件
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s 输出不一致:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// sampleHistories 两局固定的牌局记录：一局已结算，一局进行中（有玩家离开）
+func sampleHistories(t *testing.T) []*HandHistory {
+	start := time.Date(2026, 10, 18, 20, 30, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	card := func(code string) *Card {
+		c := parseCard(t, code)
+		return &c
+	}
+
+	settled := NewHandHistory("12345", 1)
+	settled.StartedAt = start
+	settled.EndedAt = at(25)
+	settled.StartedBy = "p1"
+	settled.Deck = hand(t, "5h", "Kd", "9c", "Td", "7s", "Qh")
+	settled.Seats = []SeatRecord{{Seat: 1, PlayerID: "p1", Nickname: "小明"}, {Seat: 2, PlayerID: "p2", Nickname: "小红"}}
+	settled.InitialDeal = []DealRecord{
+		{PlayerID: "p1", Cards: hand(t, "Qh", "7s")},
+		{PlayerID: "p2", Cards: hand(t, "Td", "9c")},
+	}
+	settled.Actions = []HandAction{
+		{PlayerID: "p1", Action: ActionHit, Card: card("Kd"), HandValue: 27, Time: at(10)},
+		{PlayerID: "p2", Action: ActionStand, HandValue: 19, Time: at(20)},
+	}
+	settled.Results = []RoundResult{
+		{PlayerID: "p1", Nickname: "小明", Score: 27, Status: "爆牌", Outcome: OutcomeLoss, Chips: -roundStake},
+		{PlayerID: "p2", Nickname: "小红", Score: 19, Status: "停牌", IsWinner: true, Outcome: OutcomeWin, Chips: roundStake},
+	}
+
+	playing := NewHandHistory("12345", 2)
+	playing.StartedAt = at(60)
+	playing.StartedBy = "p2"
+	playing.Deck = hand(t, "2c", "3c", "4c", "5c")
+	playing.Seats = []SeatRecord{{Seat: 1, PlayerID: "p2", Nickname: "小红"}, {Seat: 2, PlayerID: "p1", Nickname: "小明"}}
+	playing.InitialDeal = []DealRecord{
+		{PlayerID: "p2", Cards: hand(t, "5c", "4c")},
+		{PlayerID: "p1", Cards: hand(t, "3c", "2c")},
+	}
+	playing.Actions = []HandAction{{PlayerID: "p1", Action: ActionLeave, Time: at(70)}}
+
+	return []*HandHistory{settled, playing}
+}
+
+func TestWriteHandHistoriesText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteHandHistoriesText(&buf, sampleHistories(t)); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "histories.txt", buf.Bytes())
+}
+
+func TestWriteHandHistoriesJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteHandHistoriesJSONLines(&buf, sampleHistories(t)); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "histories.jsonl", buf.Bytes())
+}
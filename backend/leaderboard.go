@@ -0,0 +1,394 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 排行榜
+const (
+	BoardChips      = "chips"      // 净赢筹码
+	BoardWinRate    = "winRate"    // 胜率（局数不少于 minHands）
+	BoardStreak     = "streak"     // 最长连胜
+	BoardBlackjacks = "blackjacks" // 21点次数
+)
+
+// 统计周期（按服务器本地时间划分，每周从周一开始）
+const (
+	PeriodDaily   = "daily"
+	PeriodWeekly  = "weekly"
+	PeriodAllTime = "allTime"
+)
+
+// leaderboardBoards 全部排行榜（按显示顺序）
+var leaderboardBoards = []string{BoardChips, BoardWinRate, BoardStreak, BoardBlackjacks}
+
+// leaderboardPeriods 全部统计周期（按显示顺序）
+var leaderboardPeriods = []string{PeriodDaily, PeriodWeekly, PeriodAllTime}
+
+// leaderboardMinHands 各周期进入胜率榜需要的最少局数
+var leaderboardMinHands = map[string]int{
+	PeriodDaily:   5,
+	PeriodWeekly:  20,
+	PeriodAllTime: 50,
+}
+
+// 排行榜返回的条数
+const (
+	leaderboardDefaultLimit = 20
+	leaderboardMaxLimit     = 100
+)
+
+// LeaderboardEntry 玩家在一个统计周期内的累计结果
+type LeaderboardEntry struct {
+	PlayerID   string    `json:"playerId"`
+	Nickname   string    `json:"nickname"`
+	Hands      int       `json:"hands"`
+	Wins       int       `json:"wins"`
+	NetChips   int64     `json:"netChips"`
+	Blackjacks int       `json:"blackjacks"`
+	Streak     int       `json:"streak"`     // 当前连胜
+	BestStreak int       `json:"bestStreak"` // 周期内最长连胜
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// add 计入一局结果
+func (e *LeaderboardEntry) add(result RoundResult, at time.Time) {
+	e.Nickname = result.Nickname
+	e.Hands++
+	e.NetChips += result.Chips
+	if result.Blackjack {
+		e.Blackjacks++
+	}
+	// 平局不中断连胜
+	switch result.Outcome {
+	case OutcomeWin:
+		e.Wins++
+		e.Streak++
+		if e.Streak > e.BestStreak {
+			e.BestStreak = e.Streak
+		}
+	case OutcomeLoss:
+		e.Streak = 0
+	}
+	e.UpdatedAt = at
+}
+
+// winRate 胜率（0-1）
+func (e *LeaderboardEntry) winRate() float64 {
+	if e.Hands == 0 {
+		return 0
+	}
+	return float64(e.Wins) / float64(e.Hands)
+}
+
+// LeaderboardPeriod 一个统计周期的累计结果
+type LeaderboardPeriod struct {
+	Key     string                       `json:"key"` // 日期（2006-01-02）或ISO周（2006-W01），总榜为空
+	Entries map[string]*LeaderboardEntry `json:"entries"`
+}
+
+// LeaderboardState 排行榜的持久化状态
+type LeaderboardState struct {
+	Periods map[string]*LeaderboardPeriod `json:"periods"`
+}
+
+// LeaderboardStore 排行榜存储接口
+type LeaderboardStore interface {
+	// SaveLeaderboards 保存排行榜（覆盖旧状态）
+	SaveLeaderboards(state *LeaderboardState) error
+	// LoadLeaderboards 加载已保存的排行榜，没有时返回空状态
+	LoadLeaderboards() (*LeaderboardState, error)
+}
+
+// LeaderboardRank 排行榜中的一名玩家
+type LeaderboardRank struct {
+	Rank       int     `json:"rank" doc:"名次，数值相同的玩家名次相同"`
+	PlayerID   string  `json:"playerId"`
+	Nickname   string  `json:"nickname"`
+	Value      float64 `json:"value" doc:"排行的数值：筹码、胜率（0-1）、连胜或21点次数"`
+	Hands      int     `json:"hands"`
+	Wins       int     `json:"wins"`
+	NetChips   int64   `json:"netChips"`
+	Blackjacks int     `json:"blackjacks"`
+	BestStreak int     `json:"bestStreak"`
+}
+
+// LeaderboardResponse 排行榜（GET /api/leaderboard）
+type LeaderboardResponse struct {
+	Board    string            `json:"board"`
+	Period   string            `json:"period"`
+	Key      string            `json:"key,omitempty" doc:"统计周期：日期或ISO周，总榜为空"`
+	MinHands int               `json:"minHands,omitempty" doc:"胜率榜需要的最少局数"`
+	Entries  []LeaderboardRank `json:"entries"`
+}
+
+// leaderboards 日榜、周榜和总榜（可并发使用）
+//
+// 每局结算时只更新参与结算的玩家的累计结果；排名在查询时按需排序，
+// 排序结果缓存到下一次结算
+type leaderboards struct {
+	mu      sync.Mutex
+	periods map[string]*LeaderboardPeriod
+	ranks   map[string][]LeaderboardRank // 按 周期/排行榜 缓存的完整排名
+	store   LeaderboardStore             // 为nil时不持久化
+	saver   *saver                       // 设置存储后创建
+}
+
+// newLeaderboards 创建排行榜
+func newLeaderboards() *leaderboards {
+	lb := &leaderboards{
+		periods: make(map[string]*LeaderboardPeriod),
+		ranks:   make(map[string][]LeaderboardRank),
+	}
+	for _, period := range leaderboardPeriods {
+		lb.periods[period] = &LeaderboardPeriod{Entries: make(map[string]*LeaderboardEntry)}
+	}
+	return lb
+}
+
+// periodKey 时间所在统计周期的键
+func periodKey(period string, t time.Time) string {
+	t = t.Local()
+	switch period {
+	case PeriodDaily:
+		return t.Format("2006-01-02")
+	case PeriodWeekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return ""
+}
+
+// load 从存储恢复排行榜
+func (lb *leaderboards) load(store LeaderboardStore) error {
+	state, err := store.LoadLeaderboards()
+	if err != nil {
+		return err
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.store = store
+	lb.saver = newSaver("排行榜", lb.flush)
+	for _, period := range leaderboardPeriods {
+		if saved, ok := state.Periods[period]; ok && saved.Entries != nil {
+			lb.periods[period] = saved
+		}
+	}
+	lb.rotate(time.Now())
+	return nil
+}
+
+// rotate 进入新的一天或一周时清空对应的榜（调用方持有锁）
+func (lb *leaderboards) rotate(now time.Time) {
+	for _, period := range leaderboardPeriods {
+		key := periodKey(period, now)
+		if p := lb.periods[period]; p.Key != key {
+			lb.periods[period] = &LeaderboardPeriod{Key: key, Entries: make(map[string]*LeaderboardEntry)}
+			lb.invalidate(period)
+		}
+	}
+}
+
+// invalidate 丢弃周期内各排行榜的排名缓存（调用方持有锁）
+func (lb *leaderboards) invalidate(period string) {
+	for _, board := range leaderboardBoards {
+		delete(lb.ranks, period+"/"+board)
+	}
+}
+
+// record 计入一局的结算结果
+func (lb *leaderboards) record(results []RoundResult, at time.Time) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.rotate(at)
+	for _, period := range leaderboardPeriods {
+		entries := lb.periods[period].Entries
+		for _, result := range results {
+			entry, ok := entries[result.PlayerID]
+			if !ok {
+				entry = &LeaderboardEntry{PlayerID: result.PlayerID}
+				entries[result.PlayerID] = entry
+			}
+			entry.add(result, at)
+		}
+		lb.invalidate(period)
+	}
+	lb.save()
+}
+
+// top 排行榜的前 limit 名
+func (lb *leaderboards) top(board, period string, limit int) *LeaderboardResponse {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.rotate(time.Now())
+	key := period + "/" + board
+	ranks, ok := lb.ranks[key]
+	if !ok {
+		ranks = lb.rank(board, period)
+		lb.ranks[key] = ranks
+	}
+	if len(ranks) > limit {
+		ranks = ranks[:limit]
+	}
+
+	resp := &LeaderboardResponse{
+		Board:   board,
+		Period:  period,
+		Key:     lb.periods[period].Key,
+		Entries: append([]LeaderboardRank{}, ranks...),
+	}
+	if board == BoardWinRate {
+		resp.MinHands = leaderboardMinHands[period]
+	}
+	return resp
+}
+
+// rank 计算排行榜的完整排名：数值高的在前，数值相同时最近一局更早的在前（调用方持有锁）
+func (lb *leaderboards) rank(board, period string) []LeaderboardRank {
+	type candidate struct {
+		entry *LeaderboardEntry
+		value float64
+	}
+
+	var candidates []candidate
+	for _, entry := range lb.periods[period].Entries {
+		var value float64
+		switch board {
+		case BoardChips:
+			value = float64(entry.NetChips)
+		case BoardWinRate:
+			if entry.Hands < leaderboardMinHands[period] {
+				continue
+			}
+			value = entry.winRate()
+		case BoardStreak:
+			value = float64(entry.BestStreak)
+		case BoardBlackjacks:
+			value = float64(entry.Blackjacks)
+		}
+		// 连胜和21点榜只列出至少有一次的玩家
+		if value <= 0 && (board == BoardStreak || board == BoardBlackjacks) {
+			continue
+		}
+		candidates = append(candidates, candidate{entry, value})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.value != b.value {
+			return a.value > b.value
+		}
+		if !a.entry.UpdatedAt.Equal(b.entry.UpdatedAt) {
+			return a.entry.UpdatedAt.Before(b.entry.UpdatedAt)
+		}
+		return a.entry.PlayerID < b.entry.PlayerID
+	})
+
+	ranks := make([]LeaderboardRank, 0, len(candidates))
+	for i, c := range candidates {
+		rank := i + 1
+		if i > 0 && c.value == candidates[i-1].value {
+			rank = ranks[i-1].Rank
+		}
+		ranks = append(ranks, LeaderboardRank{
+			Rank:       rank,
+			PlayerID:   c.entry.PlayerID,
+			Nickname:   c.entry.Nickname,
+			Value:      c.value,
+			Hands:      c.entry.Hands,
+			Wins:       c.entry.Wins,
+			NetChips:   c.entry.NetChips,
+			Blackjacks: c.entry.Blackjacks,
+			BestStreak: c.entry.BestStreak,
+		})
+	}
+	return ranks
+}
+
+// save 标记排行榜有改动，由后台协程保存（调用方持有锁）
+func (lb *leaderboards) save() {
+	if lb.saver != nil {
+		lb.saver.mark()
+	}
+}
+
+// flush 保存排行榜的副本（在后台保存协程中调用）
+func (lb *leaderboards) flush() error {
+	lb.mu.Lock()
+	state := &LeaderboardState{Periods: make(map[string]*LeaderboardPeriod, len(lb.periods))}
+	for period, p := range lb.periods {
+		entries := make(map[string]*LeaderboardEntry, len(p.Entries))
+		for id, entry := range p.Entries {
+			copied := *entry
+			entries[id] = &copied
+		}
+		state.Periods[period] = &LeaderboardPeriod{Key: p.Key, Entries: entries}
+	}
+	lb.mu.Unlock()
+
+	return lb.store.SaveLeaderboards(state)
+}
+
+// close 写出未保存的改动并停止后台保存
+func (lb *leaderboards) close() {
+	lb.mu.Lock()
+	saver := lb.saver
+	lb.mu.Unlock()
+	if saver != nil {
+		saver.close()
+	}
+}
+
+// UseLeaderboardStore 设置排行榜存储并恢复已保存的排行榜
+func (rm *RoomManager) UseLeaderboardStore(store LeaderboardStore) error {
+	return rm.boards.load(store)
+}
+
+// Leaderboard 查询排行榜，board、period 为空时分别使用筹码榜和日榜
+func (rm *RoomManager) Leaderboard(board, period string, limit int) (*LeaderboardResponse, error) {
+	if board == "" {
+		board = BoardChips
+	}
+	if period == "" {
+		period = PeriodDaily
+	}
+
+	var fields []FieldError
+	if !containsString(leaderboardBoards, board) {
+		fields = append(fields, FieldError{Field: "board", Code: "oneOf", Args: []interface{}{strings.Join(leaderboardBoards, ", ")}})
+	}
+	if !containsString(leaderboardPeriods, period) {
+		fields = append(fields, FieldError{Field: "period", Code: "oneOf", Args: []interface{}{strings.Join(leaderboardPeriods, ", ")}})
+	}
+	switch {
+	case limit == 0:
+		limit = leaderboardDefaultLimit
+	case limit < 1:
+		fields = append(fields, FieldError{Field: "limit", Code: "min", Args: []interface{}{1}})
+	case limit > leaderboardMaxLimit:
+		fields = append(fields, FieldError{Field: "limit", Code: "max", Args: []interface{}{leaderboardMaxLimit}})
+	}
+	if len(fields) > 0 {
+		return nil, &validationError{Fields: fields}
+	}
+
+	return rm.boards.top(board, period, limit), nil
+}
+
+// containsString 切片中是否有该字符串
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
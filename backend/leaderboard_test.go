@@ -0,0 +1,195 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLeaderboardEntryStreak(t *testing.T) {
+	tests := []struct {
+		name       string
+		outcomes   []string
+		streak     int
+		bestStreak int
+	}{
+		{name: "连胜", outcomes: []string{OutcomeWin, OutcomeWin, OutcomeWin}, streak: 3, bestStreak: 3},
+		{name: "输了中断连胜", outcomes: []string{OutcomeWin, OutcomeWin, OutcomeLoss, OutcomeWin}, streak: 1, bestStreak: 2},
+		{name: "平局不中断连胜", outcomes: []string{OutcomeWin, OutcomePush, OutcomeWin}, streak: 2, bestStreak: 2},
+		{name: "没有赢过", outcomes: []string{OutcomeLoss, OutcomePush}, streak: 0, bestStreak: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e LeaderboardEntry
+			for _, outcome := range tt.outcomes {
+				e.add(RoundResult{Outcome: outcome}, time.Now())
+			}
+			if e.Streak != tt.streak || e.BestStreak != tt.bestStreak || e.Hands != len(tt.outcomes) {
+				t.Errorf("连胜 = %d/%d（%d局）, want %d/%d", e.Streak, e.BestStreak, e.Hands, tt.streak, tt.bestStreak)
+			}
+		})
+	}
+}
+
+// rankedIDs 排行榜中按名次排列的 玩家ID:名次
+func rankedIDs(resp *LeaderboardResponse) []string {
+	ids := make([]string, 0, len(resp.Entries))
+	for _, e := range resp.Entries {
+		ids = append(ids, e.PlayerID+":"+strconv.Itoa(e.Rank))
+	}
+	return ids
+}
+
+func TestLeaderboardTop(t *testing.T) {
+	lb := newLeaderboards()
+	start := time.Now()
+	result := func(id, outcome string, chips int64, blackjack bool) RoundResult {
+		return RoundResult{PlayerID: id, Nickname: id, Outcome: outcome, Chips: chips, Blackjack: blackjack}
+	}
+
+	// 净赢筹码 a:30 c:20 b:-10 e:-10 d:-30；b 和 e 同分时最近一局更早的 b 在前
+	rounds := [][]RoundResult{
+		{result("a", OutcomeWin, 10, false), result("b", OutcomeLoss, -10, false)},
+		{result("a", OutcomeWin, 10, false), result("c", OutcomeLoss, -10, false)},
+		{result("b", OutcomeWin, 10, true), result("d", OutcomeLoss, -10, false)},
+		{result("c", OutcomeWin, 10, false), result("b", OutcomeLoss, -10, false)},
+		{result("a", OutcomeWin, 10, false), result("d", OutcomeLoss, -10, false)},
+		{result("c", OutcomeWin, 20, false), result("d", OutcomeLoss, -10, false), result("e", OutcomeLoss, -10, false)},
+	}
+	for i, results := range rounds {
+		lb.record(results, start.Add(time.Duration(i)))
+	}
+
+	tests := []struct {
+		board  string
+		period string
+		limit  int
+		want   []string
+	}{
+		{board: BoardChips, period: PeriodAllTime, limit: 10, want: []string{"a:1", "c:2", "b:3", "e:3", "d:5"}},
+		{board: BoardChips, period: PeriodDaily, limit: 2, want: []string{"a:1", "c:2"}},
+		{board: BoardStreak, period: PeriodWeekly, limit: 10, want: []string{"a:1", "c:2", "b:3"}},
+		{board: BoardBlackjacks, period: PeriodAllTime, limit: 10, want: []string{"b:1"}},
+		{board: BoardWinRate, period: PeriodAllTime, limit: 10, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.board+"/"+tt.period, func(t *testing.T) {
+			resp := lb.top(tt.board, tt.period, tt.limit)
+			if got := rankedIDs(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("排名 = %v, want %v", got, tt.want)
+			}
+			if tt.period != PeriodAllTime && resp.Key != periodKey(tt.period, time.Now()) {
+				t.Errorf("Key = %q, want %q", resp.Key, periodKey(tt.period, time.Now()))
+			}
+		})
+	}
+}
+
+// TestLeaderboardWinRate 胜率榜只列出局数足够的玩家
+func TestLeaderboardWinRate(t *testing.T) {
+	lb := newLeaderboards()
+	minHands := leaderboardMinHands[PeriodDaily]
+	for i := 0; i < minHands; i++ {
+		outcome := OutcomeLoss
+		if i%2 == 0 {
+			outcome = OutcomeWin
+		}
+		lb.record([]RoundResult{{PlayerID: "regular", Outcome: outcome}}, time.Now())
+	}
+	lb.record([]RoundResult{{PlayerID: "newcomer", Outcome: OutcomeWin}}, time.Now())
+
+	resp := lb.top(BoardWinRate, PeriodDaily, 10)
+	if resp.MinHands != minHands {
+		t.Errorf("MinHands = %d, want %d", resp.MinHands, minHands)
+	}
+	if len(resp.Entries) != 1 || resp.Entries[0].PlayerID != "regular" {
+		t.Fatalf("胜率榜 = %+v, want 只有 regular", resp.Entries)
+	}
+	if want := float64((minHands+1)/2) / float64(minHands); resp.Entries[0].Value != want {
+		t.Errorf("胜率 = %v, want %v", resp.Entries[0].Value, want)
+	}
+}
+
+// TestLeaderboardRotate 进入新的一天时日榜清空，总榜保留
+func TestLeaderboardRotate(t *testing.T) {
+	lb := newLeaderboards()
+	yesterday := time.Now().AddDate(0, 0, -1)
+	lb.record([]RoundResult{{PlayerID: "p1", Outcome: OutcomeWin, Chips: 10}}, yesterday)
+
+	if got := lb.top(BoardChips, PeriodDaily, 10); len(got.Entries) != 0 {
+		t.Errorf("日榜 = %+v, want 空", got.Entries)
+	}
+	if got := lb.top(BoardChips, PeriodAllTime, 10); len(got.Entries) != 1 {
+		t.Errorf("总榜 = %+v, want 1条", got.Entries)
+	}
+}
+
+func TestPeriodKey(t *testing.T) {
+	at := time.Date(2024, 12, 30, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		period string
+		want   string
+	}{
+		{PeriodDaily, "2024-12-30"},
+		{PeriodWeekly, "2025-W01"},
+		{PeriodAllTime, ""},
+	}
+	for _, tt := range tests {
+		if got := periodKey(tt.period, at); got != tt.want {
+			t.Errorf("periodKey(%s) = %q, want %q", tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestLeaderboardQuery(t *testing.T) {
+	rm := NewRoomManager(NewSeededRandomSource(1))
+
+	tests := []struct {
+		board, period string
+		limit         int
+		want          []string
+	}{
+		{"", "", 0, nil},
+		{BoardStreak, PeriodWeekly, leaderboardMaxLimit, nil},
+		{"bogus", PeriodDaily, 1, []string{"board:oneOf"}},
+		{BoardChips, "monthly", -1, []string{"period:oneOf", "limit:min"}},
+		{BoardChips, PeriodDaily, leaderboardMaxLimit + 1, []string{"limit:max"}},
+	}
+	for _, tt := range tests {
+		resp, err := rm.Leaderboard(tt.board, tt.period, tt.limit)
+		if got := fieldCodes(t, err); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Leaderboard(%q, %q, %d) 错误 = %v, want %v", tt.board, tt.period, tt.limit, got, tt.want)
+		}
+		if err == nil && (resp.Board == "" || resp.Period == "") {
+			t.Errorf("Leaderboard(%q, %q) = %+v, want 默认的榜和周期", tt.board, tt.period, resp)
+		}
+	}
+}
+
+func TestLeaderboardPersistence(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lb := newLeaderboards()
+	if err := lb.load(store); err != nil {
+		t.Fatal(err)
+	}
+	lb.record([]RoundResult{{PlayerID: "p1", Nickname: "甲", Outcome: OutcomeWin, Chips: 10}}, time.Now())
+	lb.close()
+
+	restored := newLeaderboards()
+	if err := restored.load(store); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.close()
+	for _, period := range leaderboardPeriods {
+		if got := restored.top(BoardChips, period, 10); len(got.Entries) != 1 || got.Entries[0].Nickname != "甲" {
+			t.Errorf("%s 恢复的排行榜 = %+v", period, got.Entries)
+		}
+	}
+}
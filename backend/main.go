@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
 var roomManager *RoomManager
 
 func init() {
-	rand.Seed(time.Now().UnixNano())
-	roomManager = NewRoomManager()
+	roomManager = NewRoomManager(NewCryptoRandomSource())
 }
 
 func main() {
@@ -26,10 +31,67 @@ func main() {
 	}
 	fs := http.FileServer(http.Dir(staticDir))
 
+	// 房间持久化，重启后恢复房间和进行中的牌局
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	store, err := NewFileStore(dataDir)
+	if err != nil {
+		log.Fatalf("初始化存储失败: %v", err)
+	}
+	restored, err := roomManager.UseStore(store)
+	if err != nil {
+		log.Fatalf("恢复房间失败: %v", err)
+	}
+	accounts, err := roomManager.UseAccountStore(store)
+	if err != nil {
+		log.Fatalf("恢复玩家身份失败: %v", err)
+	}
+	if err := roomManager.UseStatsStore(store); err != nil {
+		log.Fatalf("恢复玩家统计失败: %v", err)
+	}
+	if err := roomManager.UseLeaderboardStore(store); err != nil {
+		log.Fatalf("恢复排行榜失败: %v", err)
+	}
+
+	// WebSocket 连接准入（来源、并发数、新建连接速率）
+	roomManager.SetConnLimits(loadConnLimits())
+
+	// 聊天审核（HTML和控制字符清理、屏蔽词、链接）
+	moderation, err := loadModerationConfig()
+	if err != nil {
+		log.Fatalf("读取聊天屏蔽词失败: %v", err)
+	}
+	roomManager.SetModeration(moderation)
+
+	// 游客身份
+	http.HandleFunc("/api/guest", handleGuest)
+
+	// 注册账号：注册、登录、注销、资料
+	http.HandleFunc("/api/account/", handleAccountAPI)
+
+	// 玩家统计
+	http.HandleFunc("/api/player/", handlePlayerAPI)
+
+	// 排行榜
+	http.HandleFunc("/api/leaderboard", handleLeaderboard)
+
 	// 创建房间API
 	http.HandleFunc("/api/room/create", handleCreateRoom)
 	http.HandleFunc("/api/room/", handleRoomAPI)
 
+	// WebSocket协议描述（JSON Schema）
+	http.HandleFunc("/api/protocol", handleProtocol)
+
+	// 快捷表情目录
+	http.HandleFunc("/api/emotes", handleEmotes)
+
+	// 运行统计（只对持有 METRICS_TOKEN 的运维开放，未设置时不提供）
+	if token := os.Getenv("METRICS_TOKEN"); token != "" {
+		http.HandleFunc("/api/metrics", requireAdminToken(token, handleMetrics))
+	}
+
 	// WebSocket处理
 	http.HandleFunc("/ws", roomManager.HandleWebSocket)
 
@@ -53,21 +115,55 @@ func main() {
 	fmt.Printf("🎰 21点游戏服务器启动\n")
 	fmt.Printf("🌐 HTTP服务地址: http://localhost:%s/21dian.html\n", port)
 	fmt.Printf("🔌 WebSocket地址: ws://localhost:%s/ws\n", port)
-	fmt.Printf("📁 静态文件目录: %s\n\n", staticDir)
+	fmt.Printf("📁 静态文件目录: %s\n", staticDir)
+	fmt.Printf("💾 数据目录: %s（已恢复 %d 个房间、%d 个玩家身份）\n\n", dataDir, restored, accounts)
+
+	srv := &http.Server{Addr: ":" + port}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("服务器启动失败: %v", err)
+		}
+	}()
+
+	// 等待退出信号后优雅关闭
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	stop()
+
+	timeout := 30 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && v >= 0 {
+		timeout = time.Duration(v) * time.Second
+	}
+	fmt.Printf("\n🛑 收到退出信号，最多等待 %v 让进行中的牌局结束...\n", timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("服务器启动失败: %v", err)
+	roomManager.Shutdown(shutdownCtx)
+
+	// 连接都已关闭，留出少量时间关闭HTTP服务
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer httpCancel()
+	if err := srv.Shutdown(httpCtx); err != nil {
+		log.Printf("HTTP服务关闭失败: %v", err)
 	}
+	roomManager.Close()
+	fmt.Println("👋 服务器已关闭")
 }
 
 // handleCreateRoom 处理创建房间
 func handleCreateRoom(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newError(ErrMethodNotAllowed))
 		return
 	}
 
-	room := roomManager.CreateRoom()
+	room, err := roomManager.CreateRoom()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -75,24 +171,223 @@ func handleCreateRoom(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleGuest 签发游客身份：服务器生成玩家ID和会话令牌
+func handleGuest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, newError(ErrMethodNotAllowed))
+		return
+	}
+
+	var req GuestRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	nickname, err := normalizeNickname(req.Nickname)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	account, token, err := roomManager.CreateGuest(r, nickname)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(GuestResponse{
+		PlayerID: account.PlayerID,
+		Token:    token,
+		Nickname: account.Nickname,
+	})
+}
+
+// handleAccountAPI 处理注册账号API：
+//
+//	POST  /api/account/register  注册（带游客令牌时升级该游客）
+//	POST  /api/account/login     登录，返回新的会话令牌
+//	POST  /api/account/logout    注销当前会话
+//	GET   /api/account/profile   查看资料
+//	PATCH /api/account/profile   修改昵称和头像
+//	GET   /api/account/avatars   可选的头像
+func handleAccountAPI(w http.ResponseWriter, r *http.Request) {
+	action := r.URL.Path[len("/api/account/"):]
+	method := http.MethodPost
+	switch action {
+	case "profile":
+		if r.Method == http.MethodGet || r.Method == http.MethodPatch {
+			method = r.Method
+		}
+	case "avatars":
+		method = http.MethodGet
+	case "register", "login", "logout":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != method {
+		writeError(w, r, newError(ErrMethodNotAllowed))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	var account *Account
+	var token string
+	var err error
+	switch action {
+	case "avatars":
+		json.NewEncoder(w).Encode(map[string]interface{}{"avatars": avatarChoices})
+		return
+
+	case "register":
+		var req RegisterRequest
+		if err = decodeBody(r, &req); err != nil {
+			break
+		}
+		var nickname string
+		if nickname, err = normalizeNickname(req.Nickname); err != nil {
+			break
+		}
+		account, token, err = roomManager.Register(r, bearerToken(r), req, nickname)
+
+	case "login":
+		var req LoginRequest
+		if err = decodeBody(r, &req); err != nil {
+			break
+		}
+		account, token, err = roomManager.Login(r, req.Username, req.Password)
+
+	case "logout":
+		if err = roomManager.Logout(bearerToken(r)); err == nil {
+			json.NewEncoder(w).Encode(map[string]string{"message": "已注销"})
+			return
+		}
+
+	case "profile":
+		if r.Method == http.MethodGet {
+			account, err = roomManager.Profile(bearerToken(r))
+			break
+		}
+		var req ProfileRequest
+		if err = decodeBody(r, &req); err != nil {
+			break
+		}
+		var nickname string
+		if nickname, err = normalizeNickname(req.Nickname); err != nil {
+			break
+		}
+		account, err = roomManager.UpdateProfile(bearerToken(r), nickname, req.Avatar)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(AccountResponse{Token: token, Profile: account.profile()})
+}
+
+// handlePlayerAPI 处理玩家API：GET /api/player/{id}/stats 返回玩家的跨局统计
+func handlePlayerAPI(w http.ResponseWriter, r *http.Request) {
+	playerID, subPath, _ := strings.Cut(r.URL.Path[len("/api/player/"):], "/")
+	if playerID == "" || subPath != "stats" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, r, newError(ErrMethodNotAllowed))
+		return
+	}
+
+	stats, err := roomManager.PlayerStats(playerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleLeaderboard 返回排行榜：GET /api/leaderboard?board=chips&period=daily&limit=20
+func handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newError(ErrMethodNotAllowed))
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, r, &validationError{Fields: []FieldError{{Field: "limit", Code: "type", Args: []interface{}{"integer"}}}})
+			return
+		}
+		limit = n
+	}
+
+	board, err := roomManager.Leaderboard(query.Get("board"), query.Get("period"), limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(board)
+}
+
+// decodeBody 读取并校验JSON请求体（最多4KB）
+func decodeBody(r *http.Request, req interface{}) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+	if err != nil {
+		return &validationError{Fields: []FieldError{{Field: "data", Code: "invalid"}}}
+	}
+	return decodeRequest(body, req)
+}
+
+// bearerToken 取 Authorization: Bearer 头中的会话令牌
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// requireAdminToken 只允许带 Authorization: Bearer <token> 的请求访问运维接口
+func requireAdminToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(token)) != 1 {
+			writeError(w, r, newError(ErrUnauthorized))
+			return
+		}
+		next(w, r)
+	}
+}
+
 // handleRoomAPI 处理房间API
 func handleRoomAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// 提取房间ID
-	roomID := r.URL.Path[len("/api/room/"):]
+	// 提取房间ID和子资源（如 /api/room/{id}/history）
+	roomID, subPath, _ := strings.Cut(r.URL.Path[len("/api/room/"):], "/")
 	if roomID == "" {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "房间ID不能为空",
-		})
+		writeError(w, r, &validationError{Fields: []FieldError{{Field: "roomId", Code: "required"}}})
 		return
 	}
 
 	room := roomManager.GetRoom(roomID)
 	if room == nil {
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "房间不存在",
-		})
+		writeError(w, r, newError(ErrRoomNotFound))
+		return
+	}
+
+	if subPath == "history" {
+		handleRoomHistory(w, r, room)
 		return
 	}
 
@@ -102,16 +397,18 @@ func handleRoomAPI(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"roomId":      room.ID,
 			"playerCount": room.PlayerCount(),
-			"status":      room.Status,
+			"status":      room.GetStatus(),
 		})
 
 	case http.MethodDelete:
-		// 离开房间
+		// 离开房间（只能让自己离开，需要会话令牌）
 		playerID := r.URL.Query().Get("playerId")
 		if playerID == "" {
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "玩家ID不能为空",
-			})
+			writeError(w, r, &validationError{Fields: []FieldError{{Field: "playerId", Code: "required"}}})
+			return
+		}
+		if err := roomManager.Authenticate(playerID, bearerToken(r)); err != nil {
+			writeError(w, r, err)
 			return
 		}
 
@@ -121,6 +418,88 @@ func handleRoomAPI(w http.ResponseWriter, r *http.Request) {
 		})
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newError(ErrMethodNotAllowed))
+	}
+}
+
+// writeError 以JSON返回错误：{"code", "error", "fields"}，状态码由错误码决定
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	msg := errorMessage(err, requestLocale(r), "")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(asGameError(err).HTTPStatus())
+	json.NewEncoder(w).Encode(struct {
+		Code   ErrorCode    `json:"code"`
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields,omitempty"`
+	}{msg.Code, msg.Error, msg.Fields})
+}
+
+// handleProtocol 返回WebSocket协议的 JSON Schema
+func handleProtocol(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newError(ErrMethodNotAllowed))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(ProtocolSchema())
+}
+
+// handleEmotes 返回按请求语言生成的快捷表情目录
+func handleEmotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newError(ErrMethodNotAllowed))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"emotes":     emoteList(requestLocale(r)),
+		"cooldownMs": emoteCooldown.Milliseconds(),
+	})
+}
+
+// handleMetrics 返回运行统计（连接准入、消息限流、聊天审核计数）
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newError(ErrMethodNotAllowed))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"connections": roomManager.ConnStats(),
+		"messages":    roomManager.FloodStats(),
+		"chat":        roomManager.ModerationStats(),
+	})
+}
+
+// handleRoomHistory 处理牌局记录查询与导出
+// 支持 format=json（默认）、text（牌局记录文本）、jsonl（每行一局）
+func handleRoomHistory(w http.ResponseWriter, r *http.Request, room *Room) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newError(ErrMethodNotAllowed))
+		return
+	}
+
+	histories := room.Histories()
+
+	switch r.URL.Query().Get("format") {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		WriteHandHistoriesText(w, histories)
+
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		WriteHandHistoriesJSONLines(w, histories)
+
+	default:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"roomId":    room.ID,
+			"histories": histories,
+		})
 	}
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdminToken(t *testing.T) {
+	handler := requireAdminToken("s3cret", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "正确的令牌", header: "Bearer s3cret", want: http.StatusNoContent},
+		{name: "没有令牌", want: http.StatusUnauthorized},
+		{name: "错误的令牌", header: "Bearer s3cre", want: http.StatusUnauthorized},
+		{name: "不是 Bearer", header: "Basic s3cret", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("状态码 = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// moderationTimeout 单条聊天审核的最长时间（外部审核服务超时按拒绝处理）
+const moderationTimeout = 2 * time.Second
+
+// ChatContent 待审核的聊天内容
+type ChatContent struct {
+	RoomID   string
+	PlayerID string
+	To       string // 私聊对象，为空时发给全房间
+	Text     string
+}
+
+// Moderator 聊天内容审核：返回处理后的文本，返回错误时拒绝这条聊天
+//
+// 外部审核服务实现该接口后通过 RoomManager.AddModerator 接入，
+// 在内置的清理和过滤之后执行
+type Moderator interface {
+	Moderate(ctx context.Context, content ChatContent) (string, error)
+}
+
+// ModeratorFunc 把函数用作 Moderator
+type ModeratorFunc func(ctx context.Context, content ChatContent) (string, error)
+
+// Moderate 调用函数本身
+func (f ModeratorFunc) Moderate(ctx context.Context, content ChatContent) (string, error) {
+	return f(ctx, content)
+}
+
+// moderationPipeline 按顺序执行审核器，每一步处理上一步的结果
+type moderationPipeline []Moderator
+
+// Moderate 依次执行审核器，任一步返回错误时停止
+func (p moderationPipeline) Moderate(ctx context.Context, content ChatContent) (string, error) {
+	for _, m := range p {
+		text, err := m.Moderate(ctx, content)
+		if err != nil {
+			return "", err
+		}
+		content.Text = text
+	}
+	return content.Text, nil
+}
+
+// ModerationConfig 聊天审核配置
+type ModerationConfig struct {
+	Words     []string // 屏蔽词（中文按字匹配，英文单词不区分大小写并按词边界匹配）
+	StripURLs bool     // 去掉消息中的链接
+}
+
+// defaultBannedWords 内置的屏蔽词，可以用 CHAT_WORDLIST 指定的文件替换
+var defaultBannedWords = []string{
+	"傻逼", "煞笔", "沙比", "操你妈", "草泥马", "尼玛", "他妈的", "妈的", "贱人", "去死",
+	"fuck", "fucking", "shit", "bitch", "asshole", "bastard", "cunt", "dick", "motherfucker",
+}
+
+// defaultModerationConfig 默认的聊天审核配置
+func defaultModerationConfig() ModerationConfig {
+	return ModerationConfig{Words: defaultBannedWords}
+}
+
+// loadModerationConfig 从环境变量读取聊天审核配置，未设置的使用默认值
+//
+//	CHAT_WORDLIST    屏蔽词文件，每行一个词，# 开头为注释
+//	CHAT_STRIP_URLS  为1时去掉消息中的链接
+func loadModerationConfig() (ModerationConfig, error) {
+	cfg := defaultModerationConfig()
+
+	if path := os.Getenv("CHAT_WORDLIST"); path != "" {
+		words, err := readWordList(path)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Words = words
+	}
+	cfg.StripURLs = os.Getenv("CHAT_STRIP_URLS") == "1"
+
+	return cfg, nil
+}
+
+// readWordList 读取屏蔽词文件
+func readWordList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words, scanner.Err()
+}
+
+// newModerationPipeline 按配置创建内置的审核流程：清理 → 去链接 → 屏蔽词
+func newModerationPipeline(cfg ModerationConfig) moderationPipeline {
+	pipeline := moderationPipeline{ModeratorFunc(sanitizeChat)}
+	if cfg.StripURLs {
+		pipeline = append(pipeline, ModeratorFunc(stripURLs))
+	}
+	if len(cfg.Words) > 0 {
+		pipeline = append(pipeline, newWordFilter(cfg.Words))
+	}
+	return pipeline
+}
+
+// htmlTagPattern HTML标签
+var htmlTagPattern = regexp.MustCompile(`<[^<>]*>`)
+
+// sanitizeChat 去掉HTML标签和控制字符：先还原实体再去标签，剩下的尖括号换成全角，
+// 换行和制表符换成空格，连续空白合并为一个
+func sanitizeChat(_ context.Context, content ChatContent) (string, error) {
+	text := strings.ToValidUTF8(content.Text, "")
+	text = html.UnescapeString(text)
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = strings.NewReplacer("<", "＜", ">", "＞").Replace(text)
+
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		case unicode.Is(unicode.Cf, r) && r != '\u200d':
+			// 零宽字符、双向文本控制符等（保留组合表情用的零宽连接符）
+			return -1
+		}
+		return r
+	}, text)
+
+	return strings.Join(strings.Fields(text), " "), nil
+}
+
+// urlPattern 链接：带协议或 www. 的地址，以及常见顶级域名的裸域名
+var urlPattern = regexp.MustCompile(`(?i)\b(?:(?:https?|ftp)://|www\.)\S+|\b[a-z0-9][a-z0-9-]*(?:\.[a-z0-9-]+)*\.(?:com|net|org|cn|io|me|cc|co|tv|gg|top|xyz|info|app|dev)\b(?:/\S*)?`)
+
+// urlReplacement 链接去掉后的占位文字
+const urlReplacement = "[链接已移除]"
+
+// stripURLs 把消息中的链接替换为占位文字
+func stripURLs(_ context.Context, content ChatContent) (string, error) {
+	return urlPattern.ReplaceAllString(content.Text, urlReplacement), nil
+}
+
+// wordFilter 屏蔽词过滤：命中的部分替换为 *
+//
+// 匹配时忽略大小写、全角/半角差异以及夹在词中间的空格和标点（"傻 逼"、"f.u.c.k"）；
+// 只由英文字母组成的词要求前后不是字母，避免误伤 "class"、"Dickens" 这类单词
+type wordFilter struct {
+	words [][]rune // 归一化后的屏蔽词
+	latin []bool   // 对应的词是否只由英文字母组成
+}
+
+// newWordFilter 创建屏蔽词过滤
+func newWordFilter(words []string) *wordFilter {
+	f := &wordFilter{}
+	for _, word := range words {
+		var normalized []rune
+		latin := true
+		for _, r := range word {
+			if r = foldRune(r); !isWordRune(r) {
+				continue
+			}
+			normalized = append(normalized, r)
+			latin = latin && r < utf8.RuneSelf && unicode.IsLetter(r)
+		}
+		if len(normalized) == 0 {
+			continue
+		}
+		f.words = append(f.words, normalized)
+		f.latin = append(f.latin, latin)
+	}
+	return f
+}
+
+// Moderate 把命中屏蔽词的部分（包括词中间的分隔符）替换为 *
+func (f *wordFilter) Moderate(_ context.Context, content ChatContent) (string, error) {
+	text := []rune(content.Text)
+
+	// 只保留文字和数字参与匹配，pos 记录它们在原文中的位置
+	var normalized []rune
+	var pos []int
+	for i, r := range text {
+		if r = foldRune(r); isWordRune(r) {
+			normalized = append(normalized, r)
+			pos = append(pos, i)
+		}
+	}
+
+	masked := false
+	for w, word := range f.words {
+		for start := 0; start+len(word) <= len(normalized); start++ {
+			if !runesEqual(normalized[start:start+len(word)], word) {
+				continue
+			}
+			first, last := pos[start], pos[start+len(word)-1]
+			if f.latin[w] && (isLatinAt(text, first-1) || isLatinAt(text, last+1)) {
+				continue
+			}
+			for i := first; i <= last; i++ {
+				text[i] = '*'
+			}
+			masked = true
+		}
+	}
+
+	if !masked {
+		return content.Text, nil
+	}
+	return string(text), nil
+}
+
+// foldRune 全角字母数字转半角并转小写
+func foldRune(r rune) rune {
+	if r >= '！' && r <= '～' {
+		r -= '！' - '!'
+	}
+	return unicode.ToLower(r)
+}
+
+// isWordRune 参与屏蔽词匹配的字符
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// isLatinAt 原文第 i 个字符是否为英文字母
+func isLatinAt(text []rune, i int) bool {
+	if i < 0 || i >= len(text) {
+		return false
+	}
+	r := foldRune(text[i])
+	return r < utf8.RuneSelf && unicode.IsLetter(r)
+}
+
+// runesEqual 比较两段字符
+func runesEqual(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ModerationStats 聊天审核统计
+type ModerationStats struct {
+	Modified uint64 `json:"modified"` // 内容被清理或屏蔽后发出的聊天
+	Rejected uint64 `json:"rejected"` // 被拒绝的聊天
+}
+
+// moderationCounters 聊天审核计数
+type moderationCounters struct {
+	modified atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// SetModeration 设置内置的聊天审核配置（在开始接受连接之前调用）
+func (rm *RoomManager) SetModeration(cfg ModerationConfig) {
+	rm.chatFilters = newModerationPipeline(cfg)
+}
+
+// AddModerator 接入外部审核器，在内置审核之后按接入顺序执行（在开始接受连接之前调用）
+func (rm *RoomManager) AddModerator(m Moderator) {
+	rm.chatHooks = append(rm.chatHooks, m)
+}
+
+// moderate 审核一条聊天，返回要发出的文本；外部审核器返回的非业务错误按拒绝处理
+func (rm *RoomManager) moderate(content ChatContent) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), moderationTimeout)
+	defer cancel()
+
+	text, err := rm.chatFilters.Moderate(ctx, content)
+	if err == nil {
+		moderated := content
+		moderated.Text = text
+		text, err = rm.chatHooks.Moderate(ctx, moderated)
+	}
+	if err != nil {
+		rm.moderation.rejected.Add(1)
+		var gerr *GameError
+		if !errors.As(err, &gerr) {
+			log.Printf("聊天审核失败: room=%s player=%s err=%v", content.RoomID, content.PlayerID, err)
+			err = newError(ErrChatRejected)
+		}
+		return "", err
+	}
+
+	if text != content.Text {
+		rm.moderation.modified.Add(1)
+	}
+	return text, nil
+}
+
+// ModerationStats 获取聊天审核统计
+func (rm *RoomManager) ModerationStats() ModerationStats {
+	return ModerationStats{
+		Modified: rm.moderation.modified.Load(),
+		Rejected: rm.moderation.rejected.Load(),
+	}
+}
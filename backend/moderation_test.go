@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeChat(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "普通文本", in: "你好 world", want: "你好 world"},
+		{name: "去掉HTML标签", in: "<b>加粗</b><script>alert(1)</script>", want: "加粗alert(1)"},
+		{name: "实体还原后再去标签", in: "&lt;img src=x onerror=alert(1)&gt;hi", want: "hi"},
+		{name: "落单的尖括号换成全角", in: "3 > 2 && 1 < 2", want: "3 ＞ 2 && 1 ＜ 2"},
+		{name: "换行和制表符合并为空格", in: "a\n\n\tb  c", want: "a b c"},
+		{name: "去掉控制字符", in: "a\x00b\x07c", want: "abc"},
+		{name: "去掉零宽和双向控制符", in: "a\u200bb\u202ec", want: "abc"},
+		{name: "保留零宽连接符", in: "👨\u200d👩", want: "👨\u200d👩"},
+		{name: "去掉无效UTF-8", in: "a\xffb", want: "ab"},
+		{name: "只有空白", in: " \n\t ", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeChat(context.Background(), ChatContent{Text: tt.in})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("sanitizeChat(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripURLs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"看 https://example.com/a?b=1 这个", "看 " + urlReplacement + " 这个"},
+		{"www.example.org", urlReplacement},
+		{"加我 abc.xyz/path 哦", "加我 " + urlReplacement + " 哦"},
+		{"FTP://files.example.net", urlReplacement},
+		{"3.5 分, e.g. 好", "3.5 分, e.g. 好"},
+		{"没有链接", "没有链接"},
+	}
+
+	for _, tt := range tests {
+		got, err := stripURLs(context.Background(), ChatContent{Text: tt.in})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("stripURLs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWordFilter(t *testing.T) {
+	filter := newWordFilter([]string{"傻逼", "fuck", "dick", "  ", "b.a.d"})
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "中文", in: "你是傻逼吗", want: "你是**吗"},
+		{name: "中间夹空格", in: "傻 逼", want: "***"},
+		{name: "英文忽略大小写", in: "FUCK you", want: "**** you"},
+		{name: "英文中间夹标点", in: "f.u.c.k", want: "*******"},
+		{name: "全角字母", in: "ｆｕｃｋ", want: "****"},
+		{name: "英文按词边界", in: "Dickens wrote this", want: "Dickens wrote this"},
+		{name: "英文词边界内的命中", in: "what a dick!", want: "what a ****!"},
+		{name: "屏蔽词本身带标点", in: "so bad", want: "so ***"},
+		{name: "多处命中", in: "傻逼傻逼", want: "****"},
+		{name: "没有命中时原样返回", in: "good game", want: "good game"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filter.Moderate(context.Background(), ChatContent{Text: tt.in})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Moderate(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModerationPipeline(t *testing.T) {
+	var calls []string
+	step := func(name, suffix string, err error) Moderator {
+		return ModeratorFunc(func(_ context.Context, content ChatContent) (string, error) {
+			calls = append(calls, name+":"+content.Text)
+			return content.Text + suffix, err
+		})
+	}
+	stop := errors.New("拒绝")
+
+	tests := []struct {
+		name      string
+		pipeline  moderationPipeline
+		want      string
+		wantErr   error
+		wantCalls []string
+	}{
+		{name: "空流程原样返回", pipeline: nil, want: "x"},
+		{name: "每一步处理上一步的结果", pipeline: moderationPipeline{step("a", "1", nil), step("b", "2", nil)}, want: "x12", wantCalls: []string{"a:x", "b:x1"}},
+		{name: "出错时停止", pipeline: moderationPipeline{step("a", "1", stop), step("b", "2", nil)}, wantErr: stop, wantCalls: []string{"a:x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			got, err := tt.pipeline.Moderate(context.Background(), ChatContent{Text: "x"})
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("Moderate = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("调用 = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRoomManagerModerate(t *testing.T) {
+	rm := NewRoomManager(NewSeededRandomSource(1))
+	rm.SetModeration(ModerationConfig{Words: []string{"坏词"}, StripURLs: true})
+
+	hookErr := errors.New("审核服务不可用")
+	rm.AddModerator(ModeratorFunc(func(_ context.Context, content ChatContent) (string, error) {
+		switch content.Text {
+		case "down":
+			return "", hookErr
+		case "muted":
+			return "", newError(ErrChatMuted)
+		}
+		return content.Text, nil
+	}))
+
+	tests := []struct {
+		in       string
+		want     string
+		wantCode ErrorCode
+	}{
+		{in: "你好", want: "你好"},
+		{in: "<i>坏词</i> www.x.com", want: "** " + urlReplacement},
+		{in: "down", wantCode: ErrChatRejected},
+		{in: "muted", wantCode: ErrChatMuted},
+	}
+
+	for _, tt := range tests {
+		got, err := rm.moderate(ChatContent{RoomID: "R", PlayerID: "p", Text: tt.in})
+		var code ErrorCode
+		var gerr *GameError
+		if errors.As(err, &gerr) {
+			code = gerr.Code
+		} else if err != nil {
+			t.Fatalf("moderate(%q) 返回了非业务错误: %v", tt.in, err)
+		}
+		if got != tt.want || code != tt.wantCode {
+			t.Errorf("moderate(%q) = %q, %q, want %q, %q", tt.in, got, code, tt.want, tt.wantCode)
+		}
+	}
+
+	if stats := rm.ModerationStats(); stats != (ModerationStats{Modified: 1, Rejected: 2}) {
+		t.Errorf("ModerationStats = %+v, want 修改1 拒绝2", stats)
+	}
+}
+
+func TestReadWordList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# 注释\n坏词\n\n  bad  \n#也是注释\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	words, err := readWordList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"坏词", "bad"}; !reflect.DeepEqual(words, want) {
+		t.Errorf("readWordList = %q, want %q", words, want)
+	}
+
+	if _, err := readWordList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("文件不存在时没有返回错误")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// nicknameMaxLength 昵称最多的字符数（按 NFC 规范化后计算）
+const nicknameMaxLength = 12
+
+// nicknameSymbols 昵称中允许的符号（另外允许文字、数字和空格）
+const nicknameSymbols = "_-.·"
+
+// reservedNicknames 保留名称，按 nicknameKey 比较（忽略大小写、全角半角、空格和符号）
+var reservedNicknames = []string{
+	"dealer", "system", "admin", "administrator", "moderator", "server", "official", "gm",
+	"庄家", "荷官", "系统", "系统消息", "管理员", "客服", "官方", "服务器",
+}
+
+// reservedNicknameKeys 保留名称的比较键
+var reservedNicknameKeys = func() map[string]struct{} {
+	keys := make(map[string]struct{}, len(reservedNicknames))
+	for _, name := range reservedNicknames {
+		keys[nicknameKey(name)] = struct{}{}
+	}
+	return keys
+}()
+
+// normalizeNickname 规范化并校验昵称：NFC 规范化、去掉首尾空白并合并连续空白，
+// 然后检查长度、字符和保留名称。为空时返回空字符串，由调用方决定是否必填
+func normalizeNickname(raw string) (string, error) {
+	nickname := strings.Join(strings.Fields(norm.NFC.String(raw)), " ")
+	if nickname == "" {
+		return "", nil
+	}
+
+	fail := func(code string, args ...interface{}) error {
+		return &validationError{Fields: []FieldError{{Field: "nickname", Code: code, Args: args}}}
+	}
+
+	if utf8.RuneCountInString(nickname) > nicknameMaxLength {
+		return "", fail("maxLength", nicknameMaxLength)
+	}
+
+	for _, r := range nickname {
+		if !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsNumber(r) &&
+			r != ' ' && !strings.ContainsRune(nicknameSymbols, r) {
+			return "", fail("nicknameChars", nicknameSymbols)
+		}
+	}
+
+	if _, ok := reservedNicknameKeys[nicknameKey(nickname)]; ok {
+		return "", fail("reserved")
+	}
+	return nickname, nil
+}
+
+// nicknameKey 昵称的比较键：全角转半角、大小写折叠，只保留文字和数字。
+// 键相同的昵称视为重名（"Bob"、"ｂｏｂ"、"b.o.b" 互相重名）
+func nicknameKey(nickname string) string {
+	folded := cases.Fold().String(width.Fold.String(nickname))
+
+	var b strings.Builder
+	for _, r := range folded {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// uniqueNickname 房间内不重名的昵称：与其他玩家重名时加上 -2、-3… 后缀（只用昵称允许的字符，
+// 客户端可以原样提交），必要时截短原昵称以保持长度限制（在房间协程中调用）
+func (r *Room) uniqueNickname(playerID, nickname string) string {
+	taken := make(map[string]struct{}, len(r.Players))
+	for id, player := range r.Players {
+		if id != playerID {
+			taken[nicknameKey(player.Nickname)] = struct{}{}
+		}
+	}
+
+	candidate := nickname
+	for n := 2; ; n++ {
+		if _, ok := taken[nicknameKey(candidate)]; !ok {
+			return candidate
+		}
+
+		suffix := "-" + strconv.Itoa(n)
+		base := []rune(nickname)
+		if max := nicknameMaxLength - utf8.RuneCountInString(suffix); len(base) > max {
+			base = base[:max]
+		}
+		candidate = strings.TrimSpace(string(base)) + suffix
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeNickname(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		want     string
+		wantCode string
+	}{
+		{name: "普通昵称", in: "小明", want: "小明"},
+		{name: "空昵称", in: "   ", want: ""},
+		{name: "合并空白", in: "  Big \t  Bob ", want: "Big Bob"},
+		{name: "NFC规范化", in: "Zoe\u0301", want: "Zo\u00e9"},
+		{name: "允许的符号", in: "a_b-c.d·e", want: "a_b-c.d·e"},
+		{name: "按字符计算长度", in: "一二三四五六七八九十十一", want: "一二三四五六七八九十十一"},
+		{name: "超过长度", in: "一二三四五六七八九十十一二", wantCode: "maxLength"},
+		{name: "不允许的符号", in: "bob!", wantCode: "nicknameChars"},
+		{name: "不允许表情", in: "bob😀", wantCode: "nicknameChars"},
+		{name: "保留名称", in: "Admin", wantCode: "reserved"},
+		{name: "保留名称的变体", in: "ＳＹＳ.tem", wantCode: "reserved"},
+		{name: "中文保留名称", in: "系统 消息", wantCode: "reserved"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeNickname(tt.in)
+			var code string
+			if err != nil {
+				var verr *validationError
+				if !errors.As(err, &verr) || len(verr.Fields) != 1 || verr.Fields[0].Field != "nickname" {
+					t.Fatalf("错误 = %v, want nickname 字段错误", err)
+				}
+				code = verr.Fields[0].Code
+			}
+			if got != tt.want || code != tt.wantCode {
+				t.Errorf("normalizeNickname(%q) = %q, %q, want %q, %q", tt.in, got, code, tt.want, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestNicknameKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Bob", "bob", true},
+		{"Bob", "ｂｏｂ", true},
+		{"Bob", "b.o.b", true},
+		{"Bob", "B o B", true},
+		{"Straße", "STRASSE", true},
+		{"Bob", "Bob2", false},
+		{"小明", "小红", false},
+	}
+
+	for _, tt := range tests {
+		if same := nicknameKey(tt.a) == nicknameKey(tt.b); same != tt.same {
+			t.Errorf("nicknameKey(%q) == nicknameKey(%q) 为 %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
+
+func TestUniqueNickname(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		playerID string
+		nickname string
+		want     string
+	}{
+		{name: "不重名", existing: []string{"小红"}, nickname: "小明", want: "小明"},
+		{name: "重名加后缀", existing: []string{"小明"}, nickname: "小明", want: "小明-2"},
+		{name: "按比较键重名", existing: []string{"bob"}, nickname: "ＢＯＢ", want: "ＢＯＢ-2"},
+		{name: "后缀依次递增", existing: []string{"小明", "小明-2", "小明-3"}, nickname: "小明", want: "小明-4"},
+		{name: "截短以保持长度", existing: []string{"一二三四五六七八九十十一"}, nickname: "一二三四五六七八九十十一", want: "一二三四五六七八九十-2"},
+		{name: "截短后去掉末尾空格", existing: []string{"abcdefghi jk"}, nickname: "abcdefghi jk", want: "abcdefghi-2"},
+		{name: "不和自己比较", existing: []string{"小明"}, playerID: "p0", nickname: "小明", want: "小明"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := newRoom("ROOM", nil)
+			for i, nickname := range tt.existing {
+				id := "p" + string(rune('0'+i))
+				room.Players[id] = NewPlayer(id, nickname)
+			}
+
+			got := room.uniqueNickname(tt.playerID, tt.nickname)
+			if got != tt.want {
+				t.Errorf("uniqueNickname = %q, want %q", got, tt.want)
+			}
+			if normalized, err := normalizeNickname(got); err != nil || normalized != got {
+				t.Errorf("%q 不是合法的昵称: %q, %v", got, normalized, err)
+			}
+		})
+	}
+}
@@ -36,6 +36,13 @@ func NewPlayer(id, nickname string) *Player {
 	}
 }
 
+// clone 复制玩家状态（手牌单独复制，不与原玩家共享）
+func (p *Player) clone() *Player {
+	c := *p
+	c.Cards = append([]Card(nil), p.Cards...)
+	return &c
+}
+
 // Reset 重置玩家状态（新一局）
 func (p *Player) Reset() {
 	p.Cards = make([]Card, 0)
@@ -96,8 +103,21 @@ func (p *Player) CanAct() bool {
 	return p.Status == StatusActing && p.HandValue < 21
 }
 
-// ToMap 转换为Map（用于JSON序列化）
-func (p *Player) ToMap(hideCards bool) map[string]interface{} {
+// PlayerInfo 发给客户端的玩家状态
+type PlayerInfo struct {
+	ID          string       `json:"id"`
+	Nickname    string       `json:"nickname"`
+	Cards       []string     `json:"cards" doc:"牌面CSS类名，隐藏的牌为 pk-hide"`
+	CardCount   int          `json:"cardCount"`
+	HandValue   int          `json:"handValue" doc:"其他玩家仍在操作时为0"`
+	Status      string       `json:"status"`
+	StatusColor string       `json:"statusColor"`
+	Online      bool         `json:"online"`
+	Stats       *PlayerStats `json:"stats,omitempty" doc:"跨局累计的统计，还没有结算过时省略"`
+}
+
+// Info 转换为发给客户端的玩家状态，hideCards 时只显示第一张牌，操作中的点数记为0
+func (p *Player) Info(hideCards bool) PlayerInfo {
 	cards := make([]string, 0, len(p.Cards))
 	for _, card := range p.Cards {
 		cards = append(cards, card.String())
@@ -111,13 +131,20 @@ func (p *Player) ToMap(hideCards bool) map[string]interface{} {
 		}
 	}
 
-	return map[string]interface{}{
-		"id":         p.ID,
-		"nickname":   p.Nickname,
-		"cards":      cards,
-		"cardCount":  len(p.Cards),
-		"handValue":  p.HandValue,
-		"status":     p.GetStatusString(),
-		"statusColor": p.GetStatusColor(),
+	// 隐藏牌时，仍在操作的玩家也不公开点数
+	handValue := p.HandValue
+	if hideCards && p.Status == StatusActing {
+		handValue = 0
+	}
+
+	return PlayerInfo{
+		ID:          p.ID,
+		Nickname:    p.Nickname,
+		Cards:       cards,
+		CardCount:   len(p.Cards),
+		HandValue:   handValue,
+		Status:      p.GetStatusString(),
+		StatusColor: p.GetStatusColor(),
+		Online:      p.Conn != nil,
 	}
 }
@@ -0,0 +1,114 @@
+package main
+
+// fullStateInterval 每发出这么多条增量消息后广播一次完整状态，纠正客户端的累积误差
+const fullStateInterval = 20
+
+// roomMessage 由事件投影出的消息
+type roomMessage struct {
+	To      string             // 目标玩家ID，为空表示广播给房间所有人
+	Msg     Message            // 发给房间所有人（或 To）的消息
+	Private map[string]Message // 广播时个别玩家收到的版本（如能看到自己的牌），与 Msg 使用同一状态版本
+
+	Ephemeral bool // 即时消息（如表情），广播时不占用状态版本，也不进入补发缓冲
+}
+
+// messageFor 玩家收到的消息版本
+func (out roomMessage) messageFor(playerID string) Message {
+	if msg, ok := out.Private[playerID]; ok {
+		return msg
+	}
+	return out.Msg
+}
+
+// projectEvents 将一条命令产生的事件批次投影为要发送的消息（在房间协程中调用）
+// 消息顺序与事件顺序一致：开局先发 start，发完初始牌后发完整状态 snapshot；
+// 要牌发 cardDealt（爆牌时再发 statusChanged），停牌发 statusChanged，结算时发 gameEnd。
+// 游戏中有玩家离开时、增量消息累计 fullStateInterval 条后补发一次完整状态。
+func projectEvents(room *Room, events []EventRecord) []roomMessage {
+	out := make([]roomMessage, 0, len(events))
+
+	dealing := false // 是否处于开局发牌阶段
+
+	fullState := func() {
+		out = append(out, fullStateMessage(room))
+		room.deltas = 0
+	}
+	delta := func(msg roomMessage) {
+		out = append(out, msg)
+		room.deltas++
+	}
+	flushDeal := func() {
+		if dealing {
+			fullState()
+			dealing = false
+		}
+	}
+
+	for _, rec := range events {
+		if e, ok := rec.Event.(CardDealt); !ok || !e.Initial {
+			flushDeal()
+		}
+
+		switch e := rec.Event.(type) {
+		case PlayerJoined:
+			out = append(out,
+				roomMessage{To: e.PlayerID, Msg: roomInfoMessage(room)},
+				roomMessage{Msg: playersMessage(room, e.PlayerID)},
+				roomMessage{To: e.PlayerID, Msg: chatHistoryMessage(room, e.PlayerID)},
+				room.systemChat(chatEventJoin, e.Nickname+" 加入了房间"),
+			)
+
+		case PlayerLeft:
+			switch {
+			case len(room.Players) == 0:
+				continue
+			case room.Status == GamePlaying:
+				fullState()
+			default:
+				out = append(out, roomMessage{Msg: playersMessage(room, "")})
+			}
+			out = append(out, room.systemChat(chatEventLeave, e.Nickname+" 离开了房间"))
+
+		case PlayerRenamed:
+			if room.Status == GamePlaying {
+				fullState()
+			} else {
+				out = append(out, roomMessage{Msg: playersMessage(room, "")})
+			}
+			out = append(out, room.systemChat(chatEventRename, e.Previous+" 改名为 "+e.Nickname))
+
+		case RoundStarted:
+			out = append(out, roomMessage{Msg: startMessage(room)})
+			dealing = true
+
+		case CardDealt:
+			if e.Initial {
+				continue
+			}
+			if player, ok := room.Players[e.PlayerID]; ok {
+				delta(cardDealtMessage(room, player, e.Card))
+				if player.Status == StatusBust {
+					delta(roomMessage{Msg: statusChangedMessage(room, player)})
+				}
+			}
+
+		case PlayerStood:
+			if player, ok := room.Players[e.PlayerID]; ok {
+				delta(roomMessage{Msg: statusChangedMessage(room, player)})
+			}
+
+		case RoundSettled:
+			out = append(out,
+				roomMessage{Msg: gameEndMessage(room, e.Results)},
+				room.systemChat(chatEventResult, resultChat(e.Results)),
+			)
+		}
+	}
+
+	flushDeal()
+	if room.deltas >= fullStateInterval && room.Status == GamePlaying {
+		fullState()
+	}
+
+	return out
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"reflect"
+	"time"
+)
+
+// ProtocolVersion 当前协议版本，未发送 hello 的客户端按此版本处理
+// 版本2：要牌、停牌改为发送增量消息 cardDealt、statusChanged，不再发送 update 和整个玩家列表
+// 版本3：玩家ID由服务器签发（POST /api/guest），connect 必须带会话令牌
+//
+// 版本1、2的客户端不会带会话令牌，无法通过身份验证，因此不再支持
+const ProtocolVersion = 3
+
+// supportedVersions 服务器支持的协议版本（从低到高）
+var supportedVersions = []int{3}
+
+// negotiateVersion 选出客户端和服务器都支持的最高版本
+func negotiateVersion(clientVersions []int) (int, bool) {
+	best := 0
+	for _, v := range clientVersions {
+		for _, s := range supportedVersions {
+			if v == s && v > best {
+				best = v
+			}
+		}
+	}
+	return best, best > 0
+}
+
+// ---- 客户端请求 ----
+
+// HelloRequest 握手请求
+type HelloRequest struct {
+	Versions []int  `json:"versions" validate:"required,max=16" doc:"客户端支持的协议版本"`
+	Client   string `json:"client,omitempty" validate:"max=64" doc:"客户端名称，仅用于日志"`
+	Locale   string `json:"locale,omitempty" validate:"max=35" doc:"错误描述的语言（zh、en），默认取 Accept-Language"`
+}
+
+// ConnectRequest 连接请求：用服务器签发的玩家ID和会话令牌验证身份
+type ConnectRequest struct {
+	PlayerID string `json:"playerId" validate:"required,max=64" doc:"POST /api/guest 或 /api/account/login 返回的玩家ID"`
+	Token    string `json:"token" validate:"required,max=128" doc:"POST /api/guest 或 /api/account/login 返回的会话令牌"`
+	Nickname string `json:"nickname" validate:"max=32" doc:"为空时使用身份的昵称"`
+}
+
+// JoinRequest 加入房间请求（玩家已在房间中时换用当前连接）
+type JoinRequest struct {
+	RoomID   string `json:"roomId" validate:"required,max=16"`
+	PlayerID string `json:"playerId" validate:"required,max=64"`
+	Nickname string `json:"nickname" validate:"max=32"`
+}
+
+// RoomActionRequest 房间内操作请求（start、hit、stand）
+type RoomActionRequest struct {
+	RoomID   string `json:"roomId" validate:"required,max=16"`
+	PlayerID string `json:"playerId" validate:"required,max=64"`
+}
+
+// ChatRequest 聊天请求
+type ChatRequest struct {
+	RoomID   string `json:"roomId" validate:"required,max=16"`
+	PlayerID string `json:"playerId" validate:"required,max=64"`
+	Message  string `json:"message" validate:"required,max=200"`
+	To       string `json:"to,omitempty" validate:"max=64" doc:"私聊对象的玩家ID，为空时发给全房间"`
+}
+
+// EmoteRequest 快捷表情请求
+type EmoteRequest struct {
+	RoomID   string `json:"roomId" validate:"required,max=16"`
+	PlayerID string `json:"playerId" validate:"required,max=64"`
+	Emote    string `json:"emote" validate:"required,max=32" doc:"表情ID，见 /api/emotes"`
+}
+
+// ReplayRequest 回放请求：牌局记录由服务器按房间和局数查找
+type ReplayRequest struct {
+	RoomID string   `json:"roomId" validate:"required,max=16"`
+	Round  int      `json:"round" validate:"required,min=1" doc:"房间内的局数，从1开始"`
+	Speed  *float64 `json:"speed,omitempty" validate:"min=0.25,max=64" doc:"回放倍速，默认1"`
+}
+
+// ResyncRequest 补发请求：获取 sinceVersion 之后的广播消息
+type ResyncRequest struct {
+	RoomID       string `json:"roomId" validate:"required,max=16"`
+	PlayerID     string `json:"playerId" validate:"required,max=64"`
+	SinceVersion uint64 `json:"sinceVersion" doc:"客户端已处理的最后一个状态版本"`
+}
+
+// ---- 服务器消息 ----
+
+// HelloResponse 握手结果
+type HelloResponse struct {
+	Version           int   `json:"version" doc:"协商出的协议版本"`
+	SupportedVersions []int `json:"supportedVersions"`
+}
+
+// ConnectResponse 连接结果
+type ConnectResponse struct {
+	PlayerID string `json:"playerId"`
+	Nickname string `json:"nickname"`
+	Username string `json:"username,omitempty" doc:"注册账号的用户名，游客为空"`
+	Avatar   string `json:"avatar"`
+	Chips    int64  `json:"chips"`
+	Guest    bool   `json:"guest"`
+}
+
+// RoomInfoResponse 房间信息
+type RoomInfoResponse struct {
+	RoomID  string     `json:"roomId"`
+	Status  GameStatus `json:"status" doc:"0等待中 1游戏中 2已结束"`
+	Version uint64     `json:"version" doc:"当前状态版本，之后的广播从 version+1 开始"`
+}
+
+// PlayersResponse 玩家列表
+type PlayersResponse struct {
+	Players []PlayerInfo `json:"players"`
+}
+
+// StartResponse 游戏开始
+type StartResponse struct {
+	RoomID string `json:"roomId"`
+}
+
+// GameEndResponse 游戏结束
+type GameEndResponse struct {
+	RoomID  string        `json:"roomId"`
+	Results []RoundResult `json:"results"`
+}
+
+// ChatResponse 聊天消息
+type ChatResponse struct {
+	ID         uint64    `json:"id" doc:"房间内递增的聊天序号"`
+	Kind       ChatKind  `json:"kind" doc:"player 公开聊天、whisper 私聊、system 系统消息"`
+	PlayerID   string    `json:"playerId,omitempty" doc:"发送者，系统消息为空"`
+	Nickname   string    `json:"nickname,omitempty"`
+	To         string    `json:"to,omitempty" doc:"私聊对象"`
+	ToNickname string    `json:"toNickname,omitempty"`
+	Event      string    `json:"event,omitempty" doc:"系统消息的事件：join、leave、rename、result"`
+	Message    string    `json:"message"`
+	Time       time.Time `json:"time" doc:"服务器时间"`
+}
+
+// ChatHistoryResponse 最近的聊天记录（加入或重新连接后发送，只含自己可见的私聊）
+type ChatHistoryResponse struct {
+	Messages []ChatResponse `json:"messages"`
+}
+
+// EmoteResponse 快捷表情
+type EmoteResponse struct {
+	Seat     int    `json:"seat" doc:"按加入顺序的座位号"`
+	PlayerID string `json:"playerId"`
+	Nickname string `json:"nickname"`
+	Emote    string `json:"emote" doc:"表情ID"`
+	Icon     string `json:"icon"`
+}
+
+// EmoteInfo 表情目录中的一项（GET /api/emotes）
+type EmoteInfo struct {
+	ID   string `json:"id"`
+	Icon string `json:"icon"`
+	Text string `json:"text"`
+}
+
+// ReplayResponse 回放结束
+type ReplayResponse struct {
+	HandID string `json:"handId"`
+	Status string `json:"status"`
+}
+
+// CardDealtResponse 给某个座位发了一张牌
+type CardDealtResponse struct {
+	Seat      int    `json:"seat" doc:"座位号，按加入顺序从0开始"`
+	PlayerID  string `json:"playerId"`
+	Card      string `json:"card" doc:"牌面CSS类名，其他玩家的牌为 pk-hide"`
+	CardCount int    `json:"cardCount"`
+	HandValue int    `json:"handValue,omitempty" doc:"只有玩家自己收到"`
+}
+
+// StatusChangedResponse 某个座位的状态变化（停牌、爆牌、上下线）
+type StatusChangedResponse struct {
+	Seat        int    `json:"seat" doc:"座位号，按加入顺序从0开始"`
+	PlayerID    string `json:"playerId"`
+	Status      string `json:"status"`
+	StatusColor string `json:"statusColor"`
+	HandValue   int    `json:"handValue,omitempty" doc:"玩家仍在操作时不公开"`
+	Online      bool   `json:"online"`
+}
+
+// SnapshotResponse 房间完整状态（开局发牌后、每隔一段增量消息后广播，补发时缓冲区中已没有缺失的消息也发送）
+type SnapshotResponse struct {
+	RoomID  string       `json:"roomId"`
+	Status  GameStatus   `json:"status"`
+	Round   int          `json:"round"`
+	Players []PlayerInfo `json:"players"`
+}
+
+// AckResponse 请求已处理（Duplicate 表示同一请求本局已执行过，这次没有重复执行）
+type AckResponse struct {
+	Type      MessageType `json:"type" doc:"被确认的请求类型"`
+	Duplicate bool        `json:"duplicate"`
+}
+
+// ShutdownResponse 服务器即将关闭
+type ShutdownResponse struct {
+	Deadline string `json:"deadline"`
+	Message  string `json:"message"`
+}
+
+// ---- 协议描述 ----
+
+// 消息方向
+const (
+	fromClient = "client"
+	fromServer = "server"
+)
+
+// messageSpec 一种消息的描述，Payload 为 data 的类型（nil 表示没有 data）
+type messageSpec struct {
+	Type        MessageType
+	From        string
+	Payload     interface{}
+	Description string
+}
+
+// protocolMessages 协议中的全部消息
+var protocolMessages = []messageSpec{
+	{TypeHello, fromClient, HelloRequest{}, "握手，协商协议版本（可选，未握手按当前版本处理）"},
+	{TypeConnect, fromClient, ConnectRequest{}, "验证身份，之后的请求只能以该玩家的身份发出"},
+	{TypeJoin, fromClient, JoinRequest{}, "加入房间或重新连接到原座位"},
+	{TypeStart, fromClient, RoomActionRequest{}, "开始新的一局"},
+	{TypeHit, fromClient, RoomActionRequest{}, "要牌"},
+	{TypeStand, fromClient, RoomActionRequest{}, "停牌"},
+	{TypeLeave, fromClient, RoomActionRequest{}, "离开房间"},
+	{TypeChat, fromClient, ChatRequest{}, "发送聊天消息或私聊"},
+	{TypeEmote, fromClient, EmoteRequest{}, "发送快捷表情"},
+	{TypeReplay, fromClient, ReplayRequest{}, "回放已结束的牌局"},
+	{TypeResync, fromClient, ResyncRequest{}, "状态版本不连续时请求补发"},
+
+	{TypeHello, fromServer, HelloResponse{}, "握手结果"},
+	{TypeConnect, fromServer, ConnectResponse{}, "连接结果"},
+	{TypeRoomInfo, fromServer, RoomInfoResponse{}, "房间信息（加入或重新连接后发送）"},
+	{TypePlayers, fromServer, PlayersResponse{}, "玩家列表，其他玩家只显示第一张牌"},
+	{TypeStart, fromServer, StartResponse{}, "游戏开始"},
+	{TypeCardDealt, fromServer, CardDealtResponse{}, "发牌（增量）"},
+	{TypeStatusChanged, fromServer, StatusChangedResponse{}, "座位状态变化（增量）"},
+	{TypeGameEnd, fromServer, GameEndResponse{}, "本局结算"},
+	{TypeChat, fromServer, ChatResponse{}, "聊天消息（私聊不是房间广播，没有 version）"},
+	{TypeChatHistory, fromServer, ChatHistoryResponse{}, "最近的聊天记录"},
+	{TypeEmote, fromServer, EmoteResponse{}, "快捷表情（即时消息，没有 version，断线期间的表情不补发）"},
+	{TypeReplay, fromServer, ReplayResponse{}, "回放结束"},
+	{TypeSnapshot, fromServer, SnapshotResponse{}, "房间完整状态，收到后以其 version 为准，只有自己的牌可见"},
+	{TypeAck, fromServer, AckResponse{}, "带 requestId 的请求处理成功"},
+	{TypeShutdown, fromServer, ShutdownResponse{}, "服务器即将关闭"},
+	{TypeError, fromServer, nil, "错误，code 为错误码，error 为按语言生成的错误描述，fields 为字段级错误"},
+}
+
+// ProtocolSchema 生成描述整个协议的 JSON Schema
+func ProtocolSchema() map[string]interface{} {
+	b := newSchemaBuilder()
+
+	variants := map[string][]interface{}{
+		fromClient: {},
+		fromServer: {},
+	}
+	for _, spec := range protocolMessages {
+		properties := map[string]interface{}{
+			"type": map[string]interface{}{"const": spec.Type},
+		}
+		required := []string{"type"}
+
+		if spec.Payload != nil {
+			properties["data"] = b.ref(reflect.TypeOf(spec.Payload))
+			required = append(required, "data")
+		}
+		if spec.From == fromClient {
+			properties["requestId"] = map[string]interface{}{"type": "string"}
+		} else {
+			properties["version"] = map[string]interface{}{"type": "integer", "description": "房间状态版本，房间广播消息才有"}
+		}
+		if spec.Type == TypeAck {
+			properties["requestId"] = map[string]interface{}{"type": "string"}
+			required = append(required, "requestId")
+		}
+		if spec.Type == TypeError {
+			properties["requestId"] = map[string]interface{}{"type": "string"}
+			properties["code"] = map[string]interface{}{"enum": errorCodes()}
+			properties["error"] = map[string]interface{}{"type": "string"}
+			properties["fields"] = b.ref(reflect.TypeOf([]FieldError{}))
+			required = append(required, "code", "error")
+		}
+
+		variants[spec.From] = append(variants[spec.From], map[string]interface{}{
+			"type":        "object",
+			"description": spec.Description,
+			"properties":  properties,
+			"required":    required,
+		})
+	}
+
+	b.defs["ClientMessage"] = map[string]interface{}{"oneOf": variants[fromClient]}
+	b.defs["ServerMessage"] = map[string]interface{}{"oneOf": variants[fromServer]}
+
+	return map[string]interface{}{
+		"$schema":           "https://json-schema.org/draft/2020-12/schema",
+		"$id":               "/api/protocol",
+		"title":             "21点 WebSocket 协议",
+		"version":           ProtocolVersion,
+		"supportedVersions": supportedVersions,
+		"subprotocols":      subprotocols(),
+		"anyOf": []interface{}{
+			map[string]interface{}{"$ref": "#/$defs/ClientMessage"},
+			map[string]interface{}{"$ref": "#/$defs/ServerMessage"},
+		},
+		"$defs": b.defs,
+	}
+}
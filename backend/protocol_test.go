@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		client []int
+		want   int
+		ok     bool
+	}{
+		{name: "当前版本", client: []int{3}, want: 3, ok: true},
+		{name: "选最高的共同版本", client: []int{1, 2, 3, 4}, want: 3, ok: true},
+		{name: "只支持旧版本", client: []int{1, 2}},
+		{name: "没有版本", client: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := negotiateVersion(tt.client)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("negotiateVersion(%v) = %d, %v, want %d, %v", tt.client, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
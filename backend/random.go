@@ -0,0 +1,79 @@
+package main
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+)
+
+// RandomSource 随机数来源（洗牌、房间ID、玩家ID共用）
+type RandomSource interface {
+	// Intn 返回 [0, n) 区间内的随机整数，n 必须大于0
+	Intn(n int) int
+}
+
+// cryptoRandomSource 基于 crypto/rand 的安全随机数来源（生产环境使用）
+type cryptoRandomSource struct{}
+
+// NewCryptoRandomSource 创建安全随机数来源
+func NewCryptoRandomSource() RandomSource {
+	return cryptoRandomSource{}
+}
+
+// Intn 使用拒绝采样避免取模偏差
+func (cryptoRandomSource) Intn(n int) int {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+
+	max := uint64(n)
+	limit := ^uint64(0) - (^uint64(0) % max)
+	var buf [8]byte
+	for {
+		if _, err := cryptorand.Read(buf[:]); err != nil {
+			panic("crypto/rand 读取失败: " + err.Error())
+		}
+		v := binary.LittleEndian.Uint64(buf[:])
+		if v < limit {
+			return int(v % max)
+		}
+	}
+}
+
+// SeededRandomSource 固定种子的确定性随机数来源（用于测试和回放）
+type SeededRandomSource struct {
+	seed int64
+	rng  *rand.Rand
+	mu   sync.Mutex
+}
+
+// NewSeededRandomSource 创建固定种子的随机数来源
+func NewSeededRandomSource(seed int64) *SeededRandomSource {
+	return &SeededRandomSource{
+		seed: seed,
+		rng:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Intn 返回 [0, n) 区间内的随机整数
+func (s *SeededRandomSource) Intn(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rng.Intn(n)
+}
+
+// Seed 获取种子
+func (s *SeededRandomSource) Seed() int64 {
+	return s.seed
+}
+
+// randomString 用指定字符集生成随机字符串
+func randomString(rng RandomSource, alphabet string, length int) string {
+	buf := make([]byte, length)
+	for i := range buf {
+		buf[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	return string(buf)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSeededRandomSource(t *testing.T) {
+	tests := []struct {
+		name string
+		seed int64
+		n    int
+	}{
+		{name: "小范围", seed: 1, n: 2},
+		{name: "牌组大小", seed: 7, n: 52},
+		{name: "负数种子", seed: -3, n: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := NewSeededRandomSource(tt.seed), NewSeededRandomSource(tt.seed)
+			if a.Seed() != tt.seed {
+				t.Errorf("Seed = %d, want %d", a.Seed(), tt.seed)
+			}
+			for i := 0; i < 100; i++ {
+				x, y := a.Intn(tt.n), b.Intn(tt.n)
+				if x != y {
+					t.Fatalf("第 %d 次: %d != %d，相同种子的序列不同", i, x, y)
+				}
+				if x < 0 || x >= tt.n {
+					t.Fatalf("Intn(%d) = %d 超出范围", tt.n, x)
+				}
+			}
+		})
+	}
+}
+
+func TestCryptoRandomSourceRange(t *testing.T) {
+	rng := NewCryptoRandomSource()
+	for _, n := range []int{1, 2, 52, 1 << 40} {
+		for i := 0; i < 50; i++ {
+			if v := rng.Intn(n); v < 0 || v >= n {
+				t.Fatalf("Intn(%d) = %d 超出范围", n, v)
+			}
+		}
+	}
+}
+
+func TestRandomString(t *testing.T) {
+	const alphabet = "abc123"
+	a := randomString(NewSeededRandomSource(5), alphabet, 16)
+	b := randomString(NewSeededRandomSource(5), alphabet, 16)
+	if a != b {
+		t.Errorf("相同种子生成了不同的字符串: %q, %q", a, b)
+	}
+	if len(a) != 16 {
+		t.Errorf("长度 = %d, want 16", len(a))
+	}
+	for _, r := range a {
+		if !strings.ContainsRune(alphabet, r) {
+			t.Errorf("字符 %q 不在字符集中", r)
+		}
+	}
+}
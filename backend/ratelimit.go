@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimit 令牌桶参数：每秒 Rate 个，最多突发 Burst 个
+type rateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// tokenBucket 令牌桶：每秒补充 rate 个令牌，最多积攒 burst 个（调用方负责加锁）
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket 创建装满令牌的令牌桶
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// refill 按经过的时间补充令牌
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// allow 取一个令牌，没有令牌时返回 false
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full 令牌桶是否已经补满（长期未使用，可以回收）
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// bucketSweepSize 令牌桶数量超过该值时回收已补满的令牌桶
+const bucketSweepSize = 1024
+
+// ipLimiter 按客户端IP分别限速（可并发使用）
+type ipLimiter struct {
+	limit   rateLimit
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// newIPLimiter 创建按IP的限速
+func newIPLimiter(limit rateLimit) *ipLimiter {
+	return &ipLimiter{
+		limit:   limit,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow 为该IP取一个令牌，数量过多时先回收长期未使用的令牌桶
+func (l *ipLimiter) allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= bucketSweepSize {
+			for key, b := range l.buckets {
+				if b.full(now) {
+					delete(l.buckets, key)
+				}
+			}
+		}
+		b = newTokenBucket(l.limit.Rate, l.limit.Burst, now)
+		l.buckets[ip] = b
+	}
+	return b.allow(now)
+}
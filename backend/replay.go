@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// maxReplayDelay 回放时两步之间的最长等待时间（避免长时间挂机导致回放停滞）
+const maxReplayDelay = 10 * time.Second
+
+// Replayer 牌局回放器：根据牌局记录重建房间和牌组，并按原顺序重新生成消息
+type Replayer struct {
+	history *HandHistory
+	speed   float64
+}
+
+// NewReplayer 创建回放器，speed 为回放倍速（1为实时，<=0 表示不等待）
+func NewReplayer(history *HandHistory, speed float64) *Replayer {
+	return &Replayer{
+		history: history,
+		speed:   speed,
+	}
+}
+
+// Run 执行回放，将消息依次交给 send；ctx 取消时提前结束，重放完所有动作仍未结算时返回错误
+func (rp *Replayer) Run(ctx context.Context, send func(Message)) error {
+	h := rp.history
+	if len(h.Deck) == 0 {
+		return newError(ErrReplayFailed, "牌局记录缺少牌序")
+	}
+
+	// 按座位重建房间，事件投影出的消息全部发给观众，观众能看到所有玩家的牌
+	room := newRoom(h.RoomID, nil)
+	room.Round = h.Round - 1
+	room.revealCards = true
+	room.sink = func(out roomMessage) {
+		send(out.Msg)
+	}
+	go room.run()
+	defer room.Close()
+	for _, seat := range h.Seats {
+		room.AddPlayer(seat.PlayerID, seat.Nickname, nil)
+	}
+
+	// 使用记录的牌序重新发牌
+	if err := room.startGameWithDeck(h.StartedBy, "", NewDeckFromCards(h.Deck)); err != nil {
+		return err
+	}
+	for _, deal := range h.InitialDeal {
+		player := room.GetPlayer(deal.PlayerID)
+		if player == nil || cardCodes(player.Cards) != cardCodes(deal.Cards) {
+			return newError(ErrReplayFailed, "初始发牌与记录不一致: "+deal.PlayerID)
+		}
+	}
+
+	last := h.StartedAt
+	for _, action := range h.Actions {
+		if err := rp.wait(ctx, action.Time.Sub(last)); err != nil {
+			return err
+		}
+		last = action.Time
+
+		var err error
+		switch action.Action {
+		case ActionHit:
+			err = room.PlayerHit(action.PlayerID, "")
+		case ActionStand:
+			err = room.PlayerStand(action.PlayerID, "")
+		case ActionLeave:
+			room.RemovePlayer(action.PlayerID)
+			continue
+		default:
+			err = newError(ErrReplayFailed, "未知动作: "+action.Action)
+		}
+		if err != nil {
+			return err
+		}
+
+		if player := room.GetPlayer(action.PlayerID); player == nil || player.HandValue != action.HandValue {
+			return newError(ErrReplayFailed, "回放结果与记录不一致: "+action.PlayerID)
+		}
+	}
+
+	// 所有动作重放完牌局应当已经结算，否则记录不完整
+	if len(room.Histories()) == 0 {
+		return newError(ErrReplayFailed, "回放的牌局没有结算")
+	}
+
+	return nil
+}
+
+// wait 按倍速等待
+func (rp *Replayer) wait(ctx context.Context, d time.Duration) error {
+	if rp.speed <= 0 || d <= 0 {
+		return ctx.Err()
+	}
+
+	d = time.Duration(float64(d) / rp.speed)
+	if d > maxReplayDelay {
+		d = maxReplayDelay
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// recordedRound 三人局：p0 要牌，p1 在牌局中离开，之后 p0、p2 停牌结算，返回牌局记录
+func recordedRound(t *testing.T) *HandHistory {
+	t.Helper()
+
+	room := newTestRoom(t, 3)
+	deck := riggedDeck(t, [][]string{{"9c", "5d", "4h"}, {"Kc", "Qd"}, {"8s", "9s"}})
+	if err := room.startGameWithDeck("p0", "", deck); err != nil {
+		t.Fatal(err)
+	}
+	if err := room.PlayerHit("p0", ""); err != nil {
+		t.Fatal(err)
+	}
+	room.RemovePlayer("p1")
+	for _, id := range []string{"p0", "p2"} {
+		if err := room.PlayerStand(id, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	histories := room.Histories()
+	if len(histories) != 1 {
+		t.Fatalf("牌局记录 %d 局, want 1", len(histories))
+	}
+	return histories[0]
+}
+
+func TestReplayer(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(h *HandHistory)
+		error bool
+	}{
+		{name: "牌局中离开", edit: func(h *HandHistory) {}},
+		{name: "缺少离开动作时不会结算", edit: func(h *HandHistory) {
+			actions := h.Actions[:0]
+			for _, action := range h.Actions {
+				if action.Action != ActionLeave {
+					actions = append(actions, action)
+				}
+			}
+			h.Actions = actions
+		}, error: true},
+		{name: "动作被截断", edit: func(h *HandHistory) { h.Actions = h.Actions[:len(h.Actions)-1] }, error: true},
+		{name: "点数与记录不一致", edit: func(h *HandHistory) { h.Actions[0].HandValue++ }, error: true},
+		{name: "缺少牌序", edit: func(h *HandHistory) { h.Deck = nil }, error: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := recordedRound(t).clone()
+			tt.edit(h)
+
+			var msgs []Message
+			err := NewReplayer(h, 0).Run(context.Background(), func(msg Message) {
+				msgs = append(msgs, msg)
+			})
+			if tt.error {
+				if codeOf(err) != ErrReplayFailed {
+					t.Errorf("Run = %v, want %s", err, ErrReplayFailed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			settled := false
+			for _, msg := range msgs {
+				settled = settled || msg.Type == TypeGameEnd
+			}
+			if !settled {
+				t.Errorf("回放消息 %v, want 包含 gameEnd", messageTypes(msgs))
+			}
+		})
+	}
+}
+
+// TestHistoryRecordsLeave 牌局中离开记录为动作，结算结果中没有离开的玩家
+func TestHistoryRecordsLeave(t *testing.T) {
+	h := recordedRound(t)
+
+	var got []string
+	for _, action := range h.Actions {
+		got = append(got, action.PlayerID+" "+action.Action)
+	}
+	want := []string{"p0 hit", "p1 leave", "p0 stand", "p2 stand"}
+	if len(got) != len(want) {
+		t.Fatalf("动作 = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("动作 = %v, want %v", got, want)
+			break
+		}
+	}
+	for _, result := range h.Results {
+		if result.PlayerID == "p1" {
+			t.Errorf("离开的玩家出现在结算结果中: %+v", result)
+		}
+	}
+}
+
+// messageTypes 消息类型列表，用于失败信息
+func messageTypes(msgs []Message) []MessageType {
+	types := make([]MessageType, len(msgs))
+	for i, msg := range msgs {
+		types[i] = msg.Type
+	}
+	return types
+}
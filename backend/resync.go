@@ -0,0 +1,119 @@
+package main
+
+// messageLogSize 每个房间保留的最近广播消息数量
+const messageLogSize = 64
+
+// messageLog 最近广播消息的环形缓冲区（按版本号递增）
+type messageLog struct {
+	buf   [messageLogSize]roomMessage
+	start int // 最早一条消息的位置
+	n     int // 已保存的消息数量
+}
+
+// push 追加一条消息，缓冲区满时覆盖最早的消息
+func (l *messageLog) push(msg roomMessage) {
+	if l.n < len(l.buf) {
+		l.buf[(l.start+l.n)%len(l.buf)] = msg
+		l.n++
+		return
+	}
+
+	l.buf[l.start] = msg
+	l.start = (l.start + 1) % len(l.buf)
+}
+
+// since 获取版本号大于 version 的消息；缓冲区中缺少紧接 version 的消息时返回 false
+func (l *messageLog) since(version uint64) ([]roomMessage, bool) {
+	if l.n == 0 {
+		return nil, false
+	}
+
+	oldest := l.buf[l.start].Msg.Version
+	if version+1 < oldest {
+		return nil, false
+	}
+
+	msgs := make([]roomMessage, 0, l.n)
+	for i := 0; i < l.n; i++ {
+		msg := l.buf[(l.start+i)%len(l.buf)]
+		if msg.Msg.Version > version {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, true
+}
+
+// stamp 为广播消息（包括各玩家的版本）分配下一个状态版本并记入缓冲区（在房间协程中调用）
+func (r *Room) stamp(out roomMessage) roomMessage {
+	r.version++
+	out.Msg.Version = r.version
+	if len(out.Private) > 0 {
+		private := make(map[string]Message, len(out.Private))
+		for id, msg := range out.Private {
+			msg.Version = r.version
+			private[id] = msg
+		}
+		out.Private = private
+	}
+	r.msgLog.push(out)
+	return out
+}
+
+// Resync 向连接补发 sinceVersion 之后的广播消息；
+// 缓冲区里已经没有这些消息（或版本号来自服务器重启之前）时改发完整快照
+func (r *Room) Resync(playerID string, sinceVersion uint64, conn *WebSocketConn) error {
+	err := errRoomClosed
+	r.do(func() {
+		if _, ok := r.Players[playerID]; !ok {
+			err = newError(ErrPlayerNotFound)
+			return
+		}
+		err = nil
+
+		if sinceVersion == r.version {
+			return
+		}
+		if sinceVersion < r.version {
+			if msgs, ok := r.msgLog.since(sinceVersion); ok {
+				for _, msg := range msgs {
+					conn.Send(msg.messageFor(playerID))
+				}
+				return
+			}
+		}
+
+		conn.Send(snapshotMessage(r, playerID))
+	})
+	return err
+}
+
+// snapshotMessage 构造房间完整状态消息，只有 playerID 自己的牌可见（在房间协程中调用）
+func snapshotMessage(room *Room, playerID string) Message {
+	players := make([]PlayerInfo, 0, len(room.seatOrder))
+	for _, player := range room.orderedPlayers() {
+		players = append(players, room.playerInfo(player, room.hideCards(player.ID, playerID)))
+	}
+
+	return Message{
+		Type:    TypeSnapshot,
+		Version: room.version,
+		Data: toJSON(SnapshotResponse{
+			RoomID:  room.ID,
+			Status:  room.Status,
+			Round:   room.Round,
+			Players: players,
+		}),
+	}
+}
+
+// fullStateMessage 构造广播给全房间的完整状态，每个玩家收到的快照中只有自己的牌可见（在房间协程中调用）
+func fullStateMessage(room *Room) roomMessage {
+	out := roomMessage{
+		Msg:     snapshotMessage(room, ""),
+		Private: make(map[string]Message, len(room.Players)),
+	}
+	for id := range room.Players {
+		out.Private[id] = snapshotMessage(room, id)
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// filledLog 依次写入版本 1..n 的消息
+func filledLog(n int) *messageLog {
+	var l messageLog
+	for v := 1; v <= n; v++ {
+		l.push(roomMessage{Msg: Message{Type: TypeChat, Version: uint64(v)}})
+	}
+	return &l
+}
+
+// versionRange 版本 from..to 的列表，from > to 时为空
+func versionRange(from, to uint64) []uint64 {
+	versions := []uint64{}
+	for v := from; v <= to; v++ {
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+func TestMessageLogSince(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     int
+		since uint64
+		want  []uint64
+		ok    bool
+	}{
+		{name: "空缓冲区", n: 0, since: 0},
+		{name: "从头补发", n: 10, since: 0, want: versionRange(1, 10), ok: true},
+		{name: "补发之后的消息", n: 10, since: 6, want: versionRange(7, 10), ok: true},
+		{name: "已是最新", n: 10, since: 10, want: versionRange(11, 10), ok: true},
+		{name: "刚好装满", n: messageLogSize, since: 0, want: versionRange(1, messageLogSize), ok: true},
+		{name: "覆盖后从最早一条补发", n: 100, since: 100 - messageLogSize, want: versionRange(100-messageLogSize+1, 100), ok: true},
+		{name: "覆盖后缺少紧接的消息", n: 100, since: 100 - messageLogSize - 1},
+		{name: "覆盖后补发最后几条", n: 100, since: 97, want: versionRange(98, 100), ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, ok := filledLog(tt.n).since(tt.since)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			got := []uint64{}
+			for _, msg := range msgs {
+				got = append(got, msg.Msg.Version)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("版本 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRoomResync 缓冲区中有断档之后的消息时逐条补发（玩家收到自己的版本），否则补发完整快照
+func TestRoomResync(t *testing.T) {
+	room := NewRoom("ROOM", NewSeededRandomSource(1))
+	t.Cleanup(room.Stop)
+	conn := newTestConn(ProtocolVersion)
+	if err := room.AddPlayer("p0", "玩家0", conn); err != nil {
+		t.Fatal(err)
+	}
+
+	// 广播 100 条消息，每条 p0 都收到自己的版本
+	var current uint64
+	room.do(func() {
+		for i := 0; i < 100; i++ {
+			room.deliver(roomMessage{
+				Msg:     Message{Type: TypeChat},
+				Private: map[string]Message{"p0": {Type: TypeStatusChanged}},
+			})
+		}
+		current = room.version
+	})
+	received(conn)
+
+	tests := []struct {
+		name     string
+		player   string
+		since    uint64
+		want     []uint64 // 逐条补发的版本
+		snapshot bool
+		err      ErrorCode
+	}{
+		{name: "没有断档", player: "p0", since: current},
+		{name: "补发断档之后的消息", player: "p0", since: current - 3, want: versionRange(current-2, current)},
+		{name: "断档早于缓冲区", player: "p0", since: current - messageLogSize - 1, snapshot: true},
+		{name: "版本来自服务器重启之前", player: "p0", since: current + 10, snapshot: true},
+		{name: "不在房间的玩家", player: "p9", since: 0, err: ErrPlayerNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := room.Resync(tt.player, tt.since, conn)
+			if codeOf(err) != tt.err {
+				t.Fatalf("Resync = %v, want %q", err, tt.err)
+			}
+
+			msgs := received(conn)
+			if tt.snapshot {
+				if len(msgs) != 1 || msgs[0].Type != TypeSnapshot || msgs[0].Version != current {
+					t.Errorf("收到 %v, want 版本 %d 的 snapshot", messageTypes(msgs), current)
+				}
+				return
+			}
+			got := []uint64{}
+			for _, msg := range msgs {
+				if msg.Type != TypeStatusChanged {
+					t.Errorf("补发了 %s, want 玩家自己的版本", msg.Type)
+				}
+				got = append(got, msg.Version)
+			}
+			if want := append([]uint64{}, tt.want...); !reflect.DeepEqual(got, want) {
+				t.Errorf("补发的版本 = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"sync"
 	"time"
 )
@@ -15,214 +15,781 @@ const (
 	GameEnded                     // 游戏结束
 )
 
+// roomCommandBuffer 房间命令队列长度
+const roomCommandBuffer = 64
+
+// autoStandGrace 正在操作的玩家断线后等待重新连接的时间，超时自动停牌
+const autoStandGrace = 20 * time.Second
+
+// errRoomClosed 房间已关闭时提交的命令返回此错误
+var errRoomClosed error = newError(ErrRoomClosed)
+
+// errDuplicateRequest 本局内已处理过同一玩家的同一请求ID，操作没有重复执行
+var errDuplicateRequest = errors.New("重复的请求")
+
+// EventListener 房间事件监听器，在房间协程中按产生顺序接收每条命令产生的事件批次
+// 以及此刻的可持久化状态；监听器内不能再调用房间的方法
+type EventListener func(events []EventRecord, state *RoomState)
+
+// MessageSink 房间消息出口，在房间协程中调用（回放时用来把消息交给观众）
+type MessageSink func(out roomMessage)
+
 // Room 房间
+// 所有状态变化都由命令产生事件，再通过 apply 应用到状态上；
+// 广播和牌局记录都由事件流派生。
+// 房间是一个 actor：状态只在房间自己的协程中读写，外部通过命令队列提交操作，
+// 因此要牌、停牌、加入和广播都严格按提交顺序处理。
 type Room struct {
-	ID          string                   `json:"id"`
-	Players     map[string]*Player       `json:"players"`
-	Status      GameStatus               `json:"status"`
-	Deck        *Deck                    `json:"-"`
-	CurrentTurn int                      `json:"currentTurn"`
-	CreatedAt   time.Time                `json:"createdAt"`
-	Lock        sync.RWMutex            `json:"-"`
+	ID          string             `json:"id"`
+	Players     map[string]*Player `json:"players"`
+	Status      GameStatus         `json:"status"`
+	Deck        *Deck              `json:"-"`
+	CurrentTurn int                `json:"currentTurn"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	Round       int                `json:"round"`
+	rng         RandomSource
+	seatOrder   []string            // 按加入顺序排列的玩家ID
+	history     *HandHistory        // 当前牌局记录
+	histories   []*HandHistory      // 已结束的牌局记录
+	requests    map[string]struct{} // 本局已执行的操作（玩家ID + 请求ID），用于去重
+
+	seq      uint64        // 最后一个事件的序号
+	events   []EventRecord // 最近一次快照之后的事件
+	snapshot *RoomSnapshot // 最近一次快照
+	batch    []EventRecord // 当前命令产生的事件
+	listener EventListener
+	sink     MessageSink
+	stats    StatsLookup
+
+	version uint64               // 状态版本，每条广播消息加一
+	msgLog  messageLog           // 最近的广播消息，用于断档补发
+	deltas  int                  // 上次广播完整状态之后发出的增量消息数
+	chat    chatLog              // 最近的聊天记录
+	emotes  map[string]time.Time // 各玩家上次发送表情的时间
+
+	spectators map[*WebSocketConn]struct{} // 正在回放本房间牌局的观众连接（接收房间的表情）
+
+	standTimers map[string]*time.Timer // 离线且正在操作的玩家的自动停牌计时
+	standGrace  time.Duration          // 断线后等待重新连接的时间，默认 autoStandGrace
+	retired     bool                   // 房间空了，等待房间管理器删除，不再接受加入
+	revealCards bool                   // 回放房间：观众能看到所有玩家的牌和点数
+
+	cmds      chan roomCommand // 命令队列
+	done      chan struct{}    // 房间关闭时关闭
+	stopped   chan struct{}    // 房间协程退出时关闭
+	closeOnce sync.Once
 }
 
-// NewRoom 创建新房间
-func NewRoom(id string) *Room {
+// NewRoom 创建新房间并启动房间协程
+func NewRoom(id string, rng RandomSource) *Room {
+	r := newRoom(id, rng)
+	go r.run()
+	return r
+}
+
+// newRoom 创建房间但不启动房间协程，调用方设置好初始状态后再 go r.run()
+func newRoom(id string, rng RandomSource) *Room {
 	return &Room{
 		ID:        id,
 		Players:   make(map[string]*Player),
+		requests:  make(map[string]struct{}),
+		emotes:    make(map[string]time.Time),
 		Status:    GameWaiting,
 		Deck:      nil,
 		CreatedAt: time.Now(),
+		rng:       rng,
+		cmds:      make(chan roomCommand, roomCommandBuffer),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+
+		spectators:  make(map[*WebSocketConn]struct{}),
+		standTimers: make(map[string]*time.Timer),
+		standGrace:  autoStandGrace,
 	}
 }
 
-// AddPlayer 添加玩家到房间
-func (r *Room) AddPlayer(player *Player) bool {
-	r.Lock.Lock()
-	defer r.Lock.Unlock()
+// roomCommand 交给房间协程执行的命令
+type roomCommand struct {
+	fn       func()
+	finished chan bool // 执行完成时为 true，房间关闭时丢弃为 false；容量为1，房间协程不会阻塞
+}
 
-	if _, exists := r.Players[player.ID]; exists {
-		return false
+// run 房间协程：依次执行命令直到房间关闭，关闭后队列中剩下的命令都不再执行
+func (r *Room) run() {
+	defer close(r.stopped)
+	for {
+		select {
+		case cmd := <-r.cmds:
+			// 关闭和命令同时就绪时 select 随机选择，先检查房间是否已关闭
+			select {
+			case <-r.done:
+				cmd.finished <- false
+				continue
+			default:
+			}
+			cmd.fn()
+			cmd.finished <- true
+		case <-r.done:
+			r.rejectQueued()
+			return
+		}
 	}
+}
 
-	// 游戏开始后不允许新玩家加入
-	if r.Status == GamePlaying {
-		return false
+// rejectQueued 丢弃队列中的命令并通知等待的调用方（房间关闭后在房间协程中调用）
+func (r *Room) rejectQueued() {
+	for {
+		select {
+		case cmd := <-r.cmds:
+			cmd.finished <- false
+		default:
+			return
+		}
 	}
+}
 
-	if len(r.Players) >= 6 { // 最多6个玩家
+// do 将 fn 交给房间协程执行并等待完成，房间已关闭、fn 没有执行时返回 false
+// 返回时 fn 已经执行完或者确定不会再执行，调用方可以直接读取 fn 写入的结果
+// 不能在房间协程内调用（会死锁）
+func (r *Room) do(fn func()) bool {
+	cmd := roomCommand{fn: fn, finished: make(chan bool, 1)}
+	select {
+	case r.cmds <- cmd:
+	case <-r.done:
 		return false
 	}
 
-	player.RoomID = r.ID
-	r.Players[player.ID] = player
-	return true
+	select {
+	case ok := <-cmd.finished:
+		return ok
+	case <-r.stopped:
+		// 房间协程已退出：命令要么在退出前执行或丢弃了，要么留在队列中永远不会执行
+		select {
+		case ok := <-cmd.finished:
+			return ok
+		default:
+			return false
+		}
+	}
+}
+
+// Close 停止房间协程，之后提交的命令都不会执行
+func (r *Room) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+// Stop 停止房间协程并等待正在执行的命令结束，之后房间不会再发送消息或保存状态
+// 不能在房间协程内调用（会死锁）
+func (r *Room) Stop() {
+	r.Close()
+	<-r.stopped
+}
+
+// SetListener 设置事件监听器
+func (r *Room) SetListener(listener EventListener) {
+	r.do(func() {
+		r.listener = listener
+	})
+}
+
+// SetStatsLookup 设置玩家统计查询，玩家列表中会带上各玩家的统计
+func (r *Room) SetStatsLookup(lookup StatsLookup) {
+	r.do(func() {
+		r.stats = lookup
+	})
+}
+
+// SetSink 设置消息出口，未设置时消息发给房间内玩家的连接
+func (r *Room) SetSink(sink MessageSink) {
+	r.do(func() {
+		r.sink = sink
+	})
+}
+
+// AddPlayer 添加玩家到房间
+func (r *Room) AddPlayer(playerID, nickname string, conn *WebSocketConn) error {
+	return r.execute(func() error {
+		if r.retired {
+			return newError(ErrRoomNotFound)
+		}
+
+		if _, exists := r.Players[playerID]; exists {
+			return newError(ErrPlayerExists)
+		}
+
+		// 游戏开始后不允许新玩家加入
+		if r.Status == GamePlaying {
+			return newError(ErrGameInProgress)
+		}
+
+		if len(r.Players) >= 6 { // 最多6个玩家
+			return newError(ErrRoomFull)
+		}
+
+		// 连接不属于领域状态，在投影发送消息前挂到新玩家上
+		r.emit(PlayerJoined{PlayerID: playerID, Nickname: r.uniqueNickname(playerID, nickname)})
+		r.Players[playerID].Conn = conn
+		return nil
+	})
+}
+
+// Rejoin 已在房间中的玩家换用新连接（处理刷新页面的情况），玩家不在房间时返回 false。
+// nickname 为空时保留原昵称，否则按房间内不重名的规则改名
+func (r *Room) Rejoin(playerID, nickname string, conn *WebSocketConn) bool {
+	rejoined := false
+	r.do(func() {
+		player, ok := r.Players[playerID]
+		if !ok {
+			return
+		}
+
+		player.Conn = conn
+		rejoined = true
+		r.cancelAutoStand(playerID)
+
+		r.deliver(roomMessage{To: playerID, Msg: roomInfoMessage(r)})
+		r.deliver(roomMessage{To: playerID, Msg: snapshotMessage(r, playerID)})
+		r.deliver(roomMessage{To: playerID, Msg: chatHistoryMessage(r, playerID)})
+
+		if nickname != "" {
+			nickname = r.uniqueNickname(playerID, nickname)
+		}
+		if nickname != "" && nickname != player.Nickname {
+			r.emit(PlayerRenamed{PlayerID: playerID, Previous: player.Nickname, Nickname: nickname})
+			r.flush()
+			return
+		}
+		r.deliver(roomMessage{Msg: statusChangedMessage(r, player)})
+	})
+
+	return rejoined
 }
 
 // RemovePlayer 从房间移除玩家
 func (r *Room) RemovePlayer(playerID string) {
-	r.Lock.Lock()
-	defer r.Lock.Unlock()
+	r.execute(func() error {
+		player, exists := r.Players[playerID]
+		if !exists {
+			return nil
+		}
 
-	delete(r.Players, playerID)
+		r.emit(PlayerLeft{PlayerID: playerID, Nickname: player.Nickname})
+		delete(r.emotes, playerID)
+		r.cancelAutoStand(playerID)
+		r.settleIfDone()
+		return nil
+	})
+}
 
-	// 如果房间空了，可以标记为待删除
-	if len(r.Players) == 0 {
-		r.Status = GameEnded
+// retireIfEmpty 房间没有玩家时标记为退役并返回 true，之后不再接受加入
+func (r *Room) retireIfEmpty() bool {
+	retired := false
+	r.do(func() {
+		if len(r.Players) == 0 {
+			r.retired = true
+			retired = true
+		}
+	})
+	return retired
+}
+
+// DetachConn 连接断开时解除玩家与该连接的绑定并通知房间（玩家已换用新连接时不处理）
+func (r *Room) DetachConn(playerID string, conn *WebSocketConn) {
+	r.do(func() {
+		player, ok := r.Players[playerID]
+		if !ok || player.Conn != conn {
+			return
+		}
+
+		player.Conn = nil
+		r.deliver(roomMessage{Msg: statusChangedMessage(r, player)})
+		r.scheduleAutoStand()
+	})
+}
+
+// scheduleAutoStand 为离线且正在操作的玩家安排自动停牌，避免牌局一直等待不会回来的玩家（在房间协程中调用）
+// 消息不发给玩家连接的房间（回放）没有在线状态，不处理
+func (r *Room) scheduleAutoStand() {
+	if r.sink != nil || r.Status != GamePlaying {
+		return
+	}
+
+	for _, player := range r.orderedPlayers() {
+		if player.Conn != nil || player.Status != StatusActing || r.standTimers[player.ID] != nil {
+			continue
+		}
+		playerID, round := player.ID, r.Round
+		r.standTimers[playerID] = time.AfterFunc(r.standGrace, func() {
+			r.autoStand(playerID, round)
+		})
 	}
 }
 
-// GetPlayer 获取玩家
+// cancelAutoStand 取消玩家的自动停牌（在房间协程中调用）
+func (r *Room) cancelAutoStand(playerID string) {
+	if timer := r.standTimers[playerID]; timer != nil {
+		timer.Stop()
+		delete(r.standTimers, playerID)
+	}
+}
+
+// autoStand 玩家在宽限期内没有重新连接时代为停牌
+func (r *Room) autoStand(playerID string, round int) {
+	r.execute(func() error {
+		delete(r.standTimers, playerID)
+
+		player, ok := r.Players[playerID]
+		if !ok || player.Conn != nil || player.Status != StatusActing || r.Status != GamePlaying || r.Round != round {
+			return nil
+		}
+
+		r.emit(PlayerStood{PlayerID: playerID})
+		r.settleIfDone()
+		return nil
+	})
+}
+
+// GetPlayer 获取玩家状态的副本，玩家不存在时返回 nil
 func (r *Room) GetPlayer(playerID string) *Player {
-	r.Lock.RLock()
-	defer r.Lock.RUnlock()
+	var player *Player
+	r.do(func() {
+		if p, ok := r.Players[playerID]; ok {
+			player = p.clone()
+		}
+	})
+	return player
+}
 
-	return r.Players[playerID]
+// StartGame 开始游戏，requestID 非空时同一请求在本局内只执行一次
+func (r *Room) StartGame(startedBy, requestID string) error {
+	return r.startGameWithDeck(startedBy, requestID, NewDeck(r.rng))
 }
 
-// StartGame 开始游戏
-func (r *Room) StartGame() error {
-	r.Lock.Lock()
-	defer r.Lock.Unlock()
+// startGameWithDeck 使用指定牌组开始游戏（回放时传入记录的牌序）
+func (r *Room) startGameWithDeck(startedBy, requestID string, deck *Deck) error {
+	return r.execute(func() error {
+		if r.isDuplicate(startedBy, requestID) {
+			return errDuplicateRequest
+		}
 
-	if r.Status == GamePlaying {
-		return fmt.Errorf("游戏已在进行中")
-	}
+		if r.Status == GamePlaying {
+			return newError(ErrGameInProgress)
+		}
+
+		if len(r.Players) < 1 {
+			return newError(ErrNotEnoughPlayers, 1)
+		}
 
-	if len(r.Players) < 1 {
-		return fmt.Errorf("至少需要1个玩家")
+		r.emit(RoundStarted{
+			Round:     r.Round + 1,
+			StartedBy: startedBy,
+			RequestID: requestID,
+			Deck:      deck.Cards(),
+		})
+
+		// 按座位顺序发初始牌（每人2张）
+		for _, player := range r.orderedPlayers() {
+			r.emit(CardDealt{PlayerID: player.ID, Card: r.Deck.Peek(), Initial: true})
+			r.emit(CardDealt{PlayerID: player.ID, Card: r.Deck.Peek(), Initial: true})
+		}
+
+		// 上一局留下的计时作废，开局时已离线的玩家重新计时
+		for playerID := range r.standTimers {
+			r.cancelAutoStand(playerID)
+		}
+		r.scheduleAutoStand()
+		r.settleIfDone()
+		return nil
+	})
+}
+
+// PlayerHit 玩家要牌，requestID 非空时同一请求在本局内只执行一次
+func (r *Room) PlayerHit(playerID, requestID string) error {
+	return r.execute(func() error {
+		if r.isDuplicate(playerID, requestID) {
+			return errDuplicateRequest
+		}
+
+		if r.Status != GamePlaying {
+			return newError(ErrGameNotInProgress)
+		}
+
+		player, exists := r.Players[playerID]
+		if !exists {
+			return newError(ErrPlayerNotFound)
+		}
+
+		if !player.CanAct() {
+			return newError(ErrNotYourTurn)
+		}
+
+		r.emit(CardDealt{PlayerID: playerID, Card: r.Deck.Peek(), RequestID: requestID})
+		r.settleIfDone()
+		return nil
+	})
+}
+
+// PlayerStand 玩家停牌，requestID 非空时同一请求在本局内只执行一次
+func (r *Room) PlayerStand(playerID, requestID string) error {
+	return r.execute(func() error {
+		if r.isDuplicate(playerID, requestID) {
+			return errDuplicateRequest
+		}
+
+		if r.Status != GamePlaying {
+			return newError(ErrGameNotInProgress)
+		}
+
+		player, exists := r.Players[playerID]
+		if !exists {
+			return newError(ErrPlayerNotFound)
+		}
+
+		if player.Status != StatusActing {
+			return newError(ErrNotYourTurn)
+		}
+
+		r.emit(PlayerStood{PlayerID: playerID, RequestID: requestID})
+		r.settleIfDone()
+		return nil
+	})
+}
+
+// PlayerList 按座位顺序获取玩家状态的副本
+func (r *Room) PlayerList() []*Player {
+	var players []*Player
+	r.do(func() {
+		for _, player := range r.orderedPlayers() {
+			players = append(players, player.clone())
+		}
+	})
+	return players
+}
+
+// GetStatus 获取游戏状态
+func (r *Room) GetStatus() GameStatus {
+	status := GameEnded
+	r.do(func() {
+		status = r.Status
+	})
+	return status
+}
+
+// CheckGameEnd 检查游戏是否结束
+func (r *Room) CheckGameEnd() bool {
+	return r.GetStatus() != GamePlaying
+}
+
+// settleIfDone 所有玩家都结束操作（停牌或爆牌）时结算本局（在房间协程中调用）
+func (r *Room) settleIfDone() {
+	if r.Status != GamePlaying || len(r.Players) == 0 {
+		return
 	}
 
-	// 重置所有玩家
 	for _, player := range r.Players {
-		player.Reset()
-		player.Status = StatusActing
+		if player.Status == StatusActing {
+			return
+		}
 	}
 
-	// 创建新牌组并洗牌
-	r.Deck = NewDeck()
+	players := r.orderedPlayers()
+	maxScore := 0
+	winnerID := ""
 
-	// 发初始牌（每人2张）
-	for _, player := range r.Players {
-		player.AddCard(r.Deck.Deal())
-		player.AddCard(r.Deck.Deal())
-
-		// 检查是否直接21点
-		if IsBlackjack(player.Cards) {
-			player.Status = StatusStood
+	// 找出最高分（不超过21）
+	for _, player := range players {
+		if player.Status != StatusBust && player.HandValue > maxScore {
+			maxScore = player.HandValue
+			winnerID = player.ID
 		}
 	}
 
-	r.Status = GamePlaying
-	r.CurrentTurn = 0
+	// 生成结果：与赢家同分的玩家为平局；赢家拿走输家的底注，无人获胜时退还底注
+	results := make([]RoundResult, 0, len(players))
+	losers := 0
+	for _, player := range players {
+		result := RoundResult{
+			PlayerID:  player.ID,
+			Nickname:  player.Nickname,
+			Score:     player.HandValue,
+			Status:    player.GetStatusString(),
+			IsWinner:  player.ID == winnerID,
+			Outcome:   OutcomeLoss,
+			Blackjack: IsBlackjack(player.Cards),
+		}
+		switch {
+		case result.IsWinner:
+			result.Outcome = OutcomeWin
+		case winnerID != "" && player.Status != StatusBust && player.HandValue == maxScore:
+			result.Outcome = OutcomePush
+		case winnerID != "":
+			result.Chips = -roundStake
+			losers++
+		}
+		results = append(results, result)
+	}
+	for i := range results {
+		if results[i].IsWinner {
+			results[i].Chips = int64(losers) * roundStake
+		}
+	}
 
-	return nil
+	r.emit(RoundSettled{Results: results})
 }
 
-// PlayerHit 玩家要牌
-func (r *Room) PlayerHit(playerID string) error {
-	r.Lock.Lock()
-	defer r.Lock.Unlock()
+// execute 在房间协程中执行命令，然后按顺序投影、发送并通知命令产生的事件
+func (r *Room) execute(cmd func() error) error {
+	err := errRoomClosed
+	r.do(func() {
+		err = cmd()
+		r.flush()
+	})
+	return err
+}
 
-	if r.Status != GamePlaying {
-		return fmt.Errorf("游戏未进行中")
+// emit 产生一个事件并应用到房间状态（在房间协程中调用）
+func (r *Room) emit(event RoomEvent) {
+	r.seq++
+	rec := EventRecord{
+		Seq:   r.seq,
+		Time:  time.Now(),
+		Event: event,
 	}
 
-	player, exists := r.Players[playerID]
-	if !exists {
-		return fmt.Errorf("玩家不存在")
+	r.apply(rec)
+	r.events = append(r.events, rec)
+	r.batch = append(r.batch, rec)
+
+	if len(r.events) >= snapshotInterval {
+		r.takeSnapshot()
 	}
+}
+
+// apply 将事件应用到房间状态，是房间状态唯一的修改入口（在房间协程中调用）
+func (r *Room) apply(rec EventRecord) {
+	switch e := rec.Event.(type) {
+	case PlayerJoined:
+		player := NewPlayer(e.PlayerID, e.Nickname)
+		player.RoomID = r.ID
+		r.Players[e.PlayerID] = player
+		r.seatOrder = append(r.seatOrder, e.PlayerID)
+
+	case PlayerLeft:
+		delete(r.Players, e.PlayerID)
+		for i, id := range r.seatOrder {
+			if id == e.PlayerID {
+				r.seatOrder = append(r.seatOrder[:i], r.seatOrder[i+1:]...)
+				break
+			}
+		}
+
+		// 如果房间空了，可以标记为待删除
+		if len(r.Players) == 0 {
+			r.Status = GameEnded
+		}
+
+	case PlayerRenamed:
+		if player, ok := r.Players[e.PlayerID]; ok {
+			player.Nickname = e.Nickname
+		}
 
-	if !player.CanAct() {
-		return fmt.Errorf("当前不能操作")
+	case RoundStarted:
+		// 重置所有玩家
+		for _, player := range r.Players {
+			player.Reset()
+			player.Status = StatusActing
+		}
+
+		r.Deck = NewDeckFromCards(e.Deck)
+		r.Round = e.Round
+		r.requests = make(map[string]struct{})
+		r.markRequest(e.StartedBy, e.RequestID)
+		r.Status = GamePlaying
+		r.CurrentTurn = 0
+
+	case CardDealt:
+		r.Deck.Deal()
+		if player, ok := r.Players[e.PlayerID]; ok {
+			player.AddCard(e.Card)
+
+			// 检查是否直接21点
+			if e.Initial && IsBlackjack(player.Cards) {
+				player.Status = StatusStood
+			}
+		}
+		r.markRequest(e.PlayerID, e.RequestID)
+
+	case PlayerStood:
+		if player, ok := r.Players[e.PlayerID]; ok {
+			player.Stand()
+		}
+		r.markRequest(e.PlayerID, e.RequestID)
+
+	case RoundSettled:
+		r.Status = GameEnded
 	}
 
-	card := r.Deck.Deal()
-	player.AddCard(card)
+	r.recordHistory(rec)
+}
 
-	return nil
+// requestKey 去重用的请求键
+func requestKey(playerID, requestID string) string {
+	return playerID + "/" + requestID
 }
 
-// PlayerStand 玩家停牌
-func (r *Room) PlayerStand(playerID string) error {
-	r.Lock.Lock()
-	defer r.Lock.Unlock()
+// isDuplicate 本局是否已执行过该请求（在房间协程中调用）
+func (r *Room) isDuplicate(playerID, requestID string) bool {
+	if requestID == "" {
+		return false
+	}
+	_, ok := r.requests[requestKey(playerID, requestID)]
+	return ok
+}
 
-	if r.Status != GamePlaying {
-		return fmt.Errorf("游戏未进行中")
+// markRequest 记录已执行的请求，由 apply 调用，因此重启恢复后去重仍然有效
+func (r *Room) markRequest(playerID, requestID string) {
+	if requestID != "" {
+		r.requests[requestKey(playerID, requestID)] = struct{}{}
 	}
+}
 
-	player, exists := r.Players[playerID]
-	if !exists {
-		return fmt.Errorf("玩家不存在")
+// flush 将当前命令产生的事件投影为消息发送出去，再交给监听器（在房间协程中调用）
+func (r *Room) flush() {
+	if len(r.batch) == 0 {
+		return
 	}
+	batch := r.batch
+	r.batch = nil
 
-	if player.Status != StatusActing {
-		return fmt.Errorf("当前不能停牌")
+	for _, out := range projectEvents(r, batch) {
+		r.deliver(out)
 	}
 
-	player.Stand()
-	return nil
+	if r.listener != nil {
+		r.listener(batch, r.state())
+	}
 }
 
-// CheckGameEnd 检查游戏是否结束
-func (r *Room) CheckGameEnd() bool {
-	r.Lock.RLock()
-	defer r.Lock.RUnlock()
+// deliver 发送一条房间消息，广播消息带上状态版本（在房间协程中调用）
+func (r *Room) deliver(out roomMessage) {
+	if out.To == "" && !out.Ephemeral {
+		out = r.stamp(out)
+	}
 
-	if r.Status != GamePlaying {
-		return true
+	if r.sink != nil {
+		r.sink(out)
+		return
 	}
 
-	// 检查所有玩家是否都结束操作（停牌或爆牌）
-	allDone := true
-	for _, player := range r.Players {
-		if player.Status == StatusActing {
-			allDone = false
-			break
+	if out.To == "" {
+		for _, player := range r.Players {
+			if player.Conn != nil {
+				player.Conn.Send(out.messageFor(player.ID))
+			}
 		}
+		return
 	}
 
-	if allDone {
-		r.Status = GameEnded
-		return true
+	if player, ok := r.Players[out.To]; ok && player.Conn != nil {
+		player.Conn.Send(out.Msg)
 	}
+}
 
-	return false
+// Events 获取最近一次快照之后的事件
+func (r *Room) Events() []EventRecord {
+	var events []EventRecord
+	r.do(func() {
+		events = append([]EventRecord(nil), r.events...)
+	})
+	return events
 }
 
-// Broadcast 向房间内所有玩家广播消息
-func (r *Room) Broadcast(message Message) {
-	r.Lock.RLock()
-	defer r.Lock.RUnlock()
+// Snapshot 获取最近一次快照（可能为nil）
+func (r *Room) Snapshot() *RoomSnapshot {
+	var snapshot *RoomSnapshot
+	r.do(func() {
+		snapshot = r.snapshot
+	})
+	return snapshot
+}
 
-	for _, player := range r.Players {
-		if player.Conn != nil {
-			player.Conn.Send(message)
+// Histories 获取已结束的牌局记录
+func (r *Room) Histories() []*HandHistory {
+	var histories []*HandHistory
+	r.do(func() {
+		histories = append([]*HandHistory(nil), r.histories...)
+	})
+	return histories
+}
+
+// orderedPlayers 按座位顺序获取玩家（在房间协程中调用）
+func (r *Room) orderedPlayers() []*Player {
+	players := make([]*Player, 0, len(r.seatOrder))
+	for _, id := range r.seatOrder {
+		if player, ok := r.Players[id]; ok {
+			players = append(players, player)
 		}
 	}
+	return players
+}
+
+// seatOf 玩家的座位号（按加入顺序从0开始），不在房间中时返回 -1（在房间协程中调用）
+func (r *Room) seatOf(playerID string) int {
+	for i, id := range r.seatOrder {
+		if id == playerID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Broadcast 向房间内所有玩家广播消息（与房间事件产生的消息保持先后顺序）
+func (r *Room) Broadcast(message Message) {
+	r.do(func() {
+		r.deliver(roomMessage{Msg: message})
+	})
 }
 
 // GetPlayersList 获取玩家列表
-func (r *Room) GetPlayersList(excludeID string) []map[string]interface{} {
-	r.Lock.RLock()
-	defer r.Lock.RUnlock()
+func (r *Room) GetPlayersList(excludeID string) []PlayerInfo {
+	var players []PlayerInfo
+	r.do(func() {
+		players = r.playersList(excludeID)
+	})
+	return players
+}
 
-	players := make([]map[string]interface{}, 0)
-	for _, player := range r.Players {
-		// 为其他玩家隐藏牌
-		hideCards := (player.ID != excludeID)
-		players = append(players, player.ToMap(hideCards))
+// playersList 获取玩家列表，excludeID 以外的玩家隐藏手牌（在房间协程中调用）
+func (r *Room) playersList(excludeID string) []PlayerInfo {
+	players := make([]PlayerInfo, 0)
+	for _, player := range r.orderedPlayers() {
+		players = append(players, r.playerInfo(player, r.hideCards(player.ID, excludeID)))
 	}
 
 	return players
 }
 
+// hideCards 玩家的牌和操作中的点数是否对 viewerID 隐藏：只有玩家自己能看到，回放房间全部公开（在房间协程中调用）
+func (r *Room) hideCards(playerID, viewerID string) bool {
+	return !r.revealCards && playerID != viewerID
+}
+
+// playerInfo 发给客户端的玩家状态，带上玩家统计（在房间协程中调用）
+func (r *Room) playerInfo(player *Player, hideCards bool) PlayerInfo {
+	info := player.Info(hideCards)
+	if r.stats != nil {
+		info.Stats = r.stats(player.ID)
+	}
+	return info
+}
+
 // PlayerCount 获取玩家数量
 func (r *Room) PlayerCount() int {
-	r.Lock.RLock()
-	defer r.Lock.RUnlock()
-
-	return len(r.Players)
+	count := 0
+	r.do(func() {
+		count = len(r.Players)
+	})
+	return count
 }
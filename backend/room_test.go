@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// codeOf 业务错误的错误码，不是业务错误时为空
+func codeOf(err error) ErrorCode {
+	var gerr *GameError
+	if errors.As(err, &gerr) {
+		return gerr.Code
+	}
+	return ""
+}
+
+// playerIDs 测试房间中按座位顺序的玩家ID
+func playerIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = "p" + strconv.Itoa(i)
+	}
+	return ids
+}
+
+// newTestRoom 创建有 n 个玩家的房间（玩家没有连接），测试结束时停止房间协程
+func newTestRoom(t *testing.T, n int) *Room {
+	t.Helper()
+
+	room := NewRoom("ROOM", NewSeededRandomSource(1))
+	t.Cleanup(room.Stop)
+	for i, id := range playerIDs(n) {
+		if err := room.AddPlayer(id, "玩家"+strconv.Itoa(i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return room
+}
+
+// riggedDeck 按座位顺序发出 hands 的牌组：先是每人的前两张，再依次是每人要的牌
+func riggedDeck(t *testing.T, hands [][]string) *Deck {
+	t.Helper()
+
+	var dealt []Card
+	for _, cards := range hands {
+		dealt = append(dealt, hand(t, cards[:2]...)...)
+	}
+	for _, cards := range hands {
+		dealt = append(dealt, hand(t, cards[2:]...)...)
+	}
+
+	// 牌组最后一张最先发出；底下垫几张牌，多要的牌不会是零值
+	deck := hand(t, "2c", "3c", "4c")
+	for i := len(dealt) - 1; i >= 0; i-- {
+		deck = append(deck, dealt[i])
+	}
+	return NewDeckFromCards(deck)
+}
+
+func TestRoomCommandErrors(t *testing.T) {
+	room := newTestRoom(t, 2)
+
+	tests := []struct {
+		name string
+		run  func() error
+		want ErrorCode
+	}{
+		{name: "未开局不能要牌", run: func() error { return room.PlayerHit("p0", "") }, want: ErrGameNotInProgress},
+		{name: "未开局不能停牌", run: func() error { return room.PlayerStand("p0", "") }, want: ErrGameNotInProgress},
+		{name: "重复加入", run: func() error { return room.AddPlayer("p1", "x", nil) }, want: ErrPlayerExists},
+		{name: "开局", run: func() error {
+			return room.startGameWithDeck("p0", "start-1", riggedDeck(t, [][]string{{"2c", "3c"}, {"4c", "5c"}}))
+		}},
+		{name: "游戏中不能加入", run: func() error { return room.AddPlayer("p9", "x", nil) }, want: ErrGameInProgress},
+		{name: "游戏中不能再开局", run: func() error { return room.StartGame("p1", "start-2") }, want: ErrGameInProgress},
+		{name: "不在房间的玩家", run: func() error { return room.PlayerHit("p9", "") }, want: ErrPlayerNotFound},
+		{name: "要牌", run: func() error { return room.PlayerHit("p0", "hit-1") }},
+		{name: "重复的要牌请求", run: func() error { return room.PlayerHit("p0", "hit-1") }},
+		{name: "重复的开局请求", run: func() error { return room.StartGame("p0", "start-1") }},
+		{name: "停牌", run: func() error { return room.PlayerStand("p0", "") }},
+		{name: "停牌后不能要牌", run: func() error { return room.PlayerHit("p0", "") }, want: ErrNotYourTurn},
+	}
+
+	for _, tt := range tests {
+		err := tt.run()
+		if errors.Is(err, errDuplicateRequest) {
+			err = nil
+		}
+		if codeOf(err) != tt.want || (err == nil) != (tt.want == "") {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// 重复的请求没有再执行
+	if player := room.GetPlayer("p0"); len(player.Cards) != 3 {
+		t.Errorf("p0 有 %d 张牌, want 3", len(player.Cards))
+	}
+	if err := room.PlayerHit("p0", "hit-1"); !errors.Is(err, errDuplicateRequest) {
+		t.Errorf("重复请求 = %v, want errDuplicateRequest", err)
+	}
+}
+
+func TestRoomFull(t *testing.T) {
+	room := newTestRoom(t, 6)
+	if err := room.AddPlayer("p6", "第七人", nil); codeOf(err) != ErrRoomFull {
+		t.Errorf("第七个玩家加入 = %v, want %s", err, ErrRoomFull)
+	}
+}
+
+// playRound 用 riggedDeck 开一局：每个玩家按 hands 要牌，仍在操作的玩家停牌，返回结算结果
+func playRound(t *testing.T, room *Room, hands [][]string) []RoundResult {
+	t.Helper()
+
+	var results []RoundResult
+	room.SetListener(func(events []EventRecord, _ *RoomState) {
+		for _, rec := range events {
+			if e, ok := rec.Event.(RoundSettled); ok {
+				results = e.Results
+			}
+		}
+	})
+
+	ids := playerIDs(len(hands))
+	if err := room.startGameWithDeck(ids[0], "", riggedDeck(t, hands)); err != nil {
+		t.Fatal(err)
+	}
+	for i, cards := range hands {
+		for range cards[2:] {
+			if err := room.PlayerHit(ids[i], ""); err != nil {
+				t.Fatalf("%s 要牌: %v", ids[i], err)
+			}
+		}
+	}
+	for _, id := range ids {
+		if player := room.GetPlayer(id); player.Status == StatusActing {
+			if err := room.PlayerStand(id, ""); err != nil {
+				t.Fatalf("%s 停牌: %v", id, err)
+			}
+		}
+	}
+
+	if room.GetStatus() != GameEnded {
+		t.Fatal("所有玩家停牌后牌局没有结算")
+	}
+	return results
+}
+
+func TestRoomSettlement(t *testing.T) {
+	type outcome struct {
+		Outcome string
+		Winner  bool
+		Chips   int64
+	}
+	win := func(chips int64) outcome { return outcome{OutcomeWin, true, chips} }
+	loss := func(chips int64) outcome { return outcome{OutcomeLoss, false, chips} }
+	push := outcome{OutcomePush, false, 0}
+
+	tests := []struct {
+		name  string
+		hands [][]string
+		want  []outcome
+	}{
+		{name: "单人局获胜不赢筹码", hands: [][]string{{"Kc", "Qd"}}, want: []outcome{win(0)}},
+		{name: "单人爆牌", hands: [][]string{{"Kc", "Qd", "5h"}}, want: []outcome{loss(0)}},
+		{name: "高分获胜", hands: [][]string{{"Kc", "Qd"}, {"9c", "9d"}}, want: []outcome{win(roundStake), loss(-roundStake)}},
+		{name: "同分时先加入的玩家获胜", hands: [][]string{{"Kc", "Qd"}, {"Jh", "Ts"}}, want: []outcome{win(0), push}},
+		{name: "与赢家同分的玩家平局", hands: [][]string{{"9c", "9d"}, {"Kc", "Qd"}, {"Jh", "Ts"}}, want: []outcome{loss(-roundStake), win(roundStake), push}},
+		{name: "赢家拿走所有输家的底注", hands: [][]string{{"Kc", "Qd"}, {"9c", "9d"}, {"8c", "8d"}}, want: []outcome{win(2 * roundStake), loss(-roundStake), loss(-roundStake)}},
+		{name: "爆牌的玩家输", hands: [][]string{{"Kc", "Qd", "5h"}, {"9c", "6d"}}, want: []outcome{loss(-roundStake), win(roundStake)}},
+		{name: "全部爆牌不输赢筹码", hands: [][]string{{"Kc", "Qd", "5h"}, {"9c", "6d", "Kh"}}, want: []outcome{loss(0), loss(0)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := newTestRoom(t, len(tt.hands))
+			results := playRound(t, room, tt.hands)
+
+			got := make([]outcome, 0, len(results))
+			for _, r := range results {
+				got = append(got, outcome{r.Outcome, r.IsWinner, r.Chips})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("结果 = %+v\nwant %+v", got, tt.want)
+			}
+			for i, r := range results {
+				if wantScore := CalculateHandValue(hand(t, tt.hands[i]...)); r.Score != wantScore || r.PlayerID != playerIDs(len(tt.hands))[i] {
+					t.Errorf("第 %d 个结果 = %s %d点, want %d点", i, r.PlayerID, r.Score, wantScore)
+				}
+			}
+		})
+	}
+}
+
+// TestRoomStopConcurrentCommands 房间停止时仍有命令在提交：do 返回 false 的命令不会再执行，返回 true 的命令已经执行完
+func TestRoomStopConcurrentCommands(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		room := NewRoom("ROOM", NewSeededRandomSource(1))
+
+		var ran, reported atomic.Int64
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					if room.do(func() { ran.Add(1) }) {
+						reported.Add(1)
+					}
+				}
+			}()
+		}
+		room.Stop()
+		wg.Wait()
+
+		if ran.Load() != reported.Load() {
+			t.Fatalf("执行了 %d 个命令，do 报告执行了 %d 个", ran.Load(), reported.Load())
+		}
+		if room.do(func() { t.Error("停止后的命令被执行") }) {
+			t.Error("停止后 do 返回 true")
+		}
+		if err := room.AddPlayer("p0", "玩家0", nil); !errors.Is(err, errRoomClosed) {
+			t.Errorf("停止后加入 = %v, want errRoomClosed", err)
+		}
+	}
+}
+
+// waitFor 等待 cond 成立，超时返回 false
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+// TestAutoStand 正在操作的玩家断线超过宽限期后自动停牌，宽限期内重新连接时取消
+func TestAutoStand(t *testing.T) {
+	const grace = 20 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		stand     bool // 断线前已经停牌
+		reconnect bool // 宽限期内重新连接
+		want      PlayerStatus
+	}{
+		{name: "断线超时自动停牌", want: StatusStood},
+		{name: "重新连接取消自动停牌", reconnect: true, want: StatusActing},
+		{name: "已停牌的玩家不处理", stand: true, want: StatusStood},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := NewRoom("ROOM", NewSeededRandomSource(1))
+			t.Cleanup(room.Stop)
+			room.do(func() { room.standGrace = grace })
+
+			conns := []*WebSocketConn{newTestConn(3), newTestConn(3)}
+			for i, id := range playerIDs(2) {
+				if err := room.AddPlayer(id, "玩家"+strconv.Itoa(i), conns[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := room.startGameWithDeck("p0", "", riggedDeck(t, [][]string{{"2c", "3c"}, {"4c", "5c"}})); err != nil {
+				t.Fatal(err)
+			}
+			if tt.stand {
+				if err := room.PlayerStand("p0", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			room.DetachConn("p0", conns[0])
+			if tt.reconnect {
+				if !room.Rejoin("p0", "", newTestConn(3)) {
+					t.Fatal("重新连接失败")
+				}
+			}
+
+			if tt.want == StatusStood {
+				ok := waitFor(time.Second, func() bool { return room.GetPlayer("p0").Status == StatusStood })
+				if !ok {
+					t.Fatal("宽限期后没有自动停牌")
+				}
+			} else {
+				time.Sleep(5 * grace)
+				if got := room.GetPlayer("p0").Status; got != tt.want {
+					t.Errorf("状态 = %v, want %v", got, tt.want)
+				}
+			}
+			if got := room.GetPlayer("p1").Status; got != StatusActing {
+				t.Errorf("在线的玩家状态 = %v, want 仍在操作", got)
+			}
+			room.do(func() {
+				if len(room.standTimers) != 0 {
+					t.Errorf("留下 %d 个自动停牌计时", len(room.standTimers))
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// saveDelay 标记改动后等待多久再保存，期间的改动合并为一次写入
+const saveDelay = time.Second
+
+// saver 后台保存：改动只做标记，由后台协程合并后写出，
+// 避免在房间协程和HTTP请求中同步写文件
+type saver struct {
+	name      string       // 日志中的名称
+	save      func() error // 取出当前状态并写出（在后台协程中调用）
+	dirty     chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// newSaver 创建后台保存并启动后台协程
+func newSaver(name string, save func() error) *saver {
+	s := &saver{
+		name:    name,
+		save:    save,
+		dirty:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// mark 标记有改动（不阻塞，可以在持有锁时调用）
+func (s *saver) mark() {
+	select {
+	case s.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// run 后台协程：有改动时等待 saveDelay 后保存，关闭时写出剩余的改动
+func (s *saver) run() {
+	defer close(s.stopped)
+
+	timer := time.NewTimer(saveDelay)
+	timer.Stop()
+	for {
+		select {
+		case <-s.dirty:
+			timer.Reset(saveDelay)
+			select {
+			case <-timer.C:
+			case <-s.done:
+				timer.Stop()
+			}
+			s.flush()
+		case <-s.done:
+			select {
+			case <-s.dirty:
+				s.flush()
+			default:
+			}
+			return
+		}
+	}
+}
+
+// flush 写出当前状态，失败时只记录日志
+func (s *saver) flush() {
+	if err := s.save(); err != nil {
+		log.Printf("保存%s失败: %v", s.name, err)
+	}
+}
+
+// close 停止后台协程并等待剩余的改动写出；之后的改动不再保存
+func (s *saver) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	<-s.stopped
+}
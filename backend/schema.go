@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// schemaBuilder 由 Go 类型生成 JSON Schema，具名结构体放到 $defs 中按名称引用
+type schemaBuilder struct {
+	defs map[string]interface{}
+}
+
+// newSchemaBuilder 创建 JSON Schema 生成器
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{defs: make(map[string]interface{})}
+}
+
+// ref 生成类型的 schema（具名结构体返回 $ref）
+func (b *schemaBuilder) ref(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.ref(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.ref(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.ref(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.defs[t.Name()]; !ok {
+			b.defs[t.Name()] = nil // 先占位，防止递归类型无限展开
+			b.defs[t.Name()] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// object 生成结构体的 object schema，validate 标签转换为 required 和长度/取值范围
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := jsonFieldName(sf)
+		if name == "" {
+			continue
+		}
+
+		prop := b.ref(sf.Type)
+		for _, rule := range strings.Split(sf.Tag.Get("validate"), ",") {
+			key, arg, _ := strings.Cut(rule, "=")
+			switch key {
+			case "required":
+				required = append(required, name)
+			case "min", "max":
+				if limit, err := strconv.ParseFloat(arg, 64); err == nil {
+					prop[schemaLimitKeyword(sf.Type, key)] = limit
+				}
+			}
+		}
+		if desc := sf.Tag.Get("doc"); desc != "" {
+			prop["description"] = desc
+		}
+		properties[name] = prop
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// schemaLimitKeyword min/max 规则在 JSON Schema 中对应的关键字
+func schemaLimitKeyword(t reflect.Type, key string) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var kw string
+	switch t.Kind() {
+	case reflect.String:
+		kw = "Length"
+	case reflect.Slice, reflect.Array:
+		kw = "Items"
+	case reflect.Map:
+		kw = "Properties"
+	default:
+		if key == "min" {
+			return "minimum"
+		}
+		return "maximum"
+	}
+	return key + kw
+}
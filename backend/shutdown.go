@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// shutdownPollInterval 等待牌局结束时的检查间隔
+const shutdownPollInterval = 200 * time.Millisecond
+
+// Shutdown 优雅关闭：停止接受新房间和加入，通知所有连接，
+// 等待进行中的牌局结束（ctx 到期时保存进度以便重启后恢复），停止所有房间，最后关闭所有连接
+func (rm *RoomManager) Shutdown(ctx context.Context) {
+	rm.closing.Store(true)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now()
+	}
+
+	rm.broadcastAll(Message{
+		Type: TypeShutdown,
+		Data: toJSON(ShutdownResponse{
+			Deadline: deadline.Format(time.RFC3339),
+			Message:  "服务器即将关闭，进行中的牌局结束后将断开连接",
+		}),
+	})
+
+	// 等待进行中的牌局结束
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+wait:
+	for rm.playingRooms() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("关闭超时，%d 个牌局未结束，已保存以便重启后恢复", rm.playingRooms())
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	// 保存所有房间（未结束的牌局重启后继续），然后停止房间协程，返回之后不再写房间文件
+	for _, room := range rm.allRooms() {
+		rm.persistRoom(room)
+		room.Stop()
+	}
+
+	// 发送关闭帧并断开所有连接
+	for _, conn := range rm.allConns() {
+		conn.CloseWithReason(websocket.CloseGoingAway, "服务器关闭")
+	}
+}
+
+// Close 写出玩家身份、统计和排行榜未保存的改动并停止后台保存（HTTP服务关闭之后调用）
+func (rm *RoomManager) Close() {
+	rm.accounts.close()
+	rm.stats.close()
+	rm.boards.close()
+}
+
+// playingRooms 统计进行中的牌局数量
+func (rm *RoomManager) playingRooms() int {
+	count := 0
+	for _, room := range rm.allRooms() {
+		if !room.CheckGameEnd() {
+			count++
+		}
+	}
+	return count
+}
+
+// allRooms 获取所有房间
+func (rm *RoomManager) allRooms() []*Room {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	rooms := make([]*Room, 0, len(rm.rooms))
+	for _, room := range rm.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// allConns 获取所有连接
+func (rm *RoomManager) allConns() []*WebSocketConn {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	conns := make([]*WebSocketConn, 0, len(rm.conns))
+	for conn := range rm.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// broadcastAll 向所有连接广播消息
+func (rm *RoomManager) broadcastAll(msg Message) {
+	for _, conn := range rm.allConns() {
+		conn.Send(msg)
+	}
+}
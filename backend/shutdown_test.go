@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dirListing 目录中每个文件的大小和修改时间
+func dirListing(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		listing[entry.Name()] = strconv.FormatInt(info.Size(), 10) + " " + info.ModTime().Format(time.RFC3339Nano)
+	}
+	return listing
+}
+
+// TestShutdownConcurrent 关闭期间并发加入、开局、要牌和保存：没有数据竞争，Shutdown 返回之后不再写房间文件
+func TestShutdownConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm := NewRoomManager(NewSeededRandomSource(1))
+	if _, err := rm.UseStore(store); err != nil {
+		t.Fatal(err)
+	}
+	defer rm.Close()
+
+	var rooms []*Room
+	for i := 0; i < 4; i++ {
+		room, err := rm.CreateRoom()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rooms = append(rooms, room)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			room := rooms[w%len(rooms)]
+			playerID := "p" + strconv.Itoa(w)
+			conn := newTestConn(2)
+			rm.mu.Lock()
+			rm.conns[conn] = struct{}{}
+			rm.mu.Unlock()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// 错误（房间已满、不是你的回合、服务器正在关闭等）都在预期之内
+				rm.JoinRoom(room.ID, playerID, "玩家"+strconv.Itoa(w), conn)
+				room.StartGame(playerID, "")
+				room.PlayerHit(playerID, "")
+				rm.persistRoom(room)
+				received(conn)
+			}
+		}(w)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	rm.Shutdown(ctx)
+
+	// Shutdown 返回之后继续操作一段时间，房间文件不应再变化
+	before := dirListing(t, dir)
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	after := dirListing(t, dir)
+
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("Shutdown 之后房间文件发生变化:\n before %v\n after  %v", before, after)
+	}
+	if tmp := tempFiles(t, dir); len(tmp) > 0 {
+		t.Errorf("Shutdown 之后留下临时文件: %v", tmp)
+	}
+	if len(after) != len(rooms) {
+		t.Errorf("保存了 %d 个房间文件, want %d", len(after), len(rooms))
+	}
+	if _, err := rm.JoinRoom(rooms[0].ID, "late", "迟到", newTestConn(2)); codeOf(err) != ErrServerClosing {
+		t.Errorf("关闭后加入: %v, want %s", err, ErrServerClosing)
+	}
+	for _, conn := range rm.allConns() {
+		if !conn.IsClosed() {
+			t.Error("Shutdown 之后连接没有关闭")
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// roundStake 每局每个玩家的底注：赢家拿走输家的底注，与赢家同分的玩家和无人获胜时退还底注
+const roundStake = 10
+
+// 本局结果
+const (
+	OutcomeWin  = "win"  // 获胜
+	OutcomeLoss = "loss" // 输（包括爆牌）
+	OutcomePush = "push" // 与赢家同分，退还底注
+)
+
+// PlayerStats 玩家跨局累计的统计
+type PlayerStats struct {
+	Hands        int       `json:"hands"`        // 参与结算的局数
+	Wins         int       `json:"wins"`         // 获胜
+	Losses       int       `json:"losses"`       // 输（包括爆牌）
+	Pushes       int       `json:"pushes"`       // 平局
+	Blackjacks   int       `json:"blackjacks"`   // 首两张牌21点
+	Busts        int       `json:"busts"`        // 爆牌
+	TotalValue   int       `json:"totalValue"`   // 最终点数之和（爆牌也计入）
+	AverageValue float64   `json:"averageValue"` // 平均最终点数
+	BiggestWin   int64     `json:"biggestWin"`   // 单局赢得的最多筹码
+	NetChips     int64     `json:"netChips"`     // 累计输赢的筹码
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// add 计入一局结果
+func (s *PlayerStats) add(result RoundResult, at time.Time) {
+	s.Hands++
+	switch result.Outcome {
+	case OutcomeWin:
+		s.Wins++
+	case OutcomePush:
+		s.Pushes++
+	default:
+		s.Losses++
+	}
+	if result.Blackjack {
+		s.Blackjacks++
+	}
+	if result.Score > 21 {
+		s.Busts++
+	}
+	s.TotalValue += result.Score
+	s.AverageValue = float64(s.TotalValue) / float64(s.Hands)
+	if result.Chips > s.BiggestWin {
+		s.BiggestWin = result.Chips
+	}
+	s.NetChips += result.Chips
+	s.UpdatedAt = at
+}
+
+// StatsStore 玩家统计存储接口
+type StatsStore interface {
+	// SaveStats 保存全部玩家统计（覆盖旧状态）
+	SaveStats(stats map[string]*PlayerStats) error
+	// LoadStats 加载已保存的玩家统计，没有时返回空表
+	LoadStats() (map[string]*PlayerStats, error)
+}
+
+// StatsLookup 查询玩家统计，没有记录时返回 nil（房间生成玩家列表时调用）
+type StatsLookup func(playerID string) *PlayerStats
+
+// PlayerStatsResponse 玩家统计（GET /api/player/{id}/stats）
+type PlayerStatsResponse struct {
+	PlayerID string      `json:"playerId"`
+	Nickname string      `json:"nickname"`
+	Stats    PlayerStats `json:"stats"`
+}
+
+// statsRegistry 按玩家ID记录的统计（可并发使用）
+type statsRegistry struct {
+	mu      sync.Mutex
+	players map[string]*PlayerStats
+	store   StatsStore // 为nil时不持久化
+	saver   *saver     // 设置存储后创建
+}
+
+// newStatsRegistry 创建玩家统计表
+func newStatsRegistry() *statsRegistry {
+	return &statsRegistry{players: make(map[string]*PlayerStats)}
+}
+
+// load 从存储恢复玩家统计
+func (sr *statsRegistry) load(store StatsStore) error {
+	players, err := store.LoadStats()
+	if err != nil {
+		return err
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	sr.store = store
+	sr.saver = newSaver("玩家统计", sr.flush)
+	for id, stats := range players {
+		sr.players[id] = stats
+	}
+	return nil
+}
+
+// record 计入一局的结算结果
+func (sr *statsRegistry) record(results []RoundResult, at time.Time) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	for _, result := range results {
+		stats, ok := sr.players[result.PlayerID]
+		if !ok {
+			stats = &PlayerStats{}
+			sr.players[result.PlayerID] = stats
+		}
+		stats.add(result, at)
+	}
+	sr.save()
+}
+
+// get 玩家统计的副本，没有记录时返回 nil
+func (sr *statsRegistry) get(playerID string) *PlayerStats {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	stats, ok := sr.players[playerID]
+	if !ok {
+		return nil
+	}
+	copied := *stats
+	return &copied
+}
+
+// save 标记玩家统计有改动，由后台协程保存（调用方持有锁）
+func (sr *statsRegistry) save() {
+	if sr.saver != nil {
+		sr.saver.mark()
+	}
+}
+
+// flush 保存全部玩家统计的副本（在后台保存协程中调用）
+func (sr *statsRegistry) flush() error {
+	sr.mu.Lock()
+	players := make(map[string]*PlayerStats, len(sr.players))
+	for id, stats := range sr.players {
+		copied := *stats
+		players[id] = &copied
+	}
+	sr.mu.Unlock()
+
+	return sr.store.SaveStats(players)
+}
+
+// close 写出未保存的改动并停止后台保存
+func (sr *statsRegistry) close() {
+	sr.mu.Lock()
+	saver := sr.saver
+	sr.mu.Unlock()
+	if saver != nil {
+		saver.close()
+	}
+}
+
+// UseStatsStore 设置玩家统计存储并恢复已保存的统计
+func (rm *RoomManager) UseStatsStore(store StatsStore) error {
+	return rm.stats.load(store)
+}
+
+// PlayerStats 玩家统计：有身份但还没有结算过的玩家返回空统计，都没有时返回 PLAYER_NOT_FOUND
+func (rm *RoomManager) PlayerStats(playerID string) (*PlayerStatsResponse, error) {
+	nickname := rm.accounts.nickname(playerID)
+	stats := rm.stats.get(playerID)
+	if stats == nil {
+		if nickname == "" {
+			return nil, newError(ErrPlayerNotFound)
+		}
+		stats = &PlayerStats{}
+	}
+	return &PlayerStatsResponse{PlayerID: playerID, Nickname: nickname, Stats: *stats}, nil
+}
+
+// leaderboardMinPlayers 计入排行榜的一局至少需要的结算玩家数（单人局没有对手，不计入胜场和连胜）
+const leaderboardMinPlayers = 2
+
+// settleRound 结算后更新玩家统计、排行榜和身份的筹码（在房间协程中按结算顺序调用）
+func (rm *RoomManager) settleRound(results []RoundResult, at time.Time) {
+	rm.stats.record(results, at)
+	if len(results) >= leaderboardMinPlayers {
+		rm.boards.record(results, at)
+	}
+	rm.accounts.addChips(results)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlayerStatsAdd(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		results []RoundResult
+		want    PlayerStats
+	}{
+		{
+			name:    "获胜",
+			results: []RoundResult{{Outcome: OutcomeWin, Score: 20, Chips: 10}},
+			want:    PlayerStats{Hands: 1, Wins: 1, TotalValue: 20, AverageValue: 20, BiggestWin: 10, NetChips: 10},
+		},
+		{
+			name:    "21点",
+			results: []RoundResult{{Outcome: OutcomeWin, Score: 21, Blackjack: true, Chips: 5}},
+			want:    PlayerStats{Hands: 1, Wins: 1, Blackjacks: 1, TotalValue: 21, AverageValue: 21, BiggestWin: 5, NetChips: 5},
+		},
+		{
+			name:    "爆牌算输",
+			results: []RoundResult{{Outcome: OutcomeLoss, Score: 25, Chips: -roundStake}},
+			want:    PlayerStats{Hands: 1, Losses: 1, Busts: 1, TotalValue: 25, AverageValue: 25, NetChips: -roundStake},
+		},
+		{
+			name:    "平局",
+			results: []RoundResult{{Outcome: OutcomePush, Score: 18}},
+			want:    PlayerStats{Hands: 1, Pushes: 1, TotalValue: 18, AverageValue: 18},
+		},
+		{
+			name: "累计多局",
+			results: []RoundResult{
+				{Outcome: OutcomeWin, Score: 20, Chips: 20},
+				{Outcome: OutcomeLoss, Score: 17, Chips: -roundStake},
+				{Outcome: OutcomeWin, Score: 19, Chips: 5},
+				{Outcome: OutcomePush, Score: 19},
+			},
+			want: PlayerStats{Hands: 4, Wins: 2, Losses: 1, Pushes: 1, TotalValue: 75, AverageValue: 18.75, BiggestWin: 20, NetChips: 15},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got PlayerStats
+			for _, result := range tt.results {
+				got.add(result, at)
+			}
+			tt.want.UpdatedAt = at
+			if got != tt.want {
+				t.Errorf("统计 = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsRegistry(t *testing.T) {
+	sr := newStatsRegistry()
+	at := time.Now()
+
+	if sr.get("p1") != nil {
+		t.Fatal("没有记录时应返回 nil")
+	}
+	sr.record([]RoundResult{
+		{PlayerID: "p1", Outcome: OutcomeWin, Score: 20, Chips: 10},
+		{PlayerID: "p2", Outcome: OutcomeLoss, Score: 18, Chips: -10},
+	}, at)
+
+	stats := sr.get("p1")
+	if stats == nil || stats.Wins != 1 || stats.NetChips != 10 {
+		t.Fatalf("p1 统计 = %+v", stats)
+	}
+	stats.Wins = 99 // 返回的是副本
+	if sr.get("p1").Wins != 1 {
+		t.Error("get 返回的统计与注册表共享")
+	}
+	if got := sr.get("p2"); got == nil || got.Losses != 1 {
+		t.Errorf("p2 统计 = %+v", got)
+	}
+}
+
+func TestStatsPersistence(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sr := newStatsRegistry()
+	if err := sr.load(store); err != nil {
+		t.Fatal(err)
+	}
+	sr.record([]RoundResult{{PlayerID: "p1", Outcome: OutcomeWin, Score: 21, Blackjack: true, Chips: 10}}, time.Now())
+	sr.close()
+
+	restored := newStatsRegistry()
+	if err := restored.load(store); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.close()
+	if got := restored.get("p1"); got == nil || got.Blackjacks != 1 || got.NetChips != 10 {
+		t.Errorf("恢复的统计 = %+v", got)
+	}
+}
+
+// TestSettleRound 单人局只计入玩家统计和筹码，不进排行榜
+func TestSettleRound(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   []RoundResult
+		wantBoard bool
+	}{
+		{name: "单人局", results: []RoundResult{{PlayerID: "p1", Nickname: "甲", Outcome: OutcomeWin, Score: 20}}},
+		{name: "多人局", results: []RoundResult{
+			{PlayerID: "p1", Nickname: "甲", Outcome: OutcomeWin, Score: 20, Chips: roundStake},
+			{PlayerID: "p2", Nickname: "乙", Outcome: OutcomeLoss, Score: 18, Chips: -roundStake},
+		}, wantBoard: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm := NewRoomManager(NewSeededRandomSource(1))
+			rm.settleRound(tt.results, time.Now())
+
+			if stats := rm.stats.get("p1"); stats == nil || stats.Hands != 1 {
+				t.Errorf("玩家统计 = %+v, want 1局", stats)
+			}
+			board := rm.boards.top(BoardChips, PeriodAllTime, 10)
+			if got := len(board.Entries) > 0; got != tt.wantBoard {
+				t.Errorf("排行榜有记录 = %v, want %v", got, tt.wantBoard)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RoomState 房间的持久化状态：最近一次快照加上快照之后的事件
+type RoomState struct {
+	Snapshot *RoomSnapshot `json:"snapshot"`
+	Events   []EventRecord `json:"events"`
+}
+
+// Store 房间存储接口
+type Store interface {
+	// SaveRoom 保存房间状态（覆盖旧状态）
+	SaveRoom(state *RoomState) error
+	// DeleteRoom 删除房间状态
+	DeleteRoom(roomID string) error
+	// LoadRooms 加载所有已保存的房间状态
+	LoadRooms() ([]*RoomState, error)
+}
+
+// FileStore 基于文件的存储，每个房间一个JSON文件
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore 创建文件存储，目录不存在时自动创建
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建数据目录失败: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// roomPath 房间文件路径
+func (fs *FileStore) roomPath(roomID string) string {
+	return filepath.Join(fs.dir, "room-"+roomID+".json")
+}
+
+// SaveRoom 保存房间状态
+func (fs *FileStore) SaveRoom(state *RoomState) error {
+	return writeFileAtomic(fs.roomPath(state.Snapshot.RoomID), state)
+}
+
+// DeleteRoom 删除房间文件
+func (fs *FileStore) DeleteRoom(roomID string) error {
+	err := os.Remove(fs.roomPath(roomID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// LoadRooms 读取目录下所有房间文件
+func (fs *FileStore) LoadRooms() ([]*RoomState, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*RoomState, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "room-") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(fs.dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var state RoomState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %w", name, err)
+		}
+		if state.Snapshot == nil {
+			return nil, fmt.Errorf("%s 缺少快照", name)
+		}
+		states = append(states, &state)
+	}
+
+	return states, nil
+}
+
+// accountsPath 身份文件路径
+func (fs *FileStore) accountsPath() string {
+	return filepath.Join(fs.dir, "accounts.json")
+}
+
+// SaveAccounts 保存全部身份和会话
+func (fs *FileStore) SaveAccounts(state *AccountState) error {
+	return writeFileAtomic(fs.accountsPath(), state)
+}
+
+// LoadAccounts 读取身份文件，文件不存在时返回空状态
+func (fs *FileStore) LoadAccounts() (*AccountState, error) {
+	data, err := os.ReadFile(fs.accountsPath())
+	if os.IsNotExist(err) {
+		return &AccountState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state AccountState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("解析 accounts.json 失败: %w", err)
+	}
+	return &state, nil
+}
+
+// statsPath 玩家统计文件路径
+func (fs *FileStore) statsPath() string {
+	return filepath.Join(fs.dir, "stats.json")
+}
+
+// SaveStats 保存全部玩家统计
+func (fs *FileStore) SaveStats(stats map[string]*PlayerStats) error {
+	return writeFileAtomic(fs.statsPath(), stats)
+}
+
+// LoadStats 读取玩家统计文件，文件不存在时返回空表
+func (fs *FileStore) LoadStats() (map[string]*PlayerStats, error) {
+	data, err := os.ReadFile(fs.statsPath())
+	if os.IsNotExist(err) {
+		return map[string]*PlayerStats{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stats map[string]*PlayerStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("解析 stats.json 失败: %w", err)
+	}
+	return stats, nil
+}
+
+// leaderboardsPath 排行榜文件路径
+func (fs *FileStore) leaderboardsPath() string {
+	return filepath.Join(fs.dir, "leaderboards.json")
+}
+
+// SaveLeaderboards 保存排行榜
+func (fs *FileStore) SaveLeaderboards(state *LeaderboardState) error {
+	return writeFileAtomic(fs.leaderboardsPath(), state)
+}
+
+// LoadLeaderboards 读取排行榜文件，文件不存在时返回空状态
+func (fs *FileStore) LoadLeaderboards() (*LeaderboardState, error) {
+	data, err := os.ReadFile(fs.leaderboardsPath())
+	if os.IsNotExist(err) {
+		return &LeaderboardState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state LeaderboardState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("解析 leaderboards.json 失败: %w", err)
+	}
+	return &state, nil
+}
+
+// writeFileAtomic 将 v 编码为JSON后写到同目录下的临时文件，落盘后再重命名，
+// 避免写到一半时崩溃留下损坏的文件；每次写入使用不同的临时文件，同一文件并发保存时互不覆盖。
+// 文件只允许本用户读写（身份文件中有会话令牌哈希）
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tempFiles 目录中剩下的临时文件
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{name: "新建", value: map[string]int{"a": 1}, want: `{"a":1}`},
+		{name: "覆盖", value: []string{"x"}, want: `["x"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := writeFileAtomic(path, tt.value); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("内容 = %s, want %s", data, tt.want)
+			}
+			if tmp := tempFiles(t, dir); len(tmp) != 0 {
+				t.Errorf("留下了临时文件 %v", tmp)
+			}
+		})
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		t.Errorf("文件权限 = %v, want 只允许本用户读写", perm)
+	}
+}
+
+// TestWriteFileAtomicFailure 编码失败时不创建文件，旧内容保持不变
+func TestWriteFileAtomicFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := writeFileAtomic(path, "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, func() {}); err == nil {
+		t.Fatal("无法编码的值没有返回错误")
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "state.json"), "x"); err == nil {
+		t.Fatal("目录不存在时没有返回错误")
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != `"old"` {
+		t.Errorf("内容 = %s, want 旧内容", data)
+	}
+	if tmp := tempFiles(t, dir); len(tmp) != 0 {
+		t.Errorf("留下了临时文件 %v", tmp)
+	}
+}
+
+// TestWriteFileAtomicConcurrent 同一文件并发保存时每次写入都是完整的
+func TestWriteFileAtomicConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := writeFileAtomic(path, map[string]string{"writer": strconv.Itoa(i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var v map[string]string
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &v); err != nil || v["writer"] == "" {
+		t.Errorf("内容不完整: %s (%v)", data, err)
+	}
+	if tmp := tempFiles(t, dir); len(tmp) != 0 {
+		t.Errorf("留下了临时文件 %v", tmp)
+	}
+}
+
+func TestFileStoreRooms(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := &RoomState{
+		Snapshot: &RoomSnapshot{RoomID: "ABCD", CreatedAt: time.Now().UTC(), Players: []PlayerSnapshot{}},
+		Events: []EventRecord{
+			{Seq: 1, Time: time.Now().UTC(), Event: PlayerJoined{PlayerID: "p1", Nickname: "甲"}},
+		},
+	}
+	if err := store.SaveRoom(state); err != nil {
+		t.Fatal(err)
+	}
+	// 其他文件和残留的临时文件不当作房间
+	for _, name := range []string{"accounts.json", "room-ABCD.json.123.tmp", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states, err := store.LoadRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 || states[0].Snapshot.RoomID != "ABCD" || len(states[0].Events) != 1 {
+		t.Fatalf("LoadRooms = %+v", states)
+	}
+	if e, ok := states[0].Events[0].Event.(PlayerJoined); !ok || e.Nickname != "甲" {
+		t.Errorf("事件 = %#v", states[0].Events[0].Event)
+	}
+
+	if err := store.DeleteRoom("ABCD"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteRoom("ABCD"); err != nil {
+		t.Errorf("删除不存在的房间: %v", err)
+	}
+	if states, err := store.LoadRooms(); err != nil || len(states) != 0 {
+		t.Errorf("删除后 LoadRooms = %d, %v", len(states), err)
+	}
+}
+
+func TestFileStoreLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "不是JSON", data: "{"},
+		{name: "缺少快照", data: `{"events":[]}`},
+		{name: "未知事件", data: `{"snapshot":{"roomId":"X"},"events":[{"seq":1,"type":"bogus","data":{}}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := NewFileStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "room-X.json"), []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.LoadRooms(); err == nil {
+				t.Error("没有返回错误")
+			}
+		})
+	}
+}
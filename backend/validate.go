@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError 字段级校验错误，Message 按连接的语言由 Code 和 Args 生成
+type FieldError struct {
+	Field   string        `json:"field"`
+	Code    string        `json:"code" doc:"required、minLength、maxLength、min、max、type、invalid、nicknameChars、reserved、usernameChars、maxBytes、oneOf"`
+	Message string        `json:"message"`
+	Args    []interface{} `json:"-"`
+}
+
+// Validator 需要跨字段校验的请求实现此接口，在标签校验通过后调用
+type Validator interface {
+	Validate() []FieldError
+}
+
+// validationError 携带字段错误的请求校验失败
+type validationError struct {
+	Fields []FieldError
+}
+
+func (e *validationError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range localizeFields(e.Fields, DefaultLocale) {
+		parts = append(parts, f.Field+": "+f.Message)
+	}
+	return "请求参数错误（" + strings.Join(parts, "; ") + "）"
+}
+
+// decodeRequest 解析消息数据并按 validate 标签校验
+// 支持的规则：required（非零值）、min=N、max=N（字符串按字符数，切片按长度，数字按数值）；
+// 嵌套结构体的字段同样按标签校验，错误路径如 items[0].name
+func decodeRequest(data json.RawMessage, req interface{}) error {
+	if len(data) == 0 {
+		data = json.RawMessage("{}")
+	}
+
+	if err := json.Unmarshal(data, req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return &validationError{Fields: []FieldError{{
+				Field: typeErr.Field,
+				Code:  "type",
+				Args:  []interface{}{jsonTypeName(typeErr.Type)},
+			}}}
+		}
+		return &validationError{Fields: []FieldError{{Field: "data", Code: "invalid"}}}
+	}
+
+	fields := validateStruct(reflect.ValueOf(req), "")
+	if len(fields) == 0 {
+		if v, ok := req.(Validator); ok {
+			fields = v.Validate()
+		}
+	}
+	if len(fields) > 0 {
+		return &validationError{Fields: fields}
+	}
+	return nil
+}
+
+// validateStruct 校验结构体字段，并递归校验嵌套的结构体、指针和切片元素；prefix 为外层字段路径
+func validateStruct(v reflect.Value, prefix string) []FieldError {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []FieldError
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := jsonFieldName(sf)
+		if name == "" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		fv := v.Field(i)
+		if fe, ok := checkRules(fv, sf.Tag.Get("validate"), path); !ok {
+			fields = append(fields, fe)
+			continue
+		}
+		fields = append(fields, validateNested(fv, path)...)
+	}
+	return fields
+}
+
+// checkRules 依次检查字段的规则，遇到第一条不满足的规则时返回对应的错误
+func checkRules(v reflect.Value, tag, path string) (FieldError, bool) {
+	for _, rule := range strings.Split(tag, ",") {
+		if code, args := checkRule(v, rule); code != "" {
+			return FieldError{Field: path, Code: code, Args: args}, false
+		}
+	}
+	return FieldError{}, true
+}
+
+// validateNested 校验嵌套的结构体和结构体切片，切片元素的路径为 path[i]
+func validateNested(v reflect.Value, path string) []FieldError {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return validateStruct(v, path)
+	case reflect.Slice, reflect.Array:
+		var fields []FieldError
+		for i := 0; i < v.Len(); i++ {
+			fields = append(fields, validateNested(v.Index(i), path+"["+strconv.Itoa(i)+"]")...)
+		}
+		return fields
+	}
+	return nil
+}
+
+// checkRule 检查单条规则，不满足时返回错误码和参数
+func checkRule(v reflect.Value, rule string) (string, []interface{}) {
+	key, arg, _ := strings.Cut(rule, "=")
+	switch key {
+	case "required":
+		if v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+			return "required", nil
+		}
+
+	case "min", "max":
+		// 可选字段用指针表示，没有提供时不检查范围
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return "", nil
+			}
+			v = v.Elem()
+		}
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return "", nil
+		}
+
+		n, isLen := measure(v)
+		if (key == "min" && n >= limit) || (key == "max" && n <= limit) {
+			return "", nil
+		}
+		if isLen {
+			return key + "Length", []interface{}{arg}
+		}
+		return key, []interface{}{arg}
+	}
+	return "", nil
+}
+
+// measure 取字段用于 min/max 比较的量，isLen 表示比较的是长度
+func measure(v reflect.Value) (n float64, isLen bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Map:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	}
+	return 0, false
+}
+
+// jsonFieldName 获取结构体字段的JSON名，不参与序列化的字段返回空字符串
+func jsonFieldName(sf reflect.StructField) string {
+	if !sf.IsExported() {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return sf.Name
+	}
+	return name
+}
+
+// jsonTypeName Go 类型对应的 JSON 类型名（用于错误提示）
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
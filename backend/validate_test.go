@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fieldCodes 校验错误中的 字段:错误码 列表，没有错误时为空
+func fieldCodes(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+	var verr *validationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("错误类型 %T，want *validationError: %v", err, err)
+	}
+	codes := make([]string, 0, len(verr.Fields))
+	for _, f := range verr.Fields {
+		codes = append(codes, f.Field+":"+f.Code)
+	}
+	return codes
+}
+
+func TestDecodeRequest(t *testing.T) {
+	long := func(n int) string { return strings.Repeat("字", n) }
+
+	tests := []struct {
+		name string
+		data string
+		req  interface{}
+		want []string
+	}{
+		{name: "合法", data: `{"roomId":"ABCD","playerId":"p1"}`, req: &RoomActionRequest{}},
+		{name: "空数据按空对象校验", data: ``, req: &RoomActionRequest{}, want: []string{"roomId:required", "playerId:required"}},
+		{name: "不是对象", data: `[1]`, req: &RoomActionRequest{}, want: []string{"data:invalid"}},
+		{name: "字段类型错误", data: `{"roomId":1,"playerId":"p1"}`, req: &RoomActionRequest{}, want: []string{"roomId:type"}},
+		{name: "按字符数计算长度", data: `{"roomId":"` + long(16) + `","playerId":"p1"}`, req: &RoomActionRequest{}},
+		{name: "超过最大长度", data: `{"roomId":"` + long(17) + `","playerId":"p1"}`, req: &RoomActionRequest{}, want: []string{"roomId:maxLength"}},
+		{name: "数字最小值", data: `{"roomId":"ABCD","round":0}`, req: &ReplayRequest{}, want: []string{"round:required"}},
+		{name: "数字最大值", data: `{"roomId":"ABCD","round":1,"speed":65}`, req: &ReplayRequest{}, want: []string{"speed:max"}},
+		{name: "可选字段未提供时不检查范围", data: `{"roomId":"ABCD","round":1}`, req: &ReplayRequest{}},
+		{name: "可选数字最小值", data: `{"roomId":"ABCD","round":1,"speed":0}`, req: &ReplayRequest{}, want: []string{"speed:min"}},
+		{name: "可选数字为负数", data: `{"roomId":"ABCD","round":1,"speed":-1}`, req: &ReplayRequest{}, want: []string{"speed:min"}},
+		{name: "空切片", data: `{"versions":[]}`, req: &HelloRequest{}, want: []string{"versions:required"}},
+		{name: "切片长度", data: `{"versions":[` + strings.Repeat("1,", 16) + `1]}`, req: &HelloRequest{}, want: []string{"versions:maxLength"}},
+		{name: "标签通过后跨字段校验", data: `{"username":"a b c","password":"12345678","avatar":"cat"}`, req: &RegisterRequest{}, want: []string{"username:usernameChars", "avatar:oneOf"}},
+		{name: "标签失败时不做跨字段校验", data: `{"username":"a b","password":"1"}`, req: &RegisterRequest{}, want: []string{"password:minLength"}},
+		{name: "密码按字节限制", data: `{"username":"abc","password":"` + long(30) + `"}`, req: &RegisterRequest{}, want: []string{"password:maxBytes"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeRequest(json.RawMessage(tt.data), tt.req)
+			if got := fieldCodes(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("错误 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 嵌套请求，用于测试递归校验
+type nestedItem struct {
+	Name  string `json:"name" validate:"required,max=4"`
+	Count int    `json:"count" validate:"min=1"`
+}
+
+type nestedRequest struct {
+	Title  string       `json:"title" validate:"required"`
+	Main   nestedItem   `json:"main"`
+	Extra  *nestedItem  `json:"extra,omitempty"`
+	Items  []nestedItem `json:"items" validate:"max=3"`
+	Ptrs   []*nestedItem
+	Hidden nestedItem `json:"-"`
+}
+
+func TestDecodeRequestNested(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "合法",
+			data: `{"title":"t","main":{"name":"a","count":1},"items":[{"name":"b","count":2}]}`,
+		},
+		{
+			name: "嵌套结构体",
+			data: `{"title":"t","main":{"name":"toolong","count":0}}`,
+			want: []string{"main.name:maxLength", "main.count:min"},
+		},
+		{
+			name: "指针为空时不校验",
+			data: `{"title":"t","main":{"name":"a","count":1},"extra":null}`,
+		},
+		{
+			name: "指针",
+			data: `{"title":"t","main":{"name":"a","count":1},"extra":{"count":1}}`,
+			want: []string{"extra.name:required"},
+		},
+		{
+			name: "切片元素",
+			data: `{"title":"t","main":{"name":"a","count":1},"items":[{"name":"ok","count":1},{"name":"","count":1},{"name":"x"}]}`,
+			want: []string{"items[1].name:required", "items[2].count:min"},
+		},
+		{
+			name: "切片本身不合法时不校验元素",
+			data: `{"title":"t","main":{"name":"a","count":1},"items":[{},{},{},{}]}`,
+			want: []string{"items:maxLength"},
+		},
+		{
+			name: "没有json标签的字段用字段名",
+			data: `{"title":"t","main":{"name":"a","count":1},"Ptrs":[null,{"name":"a"}]}`,
+			want: []string{"Ptrs[1].count:min"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeRequest(json.RawMessage(tt.data), &nestedRequest{})
+			if got := fieldCodes(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("错误 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalizeFields(t *testing.T) {
+	fields := []FieldError{{Field: "roomId", Code: "maxLength", Args: []interface{}{"16"}}}
+
+	tests := []struct {
+		locale Locale
+		want   string
+	}{
+		{LocaleZH, "长度不能超过16"},
+		{LocaleEN, "must be at most 16 characters"},
+	}
+	for _, tt := range tests {
+		got := localizeFields(fields, tt.locale)
+		if len(got) != 1 || got[0].Message != tt.want {
+			t.Errorf("%s: %+v, want %q", tt.locale, got, tt.want)
+		}
+	}
+
+	err := &validationError{Fields: fields}
+	if !strings.Contains(err.Error(), "roomId: 长度不能超过16") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+}
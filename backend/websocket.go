@@ -1,21 +1,36 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	writeWait      = 10 * time.Second    // 单次写入的超时时间
+	pongWait       = 60 * time.Second    // 等待对端pong的最长时间
+	pingPeriod     = (pongWait * 9) / 10 // ping间隔，必须小于pongWait
+	maxMessageSize = 8 * 1024            // 客户端单条消息的最大字节数
+	sendBufferSize = 256                 // 每个连接的发送缓冲区大小
+
+	maxRequestIDLength = 64 // 客户端请求ID的最大长度
+)
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    subprotocols(), // 消息编码，见 codec.go
 	CheckOrigin: func(r *http.Request) bool {
-		return true // 允许所有来源（生产环境需要限制）
+		return true // 来源已在升级前由 admission 检查
 	},
 }
 
@@ -23,144 +38,476 @@ var upgrader = websocket.Upgrader{
 type MessageType string
 
 const (
-	TypeConnect    MessageType = "connect"
-	TypeJoin       MessageType = "join"
-	TypeLeave      MessageType = "leave"
-	TypeStart      MessageType = "start"
-	TypeHit        MessageType = "hit"
-	TypeStand      MessageType = "stand"
-	TypeChat       MessageType = "chat"
-	TypeUpdate     MessageType = "update"
-	TypeError      MessageType = "error"
-	TypeRoomInfo   MessageType = "roomInfo"
-	TypePlayers    MessageType = "players"
-	TypeGameEnd    MessageType = "gameEnd"
+	TypeConnect       MessageType = "connect"
+	TypeJoin          MessageType = "join"
+	TypeLeave         MessageType = "leave"
+	TypeStart         MessageType = "start"
+	TypeHit           MessageType = "hit"
+	TypeStand         MessageType = "stand"
+	TypeChat          MessageType = "chat"
+	TypeError         MessageType = "error"
+	TypeRoomInfo      MessageType = "roomInfo"
+	TypePlayers       MessageType = "players"
+	TypeGameEnd       MessageType = "gameEnd"
+	TypeReplay        MessageType = "replay"
+	TypeShutdown      MessageType = "serverShutdown"
+	TypeHello         MessageType = "hello"
+	TypeAck           MessageType = "ack"
+	TypeResync        MessageType = "resync"
+	TypeSnapshot      MessageType = "snapshot"
+	TypeCardDealt     MessageType = "cardDealt"
+	TypeStatusChanged MessageType = "statusChanged"
+	TypeChatHistory   MessageType = "chatHistory"
+	TypeEmote         MessageType = "emote"
 )
 
-// Message WebSocket消息
+// Message WebSocket消息，各类型 data 的结构见 protocol.go
 type Message struct {
-	Type    MessageType      `json:"type"`
-	Data    json.RawMessage   `json:"data,omitempty"`
-	Error   string           `json:"error,omitempty"`
+	Type      MessageType     `json:"type"`
+	RequestID string          `json:"requestId,omitempty"` // 客户端请求ID，ack 和错误回复时原样带回
+	Version   uint64          `json:"version,omitempty"`   // 房间状态版本，只在房间广播消息上
+	Data      json.RawMessage `json:"data,omitempty"`
+	Code      ErrorCode       `json:"code,omitempty"`   // 错误码
+	Error     string          `json:"error,omitempty"`  // 按连接语言生成的错误描述
+	Fields    []FieldError    `json:"fields,omitempty"` // 请求校验失败的字段
 }
 
 // WebSocketConn WebSocket连接
+//
+// 生命周期：NewWebSocketConn 创建后必须启动 WritePump 和 ReadPump。
+// WritePump 是唯一向底层连接写数据的协程（消息、ping、关闭帧）；
+// Close/CloseWithReason 只取消 ctx，由 WritePump 发送关闭帧并关闭底层连接，
+// ReadPump 随之读取失败退出。send 通道从不关闭，Send 在关闭后直接丢弃消息。
 type WebSocketConn struct {
-	conn   *websocket.Conn
-	send   chan Message
-	mu     sync.Mutex
-	closed bool
+	conn      *websocket.Conn
+	send      chan Message
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	closeMsg  []byte // 关闭帧内容，在 cancel 之前写入
+	mu        sync.Mutex
+	account   string // 通过 connect 验证身份的玩家
+	playerID  string // 通过 join 绑定的玩家
+	roomID    string
+	version   int         // 协商出的协议版本
+	locale    Locale      // 错误描述的语言
+	codec     Codec       // 握手时按子协议选定的消息编码
+	flood     *floodGuard // 消息限流状态（只在读取协程中使用）
+	replaying atomic.Bool // 是否有进行中的回放
 }
 
-// NewWebSocketConn 创建新连接
+// NewWebSocketConn 创建新连接，消息编码由握手协商出的子协议决定
 func NewWebSocketConn(conn *websocket.Conn) *WebSocketConn {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &WebSocketConn{
-		conn:   conn,
-		send:   make(chan Message, 256),
-		closed: false,
+		conn:    conn,
+		send:    make(chan Message, sendBufferSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		version: ProtocolVersion,
+		locale:  DefaultLocale,
+		codec:   codecFor(conn.Subprotocol()),
+		flood:   newFloodGuard(time.Now()),
 	}
 }
 
-// Send 发送消息
+// Send 发送消息（不阻塞）
+// 慢消费者策略：发送缓冲区满说明客户端长期读不动，直接以 1008 关闭连接，
+// 避免拖慢房间广播；玩家保留座位，重连后可恢复。
 func (wsc *WebSocketConn) Send(msg Message) {
-	wsc.mu.Lock()
-	defer wsc.mu.Unlock()
-
-	if wsc.closed {
+	select {
+	case <-wsc.ctx.Done():
 		return
+	default:
 	}
 
 	select {
 	case wsc.send <- msg:
 	default:
-		// 发送缓冲区满，关闭连接
-		wsc.Close()
+		log.Printf("发送缓冲区已满，断开慢速连接")
+		wsc.CloseWithReason(websocket.ClosePolicyViolation, "消息积压过多")
 	}
 }
 
-// Close 关闭连接
+// Close 关闭连接（可重复调用）
 func (wsc *WebSocketConn) Close() {
+	wsc.CloseWithReason(websocket.CloseNormalClosure, "")
+}
+
+// CloseWithReason 以指定关闭码关闭连接，只有第一次调用生效
+func (wsc *WebSocketConn) CloseWithReason(code int, reason string) {
+	wsc.closeOnce.Do(func() {
+		wsc.closeMsg = websocket.FormatCloseMessage(code, reason)
+		wsc.cancel()
+	})
+}
+
+// Context 连接关闭时取消的上下文
+func (wsc *WebSocketConn) Context() context.Context {
+	return wsc.ctx
+}
+
+// bind 绑定连接所属的玩家和房间
+func (wsc *WebSocketConn) bind(playerID, roomID string) {
 	wsc.mu.Lock()
 	defer wsc.mu.Unlock()
 
-	if wsc.closed {
-		return
-	}
+	wsc.playerID = playerID
+	wsc.roomID = roomID
+}
+
+// authenticate 记录连接通过 connect 验证的玩家身份
+func (wsc *WebSocketConn) authenticate(playerID string) {
+	wsc.mu.Lock()
+	defer wsc.mu.Unlock()
 
-	wsc.closed = true
-	close(wsc.send)
-	wsc.conn.Close()
+	wsc.account = playerID
 }
 
-// IsClosed 检查连接是否已关闭
-func (wsc *WebSocketConn) IsClosed() bool {
+// authenticated 连接验证过的玩家身份，未验证时为空
+func (wsc *WebSocketConn) authenticated() string {
+	wsc.mu.Lock()
+	defer wsc.mu.Unlock()
+
+	return wsc.account
+}
+
+// identity 获取连接绑定的玩家和房间
+func (wsc *WebSocketConn) identity() (playerID, roomID string) {
+	wsc.mu.Lock()
+	defer wsc.mu.Unlock()
+
+	return wsc.playerID, wsc.roomID
+}
+
+// setVersion 记录握手协商出的协议版本
+func (wsc *WebSocketConn) setVersion(version int) {
+	wsc.mu.Lock()
+	defer wsc.mu.Unlock()
+
+	wsc.version = version
+}
+
+// Version 连接使用的协议版本
+func (wsc *WebSocketConn) Version() int {
+	wsc.mu.Lock()
+	defer wsc.mu.Unlock()
+
+	return wsc.version
+}
+
+// setLocale 设置错误描述的语言
+func (wsc *WebSocketConn) setLocale(locale Locale) {
 	wsc.mu.Lock()
 	defer wsc.mu.Unlock()
 
-	return wsc.closed
+	wsc.locale = locale
 }
 
-// WritePump 写入协程
+// Locale 连接使用的语言
+func (wsc *WebSocketConn) Locale() Locale {
+	wsc.mu.Lock()
+	defer wsc.mu.Unlock()
+
+	return wsc.locale
+}
+
+// IsClosed 检查连接是否已关闭
+func (wsc *WebSocketConn) IsClosed() bool {
+	return wsc.ctx.Err() != nil
+}
+
+// WritePump 写入协程，定期发送ping保持心跳；退出时关闭底层连接
 func (wsc *WebSocketConn) WritePump() {
-	defer wsc.Close()
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		wsc.Close()
+		wsc.conn.Close()
+	}()
 
 	for {
 		select {
-		case msg, ok := <-wsc.send:
-			if !ok {
+		case <-wsc.ctx.Done():
+			wsc.flush()
+			wsc.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			wsc.conn.WriteMessage(websocket.CloseMessage, wsc.closeMsg)
+			return
+
+		case msg := <-wsc.send:
+			if err := wsc.write(msg); err != nil {
+				log.Printf("写入错误: %v", err)
 				return
 			}
 
-			if err := wsc.conn.WriteJSON(msg); err != nil {
-				log.Printf("写入错误: %v", err)
+		case <-ticker.C:
+			wsc.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := wsc.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		}
 	}
 }
 
-// ReadPump 读取协程
+// write 按连接的编码写入一条消息，编码失败的消息丢弃
+func (wsc *WebSocketConn) write(msg Message) error {
+	data, err := wsc.codec.Encode(msg)
+	if err != nil {
+		log.Printf("消息编码失败（%s）: %v", msg.Type, err)
+		return nil
+	}
+
+	wsc.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return wsc.conn.WriteMessage(wsc.codec.FrameType(), data)
+}
+
+// flush 关闭前尽量发出已排队的消息
+func (wsc *WebSocketConn) flush() {
+	for {
+		select {
+		case msg := <-wsc.send:
+			if err := wsc.write(msg); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// ReadPump 读取协程，超过 pongWait 未收到任何数据（包括pong）即判定连接已断开
 func (wsc *WebSocketConn) ReadPump(handler func(msg Message)) {
 	defer wsc.Close()
 
+	wsc.conn.SetReadLimit(maxMessageSize)
+	wsc.conn.SetReadDeadline(time.Now().Add(pongWait))
+	wsc.conn.SetPongHandler(func(string) error {
+		return wsc.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	for {
-		var msg Message
-		if err := wsc.conn.ReadJSON(&msg); err != nil {
+		_, data, err := wsc.conn.ReadMessage()
+		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("读取错误: %v", err)
 			}
 			return
 		}
 
+		msg, err := wsc.codec.Decode(data)
+		if err != nil {
+			log.Printf("消息解码失败（%s）: %v", wsc.codec.Subprotocol(), err)
+			wsc.CloseWithReason(websocket.CloseInvalidFramePayloadData, "无法解析的消息")
+			return
+		}
+
+		// 连接已被关闭（如握手失败、持续超限）时不再处理缓冲中的消息
+		if wsc.IsClosed() {
+			return
+		}
+
+		wsc.conn.SetReadDeadline(time.Now().Add(pongWait))
 		handler(msg)
 	}
 }
 
+// maxConcurrentReplays 服务器同时进行的回放数量上限
+const maxConcurrentReplays = 16
+
 // RoomManager 房间管理器
 type RoomManager struct {
-	rooms   map[string]*Room
-	players map[string]*Player // 按玩家ID索引
-	mu      sync.RWMutex
+	rooms       map[string]*Room
+	players     map[string]*Player // 按玩家ID索引
+	rng         RandomSource
+	store       Store // 为nil时不持久化
+	conns       map[*WebSocketConn]struct{}
+	admission   *admission         // 连接准入检查
+	floods      floodCounters      // 消息限流计数
+	chatFilters moderationPipeline // 内置的聊天清理和过滤
+	chatHooks   moderationPipeline // 外部接入的聊天审核
+	moderation  moderationCounters // 聊天审核计数
+	accounts    *accountRegistry   // 服务器签发的玩家身份
+	stats       *statsRegistry     // 玩家跨局统计
+	boards      *leaderboards      // 日榜、周榜和总榜
+	replays     chan struct{}      // 进行中的回放，容量为同时回放的上限
+	closing     atomic.Bool        // 正在关闭，不再接受新房间和加入
+	mu          sync.RWMutex
 }
 
 // NewRoomManager 创建房间管理器
-func NewRoomManager() *RoomManager {
+func NewRoomManager(rng RandomSource) *RoomManager {
 	return &RoomManager{
-		rooms:   make(map[string]*Room),
-		players: make(map[string]*Player),
+		rooms:       make(map[string]*Room),
+		players:     make(map[string]*Player),
+		rng:         rng,
+		conns:       make(map[*WebSocketConn]struct{}),
+		admission:   newAdmission(defaultConnLimits()),
+		chatFilters: newModerationPipeline(defaultModerationConfig()),
+		accounts:    newAccountRegistry(),
+		stats:       newStatsRegistry(),
+		boards:      newLeaderboards(),
+		replays:     make(chan struct{}, maxConcurrentReplays),
+	}
+}
+
+// SetConnLimits 设置连接准入配置（在开始接受连接之前调用）
+func (rm *RoomManager) SetConnLimits(limits ConnLimits) {
+	rm.admission = newAdmission(limits)
+}
+
+// ConnStats 获取连接准入统计
+func (rm *RoomManager) ConnStats() ConnStats {
+	return rm.admission.stats()
+}
+
+// UseAccountStore 使用身份存储并恢复已签发的身份，返回恢复的数量
+func (rm *RoomManager) UseAccountStore(store AccountStore) (int, error) {
+	return rm.accounts.load(store)
+}
+
+// CreateGuest 签发游客身份，按客户端IP限速
+func (rm *RoomManager) CreateGuest(r *http.Request, nickname string) (*Account, string, error) {
+	if !rm.accounts.mint.allow(rm.admission.clientIP(r), time.Now()) {
+		return nil, "", newError(ErrRateLimited)
+	}
+	return rm.accounts.createGuest(rm.rng, nickname)
+}
+
+// Authenticate 校验玩家ID和会话令牌（HTTP API 使用）
+func (rm *RoomManager) Authenticate(playerID, token string) error {
+	_, err := rm.accounts.authenticate(playerID, token)
+	return err
+}
+
+// Register 注册账号，guestToken 不为空时升级该游客；和登录共用按IP的限速
+func (rm *RoomManager) Register(r *http.Request, guestToken string, req RegisterRequest, nickname string) (*Account, string, error) {
+	if !rm.accounts.auth.allow(rm.admission.clientIP(r), time.Now()) {
+		return nil, "", newError(ErrRateLimited)
+	}
+	return rm.accounts.register(rm.rng, guestToken, req, nickname)
+}
+
+// Login 用户名密码登录，返回账号和新的会话令牌
+func (rm *RoomManager) Login(r *http.Request, username, password string) (*Account, string, error) {
+	if !rm.accounts.auth.allow(rm.admission.clientIP(r), time.Now()) {
+		return nil, "", newError(ErrRateLimited)
+	}
+	return rm.accounts.login(username, password)
+}
+
+// Logout 注销会话令牌
+func (rm *RoomManager) Logout(token string) error {
+	return rm.accounts.logout(token)
+}
+
+// Profile 会话令牌对应的玩家资料
+func (rm *RoomManager) Profile(token string) (*Account, error) {
+	return rm.accounts.session(token)
+}
+
+// UpdateProfile 修改会话令牌对应玩家的昵称和头像
+func (rm *RoomManager) UpdateProfile(token, nickname, avatar string) (*Account, error) {
+	account, err := rm.accounts.session(token)
+	if err != nil {
+		return nil, err
+	}
+	return rm.accounts.updateProfile(account.PlayerID, nickname, avatar)
+}
+
+// authorize 检查请求中的玩家就是连接通过 connect 验证的身份
+func (rm *RoomManager) authorize(wsConn *WebSocketConn, playerID string) error {
+	switch wsConn.authenticated() {
+	case "":
+		return newError(ErrNotAuthenticated)
+	case playerID:
+		return nil
+	default:
+		return newError(ErrForbidden)
 	}
 }
 
 // CreateRoom 创建房间
-func (rm *RoomManager) CreateRoom() *Room {
+func (rm *RoomManager) CreateRoom() (*Room, error) {
+	if rm.closing.Load() {
+		return nil, newError(ErrServerClosing)
+	}
+
+	// 新房间的协程还没有其他命令，设置监听器不会长时间持有全局锁；保存在释放锁之后进行
+	rm.mu.Lock()
+	roomID := generateRoomID(rm.rng)
+	for rm.rooms[roomID] != nil {
+		roomID = generateRoomID(rm.rng)
+	}
+	room := NewRoom(roomID, rm.rng)
+	rm.watchRoom(room)
+	rm.rooms[roomID] = room
+	rm.mu.Unlock()
+
+	rm.persistRoom(room)
+	return room, nil
+}
+
+// UseStore 设置存储并恢复已保存的房间，返回恢复的房间数量
+func (rm *RoomManager) UseStore(store Store) (int, error) {
+	states, err := store.LoadRooms()
+	if err != nil {
+		return 0, err
+	}
+
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
-	roomID := generateRoomID()
-	room := NewRoom(roomID)
-	rm.rooms[roomID] = room
+	rm.store = store
+	for _, state := range states {
+		room := RestoreRoom(state.Snapshot, state.Events, rm.rng)
+		rm.watchRoom(room)
+		rm.rooms[room.ID] = room
+
+		// 恢复玩家索引（房间内玩家的副本），玩家重新连接后通过 join 挂回连接
+		for _, player := range room.PlayerList() {
+			rm.players[player.ID] = player
+		}
+	}
+
+	return len(states), nil
+}
+
+// watchRoom 监听房间事件（保存状态、结算统计），玩家列表带上玩家统计
+func (rm *RoomManager) watchRoom(room *Room) {
+	room.SetListener(rm.onRoomEvents)
+	room.SetStatsLookup(rm.stats.get)
+}
+
+// onRoomEvents 房间事件监听器：保存房间状态，结算时更新玩家统计和筹码（在房间协程中调用）
+func (rm *RoomManager) onRoomEvents(events []EventRecord, state *RoomState) {
+	rm.saveRoomState(events, state)
+	for _, rec := range events {
+		if e, ok := rec.Event.(RoundSettled); ok {
+			rm.settleRound(e.Results, rec.Time)
+		}
+	}
+}
+
+// persistRoom 保存房间状态：和事件监听器一样在房间协程中保存，同一房间的保存按顺序进行
+func (rm *RoomManager) persistRoom(room *Room) {
+	if rm.store == nil {
+		return
+	}
+
+	room.do(func() {
+		rm.saveRoomState(nil, room.state())
+	})
+}
+
+// saveRoomState 保存房间状态，作为房间事件监听器时在房间协程中按事件顺序调用
+func (rm *RoomManager) saveRoomState(_ []EventRecord, state *RoomState) {
+	if rm.store == nil || state == nil {
+		return
+	}
 
-	return room
+	if err := rm.store.SaveRoom(state); err != nil {
+		log.Printf("保存房间 %s 失败: %v", state.Snapshot.RoomID, err)
+	}
 }
 
 // GetRoom 获取房间
@@ -172,37 +519,54 @@ func (rm *RoomManager) GetRoom(roomID string) *Room {
 }
 
 // JoinRoom 加入房间
-func (rm *RoomManager) JoinRoom(roomID, playerID, nickname string) (*Room, error) {
-	rm.mu.Lock()
-	defer rm.mu.Unlock()
+func (rm *RoomManager) JoinRoom(roomID, playerID, nickname string, conn *WebSocketConn) (*Room, error) {
+	if rm.closing.Load() {
+		return nil, newError(ErrServerClosing)
+	}
 
-	room, exists := rm.rooms[roomID]
-	if !exists {
-		return nil, fmt.Errorf("房间不存在")
+	// 只在查找房间时持有全局锁，等待房间协程时不阻塞其他房间
+	room := rm.GetRoom(roomID)
+	if room == nil {
+		return nil, newError(ErrRoomNotFound)
 	}
 
-	player := NewPlayer(playerID, nickname)
-	if !room.AddPlayer(player) {
-		return nil, fmt.Errorf("无法加入房间（玩家已存在或房间已满）")
+	if err := room.AddPlayer(playerID, nickname, conn); err != nil {
+		return nil, err
 	}
+	player := room.GetPlayer(playerID)
 
+	rm.mu.Lock()
 	rm.players[playerID] = player
+	rm.mu.Unlock()
 	return room, nil
 }
 
 // LeaveRoom 离开房间
 func (rm *RoomManager) LeaveRoom(roomID, playerID string) {
+	room := rm.GetRoom(roomID)
+	if room == nil {
+		return
+	}
+
+	room.RemovePlayer(playerID)
 	rm.mu.Lock()
-	defer rm.mu.Unlock()
+	delete(rm.players, playerID)
+	rm.mu.Unlock()
 
-	room, exists := rm.rooms[roomID]
-	if exists {
-		room.RemovePlayer(playerID)
-		delete(rm.players, playerID)
+	// 如果房间空了，删除房间并停止房间协程；退役后的房间不再接受加入，不会删掉刚加入的玩家
+	if !room.retireIfEmpty() {
+		return
+	}
+	rm.mu.Lock()
+	if rm.rooms[roomID] == room {
+		delete(rm.rooms, roomID)
+	}
+	rm.mu.Unlock()
 
-		// 如果房间空了，删除房间
-		if room.PlayerCount() == 0 {
-			delete(rm.rooms, roomID)
+	room.Stop()
+	if rm.store != nil {
+		if err := rm.store.DeleteRoom(roomID); err != nil {
+			log.Printf("删除房间 %s 失败: %v", roomID, err)
 		}
 	}
 }
@@ -217,6 +581,14 @@ func (rm *RoomManager) GetPlayer(playerID string) *Player {
 
 // HandleWebSocket 处理WebSocket连接
 func (rm *RoomManager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// 升级之前检查来源、连接数和新建连接速率
+	release, err := rm.admission.admit(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer release()
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket升级失败: %v", err)
@@ -224,6 +596,16 @@ func (rm *RoomManager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	wsConn := NewWebSocketConn(conn)
+	wsConn.setLocale(requestLocale(r))
+	rm.mu.Lock()
+	rm.conns[wsConn] = struct{}{}
+	rm.mu.Unlock()
+
+	defer func() {
+		rm.mu.Lock()
+		delete(rm.conns, wsConn)
+		rm.mu.Unlock()
+	}()
 
 	// 启动写入协程
 	go wsConn.WritePump()
@@ -232,337 +614,554 @@ func (rm *RoomManager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	wsConn.ReadPump(func(msg Message) {
 		rm.handleMessage(wsConn, msg)
 	})
+
+	// 连接已断开（对端关闭或心跳超时）
+	rm.handleDisconnect(wsConn)
+}
+
+// handleDisconnect 处理连接断开：解除玩家与连接的绑定并通知房间
+// 玩家保留座位，重新 join 后即可恢复
+func (rm *RoomManager) handleDisconnect(wsConn *WebSocketConn) {
+	playerID, roomID := wsConn.identity()
+	if playerID == "" || roomID == "" {
+		rm.forgetLobbyPlayer(wsConn)
+		return
+	}
+
+	room := rm.GetRoom(roomID)
+	if room == nil {
+		return
+	}
+
+	room.DetachConn(playerID, wsConn)
+}
+
+// forgetLobbyPlayer 没有加入房间的玩家的最后一个连接断开时，从大厅的玩家索引中删除
+func (rm *RoomManager) forgetLobbyPlayer(wsConn *WebSocketConn) {
+	playerID := wsConn.authenticated()
+	if playerID == "" {
+		return
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	for conn := range rm.conns {
+		if conn != wsConn && conn.authenticated() == playerID {
+			return
+		}
+	}
+	if player := rm.players[playerID]; player != nil && player.RoomID == "" {
+		delete(rm.players, playerID)
+	}
 }
 
-// handleMessage 处理收到的消息
+// handleMessage 处理收到的消息：处理失败回复错误，带 requestId 的请求处理成功后回复 ack
 func (rm *RoomManager) handleMessage(wsConn *WebSocketConn, msg Message) {
+	if !rm.admitMessage(wsConn, msg) {
+		return
+	}
+
+	// requestId 会随操作事件持久化，限制长度
+	if utf8.RuneCountInString(msg.RequestID) > maxRequestIDLength {
+		rm.sendError(wsConn, Message{}, &validationError{Fields: []FieldError{{
+			Field: "requestId",
+			Code:  "maxLength",
+			Args:  []interface{}{maxRequestIDLength},
+		}}})
+		return
+	}
+
+	var err error
 	switch msg.Type {
+	case TypeHello:
+		err = rm.handleHello(wsConn, msg)
 	case TypeConnect:
-		rm.handleConnect(wsConn, msg)
+		err = rm.handleConnect(wsConn, msg)
 	case TypeJoin:
-		rm.handleJoin(wsConn, msg)
+		err = rm.handleJoin(wsConn, msg)
 	case TypeStart:
-		rm.handleStart(wsConn, msg)
+		err = rm.handleStart(wsConn, msg)
 	case TypeHit:
-		rm.handleHit(wsConn, msg)
+		err = rm.handleHit(wsConn, msg)
 	case TypeStand:
-		rm.handleStand(wsConn, msg)
+		err = rm.handleStand(wsConn, msg)
+	case TypeLeave:
+		err = rm.handleLeave(wsConn, msg)
 	case TypeChat:
-		rm.handleChat(wsConn, msg)
+		err = rm.handleChat(wsConn, msg)
+	case TypeEmote:
+		err = rm.handleEmote(wsConn, msg)
+	case TypeReplay:
+		err = rm.handleReplay(wsConn, msg)
+	case TypeResync:
+		err = rm.handleResync(wsConn, msg)
 	default:
+		err = newError(ErrUnknownMessageType, msg.Type)
+	}
+
+	duplicate := err == errDuplicateRequest
+	if err != nil && !duplicate {
+		rm.sendError(wsConn, msg, err)
+		return
+	}
+
+	if msg.RequestID != "" {
 		wsConn.Send(Message{
-			Type:  TypeError,
-			Error: "未知消息类型",
+			Type:      TypeAck,
+			RequestID: msg.RequestID,
+			Data: toJSON(AckResponse{
+				Type:      msg.Type,
+				Duplicate: duplicate,
+			}),
 		})
 	}
 }
 
+// sendError 向客户端发送错误，描述按连接的语言生成，并带上原请求的 requestId
+func (rm *RoomManager) sendError(wsConn *WebSocketConn, msg Message, err error) {
+	wsConn.Send(errorMessage(err, wsConn.Locale(), msg.RequestID))
+}
+
+// handleHello 处理握手：协商协议版本，没有共同版本时断开连接
+func (rm *RoomManager) handleHello(wsConn *WebSocketConn, msg Message) error {
+	var req HelloRequest
+	if err := decodeRequest(msg.Data, &req); err != nil {
+		return err
+	}
+
+	version, ok := negotiateVersion(req.Versions)
+	if !ok {
+		// 先排队错误消息再关闭，WritePump 会在关闭帧之前发出
+		rm.sendError(wsConn, msg, newError(ErrUnsupportedVersion, supportedVersions))
+		wsConn.CloseWithReason(websocket.CloseProtocolError, "协议版本不兼容")
+		return nil
+	}
+
+	wsConn.setVersion(version)
+	if locale, ok := parseLocale(req.Locale); ok {
+		wsConn.setLocale(locale)
+	}
+	wsConn.Send(Message{
+		Type: TypeHello,
+		Data: toJSON(HelloResponse{
+			Version:           version,
+			SupportedVersions: supportedVersions,
+		}),
+	})
+	return nil
+}
+
 // handleConnect 处理连接消息
-func (rm *RoomManager) handleConnect(wsConn *WebSocketConn, msg Message) {
-	var data struct {
-		PlayerID string `json:"playerId"`
-		Nickname string `json:"nickname"`
+func (rm *RoomManager) handleConnect(wsConn *WebSocketConn, msg Message) error {
+	var req ConnectRequest
+	if err := decodeRequest(msg.Data, &req); err != nil {
+		return err
 	}
 
-	if err := json.Unmarshal(msg.Data, &data); err != nil {
-		wsConn.Send(Message{
-			Type:  TypeError,
-			Error: "无效的数据格式",
-		})
-		return
+	nickname, err := normalizeNickname(req.Nickname)
+	if err != nil {
+		return err
+	}
+
+	// 只接受服务器签发的身份；一个连接验证后不能换成其他玩家
+	account, err := rm.accounts.authenticate(req.PlayerID, req.Token)
+	if err != nil {
+		return err
+	}
+	if current := wsConn.authenticated(); current != "" && current != account.PlayerID {
+		return newError(ErrForbidden)
+	}
+	wsConn.authenticate(account.PlayerID)
+
+	// 昵称为空时使用身份的昵称，否则记到身份上
+	if nickname == "" {
+		nickname = account.Nickname
+	} else {
+		rm.accounts.setNickname(account.PlayerID, nickname)
 	}
 
-	player := rm.GetPlayer(data.PlayerID)
+	// 大厅中的玩家记录只在 rm.mu 下修改；房间内的玩家状态由房间协程维护
+	rm.mu.Lock()
+	player := rm.players[account.PlayerID]
 	if player == nil {
-		player = NewPlayer(data.PlayerID, data.Nickname)
-		rm.mu.Lock()
-		rm.players[data.PlayerID] = player
-		rm.mu.Unlock()
+		player = NewPlayer(account.PlayerID, nickname)
+		rm.players[account.PlayerID] = player
 	} else {
-		player.Nickname = data.Nickname
-		player.Conn = wsConn
+		player.Nickname = nickname
+	}
+	resp := ConnectResponse{
+		PlayerID: player.ID,
+		Nickname: player.Nickname,
+		Username: account.Username,
+		Avatar:   account.Avatar,
+		Chips:    account.Chips,
+		Guest:    account.Guest,
 	}
+	rm.mu.Unlock()
 
 	wsConn.Send(Message{
 		Type: TypeConnect,
-		Data: toJSON(map[string]string{
-			"playerId": player.ID,
-			"nickname": player.Nickname,
-		}),
+		Data: toJSON(resp),
 	})
+	return nil
 }
 
 // handleJoin 处理加入房间
-func (rm *RoomManager) handleJoin(wsConn *WebSocketConn, msg Message) {
-	var data struct {
-		RoomID   string `json:"roomId"`
-		PlayerID string `json:"playerId"`
-		Nickname string `json:"nickname"`
+func (rm *RoomManager) handleJoin(wsConn *WebSocketConn, msg Message) error {
+	var req JoinRequest
+	if err := decodeRequest(msg.Data, &req); err != nil {
+		return err
 	}
 
-	if err := json.Unmarshal(msg.Data, &data); err != nil {
-		wsConn.Send(Message{
-			Type:  TypeError,
-			Error: "无效的数据格式",
-		})
-		return
+	if err := rm.authorize(wsConn, req.PlayerID); err != nil {
+		return err
 	}
 
 	// 获取房间
-	room := rm.GetRoom(data.RoomID)
+	room := rm.GetRoom(req.RoomID)
 	if room == nil {
-		wsConn.Send(Message{
-			Type:  TypeError,
-			Error: "房间不存在",
-		})
-		return
+		return newError(ErrRoomNotFound)
 	}
 
-	// 检查玩家是否已经在房间中
-	existingPlayer := room.GetPlayer(data.PlayerID)
-	if existingPlayer != nil {
-		// 玩家已存在，更新连接（处理刷新页面的情况）
-		existingPlayer.Conn = wsConn
-		existingPlayer.Nickname = data.Nickname
-		
-		// 发送房间信息
-		wsConn.Send(Message{
-			Type: TypeRoomInfo,
-			Data: toJSON(map[string]interface{}{
-				"roomId": room.ID,
-				"status": room.Status,
-			}),
-		})
+	nickname, err := normalizeNickname(req.Nickname)
+	if err != nil {
+		return err
+	}
 
-		// 广播玩家列表更新
-		rm.broadcastPlayers(room, data.PlayerID)
-		return
+	// 玩家已在房间中时换用新连接（处理刷新页面的情况），由房间推送房间信息和玩家列表
+	if room.Rejoin(req.PlayerID, nickname, wsConn) {
+		wsConn.bind(req.PlayerID, room.ID)
+		return nil
 	}
 
-	// 玩家不存在，尝试加入房间
-	room, err := rm.JoinRoom(data.RoomID, data.PlayerID, data.Nickname)
-	if err != nil {
-		wsConn.Send(Message{
-			Type:  TypeError,
-			Error: err.Error(),
-		})
-		return
+	// 玩家不存在，尝试加入房间（房间信息和玩家列表由 PlayerJoined 事件推送），昵称为空时使用身份的昵称
+	if nickname == "" {
+		nickname = rm.accounts.nickname(req.PlayerID)
 	}
+	if _, err := rm.JoinRoom(req.RoomID, req.PlayerID, nickname, wsConn); err != nil {
+		return err
+	}
+	wsConn.bind(req.PlayerID, req.RoomID)
+	return nil
+}
 
-	player := rm.GetPlayer(data.PlayerID)
-	player.Conn = wsConn
+// roomAction 解析房间内操作请求、检查身份并找到房间
+func (rm *RoomManager) roomAction(wsConn *WebSocketConn, msg Message) (*Room, *RoomActionRequest, error) {
+	var req RoomActionRequest
+	if err := decodeRequest(msg.Data, &req); err != nil {
+		return nil, nil, err
+	}
+	if err := rm.authorize(wsConn, req.PlayerID); err != nil {
+		return nil, nil, err
+	}
 
-	// 发送房间信息
-	wsConn.Send(Message{
-		Type: TypeRoomInfo,
-		Data: toJSON(map[string]interface{}{
-			"roomId": room.ID,
-			"status": room.Status,
-		}),
-	})
+	room := rm.GetRoom(req.RoomID)
+	if room == nil {
+		return nil, nil, newError(ErrRoomNotFound)
+	}
 
-	// 广播玩家列表更新
-	rm.broadcastPlayers(room, data.PlayerID)
+	return room, &req, nil
 }
 
 // handleStart 处理开始游戏
-func (rm *RoomManager) handleStart(wsConn *WebSocketConn, msg Message) {
-	var data struct {
-		RoomID   string `json:"roomId"`
-		PlayerID string `json:"playerId"`
+func (rm *RoomManager) handleStart(wsConn *WebSocketConn, msg Message) error {
+	room, req, err := rm.roomAction(wsConn, msg)
+	if err != nil {
+		return err
 	}
 
-	if err := json.Unmarshal(msg.Data, &data); err != nil {
-		return
+	// 服务器关闭期间不再开始新的一局
+	if rm.closing.Load() {
+		return newError(ErrServerClosing)
 	}
 
-	room := rm.GetRoom(data.RoomID)
-	if room == nil {
-		return
-	}
+	return room.StartGame(req.PlayerID, msg.RequestID)
+}
 
-	if err := room.StartGame(); err != nil {
-		wsConn.Send(Message{
-			Type:  TypeError,
-			Error: err.Error(),
-		})
-		return
+// handleHit 处理要牌
+func (rm *RoomManager) handleHit(wsConn *WebSocketConn, msg Message) error {
+	room, req, err := rm.roomAction(wsConn, msg)
+	if err != nil {
+		return err
 	}
 
-	// 广播游戏开始和玩家列表
-	room.Broadcast(Message{
-		Type: TypeStart,
-		Data: toJSON(map[string]string{
-			"roomId": room.ID,
-		}),
-	})
+	return room.PlayerHit(req.PlayerID, msg.RequestID)
+}
+
+// handleStand 处理停牌
+func (rm *RoomManager) handleStand(wsConn *WebSocketConn, msg Message) error {
+	room, req, err := rm.roomAction(wsConn, msg)
+	if err != nil {
+		return err
+	}
 
-	rm.broadcastPlayers(room, data.PlayerID)
+	return room.PlayerStand(req.PlayerID, msg.RequestID)
 }
 
-// handleHit 处理要牌
-func (rm *RoomManager) handleHit(wsConn *WebSocketConn, msg Message) {
-	var data struct {
-		RoomID   string `json:"roomId"`
-		PlayerID string `json:"playerId"`
+// handleLeave 处理离开房间（与 DELETE /api/room/{id} 相同），玩家不在房间中时回复 PLAYER_NOT_FOUND
+func (rm *RoomManager) handleLeave(wsConn *WebSocketConn, msg Message) error {
+	room, req, err := rm.roomAction(wsConn, msg)
+	if err != nil {
+		return err
+	}
+	if room.GetPlayer(req.PlayerID) == nil {
+		return newError(ErrPlayerNotFound)
 	}
 
-	if err := json.Unmarshal(msg.Data, &data); err != nil {
-		return
+	rm.LeaveRoom(room.ID, req.PlayerID)
+	return nil
+}
+
+// handleChat 处理聊天和私聊
+func (rm *RoomManager) handleChat(wsConn *WebSocketConn, msg Message) error {
+	var req ChatRequest
+	if err := decodeRequest(msg.Data, &req); err != nil {
+		return err
+	}
+	if err := rm.authorize(wsConn, req.PlayerID); err != nil {
+		return err
 	}
 
-	room := rm.GetRoom(data.RoomID)
+	room := rm.GetRoom(req.RoomID)
 	if room == nil {
-		return
+		return newError(ErrRoomNotFound)
 	}
 
-	if err := room.PlayerHit(data.PlayerID); err != nil {
-		wsConn.Send(Message{
-			Type:  TypeError,
-			Error: err.Error(),
-		})
-		return
+	text, err := rm.moderate(ChatContent{
+		RoomID:   req.RoomID,
+		PlayerID: req.PlayerID,
+		To:       req.To,
+		Text:     req.Message,
+	})
+	if err != nil {
+		return err
 	}
 
-	player := room.GetPlayer(data.PlayerID)
-	room.Broadcast(Message{
-		Type: TypeUpdate,
-		Data: toJSON(player.ToMap(false)),
-	})
+	return room.Chat(req.PlayerID, req.To, text)
+}
 
-	// 检查游戏是否结束
-	if room.CheckGameEnd() {
-		rm.handleGameEnd(room)
-	} else {
-		rm.broadcastPlayers(room, data.PlayerID)
+// handleEmote 处理快捷表情
+func (rm *RoomManager) handleEmote(wsConn *WebSocketConn, msg Message) error {
+	var req EmoteRequest
+	if err := decodeRequest(msg.Data, &req); err != nil {
+		return err
 	}
+	if err := rm.authorize(wsConn, req.PlayerID); err != nil {
+		return err
+	}
+
+	room := rm.GetRoom(req.RoomID)
+	if room == nil {
+		return newError(ErrRoomNotFound)
+	}
+
+	return room.Emote(req.PlayerID, req.Emote)
 }
 
-// handleStand 处理停牌
-func (rm *RoomManager) handleStand(wsConn *WebSocketConn, msg Message) {
-	var data struct {
-		RoomID   string `json:"roomId"`
-		PlayerID string `json:"playerId"`
+// handleResync 处理补发请求：客户端发现状态版本不连续时请求补发或完整快照
+func (rm *RoomManager) handleResync(wsConn *WebSocketConn, msg Message) error {
+	var req ResyncRequest
+	if err := decodeRequest(msg.Data, &req); err != nil {
+		return err
+	}
+	if err := rm.authorize(wsConn, req.PlayerID); err != nil {
+		return err
 	}
 
-	if err := json.Unmarshal(msg.Data, &data); err != nil {
-		return
+	room := rm.GetRoom(req.RoomID)
+	if room == nil {
+		return newError(ErrRoomNotFound)
+	}
+
+	return room.Resync(req.PlayerID, req.SinceVersion, wsConn)
+}
+
+// handleReplay 处理牌局回放请求（回放消息只发给请求的观众连接，回放期间观众也收到原房间的表情）
+// 牌局记录由服务器按房间和局数查找；连接必须已验证身份，每个连接同时只能有一个回放，
+// 服务器同时回放的数量不超过 maxConcurrentReplays。ack 表示回放已开始，回放结束后另外发送 replay 消息
+func (rm *RoomManager) handleReplay(wsConn *WebSocketConn, msg Message) error {
+	var req ReplayRequest
+	if err := decodeRequest(msg.Data, &req); err != nil {
+		return err
+	}
+	if wsConn.authenticated() == "" {
+		return newError(ErrNotAuthenticated)
 	}
 
-	room := rm.GetRoom(data.RoomID)
+	room := rm.GetRoom(req.RoomID)
 	if room == nil {
-		return
+		return newError(ErrRoomNotFound)
 	}
 
-	if err := room.PlayerStand(data.PlayerID); err != nil {
-		wsConn.Send(Message{
-			Type:  TypeError,
-			Error: err.Error(),
-		})
-		return
+	var history *HandHistory
+	for _, h := range room.Histories() {
+		if h.Round == req.Round {
+			history = h
+			break
+		}
+	}
+	if history == nil {
+		return newError(ErrHistoryNotFound)
 	}
 
-	player := room.GetPlayer(data.PlayerID)
-	room.Broadcast(Message{
-		Type: TypeUpdate,
-		Data: toJSON(player.ToMap(false)),
-	})
+	speed := 1.0
+	if req.Speed != nil {
+		speed = *req.Speed
+	}
 
-	// 检查游戏是否结束
-	if room.CheckGameEnd() {
-		rm.handleGameEnd(room)
-	} else {
-		rm.broadcastPlayers(room, data.PlayerID)
+	if !wsConn.replaying.CompareAndSwap(false, true) {
+		return newError(ErrTooManyReplays)
+	}
+	select {
+	case rm.replays <- struct{}{}:
+	default:
+		wsConn.replaying.Store(false)
+		return newError(ErrTooManyReplays)
 	}
+
+	go func() {
+		// 回放期间观众同时收到原房间里的表情
+		room.Watch(wsConn)
+		defer func() {
+			room.Unwatch(wsConn)
+			<-rm.replays
+			wsConn.replaying.Store(false)
+		}()
+
+		ctx := wsConn.Context()
+		err := NewReplayer(history, speed).Run(ctx, wsConn.Send)
+		if err != nil {
+			if ctx.Err() == nil {
+				rm.sendError(wsConn, msg, err)
+			}
+			return
+		}
+
+		wsConn.Send(Message{
+			Type:      TypeReplay,
+			RequestID: msg.RequestID,
+			Data: toJSON(ReplayResponse{
+				HandID: history.ID,
+				Status: "finished",
+			}),
+		})
+	}()
+	return nil
 }
 
-// handleChat 处理聊天
-func (rm *RoomManager) handleChat(wsConn *WebSocketConn, msg Message) {
-	var data struct {
-		RoomID   string `json:"roomId"`
-		PlayerID string `json:"playerId"`
-		Message  string `json:"message"`
+// errorMessage 构造错误消息，校验失败时附带字段错误
+func errorMessage(err error, locale Locale, requestID string) Message {
+	gerr := asGameError(err)
+	if gerr.Code == ErrInternal {
+		log.Printf("内部错误: %v", err)
 	}
 
-	if err := json.Unmarshal(msg.Data, &data); err != nil {
-		return
+	reply := Message{
+		Type:      TypeError,
+		RequestID: requestID,
+		Code:      gerr.Code,
+		Error:     gerr.Message(locale),
 	}
 
-	room := rm.GetRoom(data.RoomID)
-	if room == nil {
-		return
+	var verr *validationError
+	if errors.As(err, &verr) {
+		reply.Fields = localizeFields(verr.Fields, locale)
 	}
+	return reply
+}
 
-	player := room.GetPlayer(data.PlayerID)
-	if player == nil {
-		return
+// roomInfoMessage 构造房间信息消息（在房间协程中调用）
+func roomInfoMessage(room *Room) Message {
+	return Message{
+		Type: TypeRoomInfo,
+		Data: toJSON(RoomInfoResponse{
+			RoomID:  room.ID,
+			Status:  room.Status,
+			Version: room.version,
+		}),
 	}
+}
 
-	chatMsg := map[string]interface{}{
-		"playerId": player.ID,
-		"nickname": player.Nickname,
-		"message":  data.Message,
-		"time":     "now",
+// startMessage 构造游戏开始消息（在房间协程中调用）
+func startMessage(room *Room) Message {
+	return Message{
+		Type: TypeStart,
+		Data: toJSON(StartResponse{
+			RoomID: room.ID,
+		}),
 	}
-
-	room.Broadcast(Message{
-		Type: TypeChat,
-		Data: toJSON(chatMsg),
-	})
 }
 
-// handleGameEnd 处理游戏结束
-func (rm *RoomManager) handleGameEnd(room *Room) {
-	// 计算结果
-	results := make([]map[string]interface{}, 0)
-	maxScore := 0
-	winnerID := ""
+// cardDealtMessage 构造发牌消息：其他玩家只知道发了一张暗牌，玩家自己收到牌面和点数（在房间协程中调用）
+func cardDealtMessage(room *Room, player *Player, card Card) roomMessage {
+	dealt := CardDealtResponse{
+		Seat:      room.seatOf(player.ID),
+		PlayerID:  player.ID,
+		Card:      "pk-hide",
+		CardCount: len(player.Cards),
+	}
+	private := dealt
+	private.Card = card.String()
+	private.HandValue = player.HandValue
+	if !room.hideCards(player.ID, "") {
+		dealt = private
+	}
 
-	// 找出最高分（不超过21）
-	for _, player := range room.Players {
-		if player.Status != StatusBust && player.HandValue > maxScore {
-			maxScore = player.HandValue
-			winnerID = player.ID
-		}
+	return roomMessage{
+		Msg:     Message{Type: TypeCardDealt, Data: toJSON(dealt)},
+		Private: map[string]Message{player.ID: {Type: TypeCardDealt, Data: toJSON(private)}},
 	}
+}
 
-	// 生成结果
-	for _, player := range room.Players {
-		result := map[string]interface{}{
-			"playerId":  player.ID,
-			"nickname":  player.Nickname,
-			"score":     player.HandValue,
-			"status":    player.GetStatusString(),
-			"isWinner":  (player.ID == winnerID),
-		}
-		results = append(results, result)
+// statusChangedMessage 构造玩家状态变化消息，玩家仍在操作时不公开点数（在房间协程中调用）
+func statusChangedMessage(room *Room, player *Player) Message {
+	changed := StatusChangedResponse{
+		Seat:        room.seatOf(player.ID),
+		PlayerID:    player.ID,
+		Status:      player.GetStatusString(),
+		StatusColor: player.GetStatusColor(),
+		Online:      player.Conn != nil,
+	}
+	if player.Status != StatusActing || !room.hideCards(player.ID, "") {
+		changed.HandValue = player.HandValue
 	}
 
-	// 广播游戏结束
-	room.Broadcast(Message{
-		Type: TypeGameEnd,
-		Data: toJSON(map[string]interface{}{
-			"roomId":  room.ID,
-			"results": results,
-		}),
-	})
+	return Message{
+		Type: TypeStatusChanged,
+		Data: toJSON(changed),
+	}
 }
 
-// broadcastPlayers 广播玩家列表
-func (rm *RoomManager) broadcastPlayers(room *Room, excludeID string) {
-	players := room.GetPlayersList(excludeID)
-
-	room.Broadcast(Message{
+// playersMessage 构造玩家列表消息（在房间协程中调用）
+func playersMessage(room *Room, excludeID string) Message {
+	return Message{
 		Type: TypePlayers,
-		Data: toJSON(map[string]interface{}{
-			"players": players,
+		Data: toJSON(PlayersResponse{
+			Players: room.playersList(excludeID),
 		}),
-	})
+	}
+}
+
+// gameEndMessage 构造游戏结束消息（在房间协程中调用）
+func gameEndMessage(room *Room, results []RoundResult) Message {
+	return Message{
+		Type: TypeGameEnd,
+		Data: toJSON(GameEndResponse{
+			RoomID:  room.ID,
+			Results: results,
+		}),
+	}
 }
 
 // generateRoomID 生成房间ID
-func generateRoomID() string {
-	return fmt.Sprintf("%d", 10000+rand.Intn(90000))
+func generateRoomID(rng RandomSource) string {
+	return fmt.Sprintf("%d", 10000+rng.Intn(90000))
+}
+
+// generatePlayerID 生成玩家ID
+func generatePlayerID(rng RandomSource) string {
+	return "player_" + randomString(rng, "abcdefghijklmnopqrstuvwxyz0123456789", 16)
 }
 
 // toJSON 将对象转换为JSON字节数组
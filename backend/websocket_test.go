@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestConn 没有底层网络连接的连接：Send 的消息留在发送缓冲区中，由 received 取出
+func newTestConn(version int) *WebSocketConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebSocketConn{
+		send:    make(chan Message, sendBufferSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		version: version,
+		locale:  DefaultLocale,
+		codec:   jsonCodec{},
+		flood:   newFloodGuard(time.Now()),
+	}
+}
+
+// received 取出连接发送缓冲区中的全部消息
+func received(conn *WebSocketConn) []Message {
+	var msgs []Message
+	for {
+		select {
+		case msg := <-conn.send:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+// dialTestConn 启动测试服务器，把升级后的连接交给 serve，返回客户端连接
+func dialTestConn(t *testing.T, serve func(wsc *WebSocketConn)) *websocket.Conn {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serve(NewWebSocketConn(conn))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return client
+}
+
+// readUntilClose 读取消息直到收到关闭帧，返回消息数和关闭码
+func readUntilClose(t *testing.T, client *websocket.Conn) (int, int) {
+	t.Helper()
+
+	count := 0
+	for {
+		_, _, err := client.ReadMessage()
+		if err == nil {
+			count++
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("没有收到关闭帧: %v", err)
+		}
+		return count, closeErr.Code
+	}
+}
+
+// TestWebSocketConnSlowConsumer 发送缓冲区满时以 1008 关闭连接，已排队的消息在关闭帧之前发出
+func TestWebSocketConnSlowConsumer(t *testing.T) {
+	client := dialTestConn(t, func(wsc *WebSocketConn) {
+		// 写入协程启动前缓冲区就会被填满
+		for i := 0; i < sendBufferSize+10; i++ {
+			wsc.Send(Message{Type: TypeAck})
+		}
+		if !wsc.IsClosed() {
+			t.Error("缓冲区满后连接没有关闭")
+		}
+		go wsc.WritePump()
+		go wsc.ReadPump(func(Message) {})
+	})
+
+	count, code := readUntilClose(t, client)
+	if code != websocket.ClosePolicyViolation {
+		t.Errorf("关闭码 = %d, want %d", code, websocket.ClosePolicyViolation)
+	}
+	if count != sendBufferSize {
+		t.Errorf("收到 %d 条消息, want %d", count, sendBufferSize)
+	}
+}
+
+// TestWebSocketConnConcurrentSendClose 多个协程同时发送和关闭：不死锁、不向已关闭的通道发送，关闭码以第一次为准
+func TestWebSocketConnConcurrentSendClose(t *testing.T) {
+	done := make(chan struct{})
+	client := dialTestConn(t, func(wsc *WebSocketConn) {
+		go wsc.WritePump()
+		go wsc.ReadPump(func(Message) {})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					wsc.Send(Message{Type: TypeAck})
+				}
+			}()
+		}
+		wsc.CloseWithReason(websocket.CloseGoingAway, "服务器关闭")
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				wsc.Close()
+				wsc.CloseWithReason(websocket.ClosePolicyViolation, "")
+			}()
+		}
+		wg.Wait()
+		wsc.Send(Message{Type: TypeAck}) // 关闭后直接丢弃
+
+		select {
+		case <-wsc.Context().Done():
+		default:
+			t.Error("Close 后上下文没有取消")
+		}
+		close(done)
+	})
+
+	_, code := readUntilClose(t, client)
+	if code != websocket.CloseGoingAway {
+		t.Errorf("关闭码 = %d, want %d", code, websocket.CloseGoingAway)
+	}
+	<-done
+}
+
+// TestWebSocketConnPeerClose 对端关闭时读写协程都退出并取消上下文
+func TestWebSocketConnPeerClose(t *testing.T) {
+	conns := make(chan *WebSocketConn, 1)
+	pumps := make(chan struct{}, 2)
+	client := dialTestConn(t, func(wsc *WebSocketConn) {
+		go func() { wsc.WritePump(); pumps <- struct{}{} }()
+		go func() { wsc.ReadPump(func(Message) {}); pumps <- struct{}{} }()
+		conns <- wsc
+	})
+	wsc := <-conns
+
+	client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	client.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pumps:
+		case <-time.After(5 * time.Second):
+			t.Fatal("读写协程没有退出")
+		}
+	}
+	if !wsc.IsClosed() {
+		t.Error("对端关闭后连接没有标记为关闭")
+	}
+	wsc.Send(Message{Type: TypeAck}) // 不阻塞、不 panic
+}
+
+// TestWebSocketConnMessages 消息按连接的编码收发，解码失败时以 1007 关闭
+func TestWebSocketConnMessages(t *testing.T) {
+	handled := make(chan Message, 1)
+	client := dialTestConn(t, func(wsc *WebSocketConn) {
+		go wsc.WritePump()
+		go wsc.ReadPump(func(msg Message) {
+			handled <- msg
+			wsc.Send(Message{Type: TypeAck, RequestID: msg.RequestID})
+		})
+	})
+
+	if err := client.WriteMessage(websocket.TextMessage, []byte(`{"type":"hit","requestId":"r1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-handled; msg.Type != TypeHit || msg.RequestID != "r1" {
+		t.Errorf("收到 %+v", msg)
+	}
+	var reply Message
+	if err := client.ReadJSON(&reply); err != nil || reply.Type != TypeAck || reply.RequestID != "r1" {
+		t.Errorf("回复 = %+v, %v", reply, err)
+	}
+
+	if err := client.WriteMessage(websocket.TextMessage, []byte(`{not json`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, code := readUntilClose(t, client); code != websocket.CloseInvalidFramePayloadData {
+		t.Errorf("关闭码 = %d, want %d", code, websocket.CloseInvalidFramePayloadData)
+	}
+}
+
+// testClient 通过 handleMessage 收发消息的已验证客户端
+type testClient struct {
+	rm       *RoomManager
+	conn     *WebSocketConn
+	playerID string
+}
+
+// newTestClient 签发游客身份并在新连接上 connect
+func newTestClient(t *testing.T, rm *RoomManager, nickname string) *testClient {
+	t.Helper()
+
+	account, token, err := rm.accounts.createGuest(rm.rng, nickname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{rm: rm, conn: newTestConn(ProtocolVersion), playerID: account.PlayerID}
+	c.send(TypeConnect, "", ConnectRequest{PlayerID: account.PlayerID, Token: token})
+	if got := received(c.conn); len(got) != 1 || got[0].Type != TypeConnect {
+		t.Fatalf("connect 回复 %v", messageTypes(got))
+	}
+	return c
+}
+
+// send 发送一条消息，返回之后回复已经在连接的发送缓冲区中
+func (c *testClient) send(typ MessageType, requestID string, data interface{}) {
+	c.rm.handleMessage(c.conn, Message{Type: typ, RequestID: requestID, Data: toJSON(data)})
+}
+
+// reply 取出发给 requestID 的回复（ack 或 error）
+func (c *testClient) reply(t *testing.T, requestID string) Message {
+	t.Helper()
+
+	var replies []Message
+	for _, msg := range received(c.conn) {
+		if msg.RequestID == requestID && (msg.Type == TypeAck || msg.Type == TypeError) {
+			replies = append(replies, msg)
+		}
+	}
+	if len(replies) != 1 {
+		t.Fatalf("请求 %s 收到 %d 条回复", requestID, len(replies))
+	}
+	return replies[0]
+}
+
+// TestHandleMessageDuplicateRequests 重发的请求回复 duplicate 的 ack，不会再执行
+func TestHandleMessageDuplicateRequests(t *testing.T) {
+	rm := NewRoomManager(NewSeededRandomSource(1))
+	room, err := rm.CreateRoom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(room.Stop)
+
+	a, b := newTestClient(t, rm, "小明"), newTestClient(t, rm, "小红")
+	for _, c := range []*testClient{a, b} {
+		c.send(TypeJoin, "", JoinRequest{RoomID: room.ID, PlayerID: c.playerID})
+	}
+	action := func(c *testClient) RoomActionRequest { return RoomActionRequest{RoomID: room.ID, PlayerID: c.playerID} }
+
+	tests := []struct {
+		name      string
+		client    *testClient
+		typ       MessageType
+		requestID string
+		duplicate bool
+		cards     int
+	}{
+		{name: "开局", client: a, typ: TypeStart, requestID: "start-1", cards: 2},
+		{name: "重发开局", client: a, typ: TypeStart, requestID: "start-1", duplicate: true, cards: 2},
+		{name: "要牌", client: a, typ: TypeHit, requestID: "hit-1", cards: 3},
+		{name: "重发要牌", client: a, typ: TypeHit, requestID: "hit-1", duplicate: true, cards: 3},
+		{name: "另一个玩家用相同的 requestId", client: b, typ: TypeHit, requestID: "hit-1", cards: 3},
+		{name: "新的要牌请求", client: a, typ: TypeHit, requestID: "hit-2", cards: 4},
+	}
+
+	for _, tt := range tests {
+		received(a.conn)
+		received(b.conn)
+		tt.client.send(tt.typ, tt.requestID, action(tt.client))
+
+		reply := tt.client.reply(t, tt.requestID)
+		var ack AckResponse
+		if reply.Type != TypeAck || json.Unmarshal(reply.Data, &ack) != nil {
+			t.Fatalf("%s: 回复 %s %s", tt.name, reply.Type, reply.Data)
+		}
+		if ack.Type != tt.typ || ack.Duplicate != tt.duplicate {
+			t.Errorf("%s: ack = %+v, want duplicate=%v", tt.name, ack, tt.duplicate)
+		}
+		if got := len(room.GetPlayer(tt.client.playerID).Cards); got != tt.cards {
+			t.Errorf("%s: 有 %d 张牌, want %d", tt.name, got, tt.cards)
+		}
+	}
+}
+
+// TestHandleMessageLeave leave 让玩家离开房间，最后一个玩家离开后删除房间
+func TestHandleMessageLeave(t *testing.T) {
+	rm := NewRoomManager(NewSeededRandomSource(1))
+	room, err := rm.CreateRoom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(room.Stop)
+
+	a, b := newTestClient(t, rm, "小明"), newTestClient(t, rm, "小红")
+	for _, c := range []*testClient{a, b} {
+		c.send(TypeJoin, "", JoinRequest{RoomID: room.ID, PlayerID: c.playerID})
+	}
+
+	tests := []struct {
+		name    string
+		client  *testClient
+		player  string
+		want    ErrorCode
+		players int
+	}{
+		{name: "不能让别人离开", client: a, player: b.playerID, want: ErrForbidden, players: 2},
+		{name: "离开", client: a, player: a.playerID, players: 1},
+		{name: "已经不在房间", client: a, player: a.playerID, want: ErrPlayerNotFound, players: 1},
+		{name: "最后一个玩家离开", client: b, player: b.playerID},
+	}
+
+	for i, tt := range tests {
+		requestID := "leave-" + strconv.Itoa(i)
+		tt.client.send(TypeLeave, requestID, RoomActionRequest{RoomID: room.ID, PlayerID: tt.player})
+
+		reply := tt.client.reply(t, requestID)
+		if tt.want == "" && reply.Type != TypeAck || tt.want != "" && reply.Code != tt.want {
+			t.Errorf("%s: 回复 %s %s %s, want %q", tt.name, reply.Type, reply.Code, reply.Data, tt.want)
+		}
+		if tt.players > 0 && room.PlayerCount() != tt.players {
+			t.Errorf("%s: 房间有 %d 个玩家, want %d", tt.name, room.PlayerCount(), tt.players)
+		}
+	}
+	if rm.GetRoom(room.ID) != nil {
+		t.Error("所有玩家离开后房间没有删除")
+	}
+}